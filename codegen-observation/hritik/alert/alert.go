@@ -0,0 +1,190 @@
+package v1
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	e "github.com/nutanix-core/nai-api/common/errors"
+	"github.com/nutanix-core/nai-api/common/logger"
+	"github.com/nutanix-core/nai-api/common/response"
+	"github.com/nutanix-core/nai-api/iep/internal/client"
+	auth "github.com/nutanix-core/nai-api/iep/internal/middleware"
+)
+
+// AlertController struct
+type AlertController struct {
+	v1Route        *gin.RouterGroup
+	logger         logger.Logger
+	validator      *validator.Validate
+	notifier       client.IAlertNotifier
+	authMiddleware auth.IAuthenticationMiddleware
+}
+
+// NewAlertController creates and initiates the route for managing health alert notifications
+func NewAlertController(v1Route *gin.RouterGroup, logger logger.Logger, validator *validator.Validate, notifier client.IAlertNotifier, authMiddleware auth.IAuthenticationMiddleware) *AlertController {
+	controller := &AlertController{v1Route: v1Route, logger: logger, validator: validator, notifier: notifier, authMiddleware: authMiddleware}
+	controller.route()
+	return controller
+}
+
+// Route requests to the correct function for managing health alert notifications, only admins receive alerts
+func (ac *AlertController) route() {
+	route := ac.v1Route.Group("/alerts", ac.authMiddleware.ValidateAccessToken(auth.AllowAdmin))
+	route.POST("/receivers", ac.CreateReceiver)
+	route.GET("/receivers", ac.ListReceivers)
+	route.DELETE("/receivers/:receiver_id", ac.DeleteReceiver)
+	route.GET("/deliveries", ac.ListDeliveries)
+}
+
+// CreateReceiver godoc
+//
+//	@Summary		createReceiver
+//	@Description	register a webhook receiver notified when endpoints change health status
+//	@Tags			alerts
+//	@Accept			json
+//	@Produce		json
+//	@Param			WebhookReceiver	body		client.WebhookReceiver													true	"webhook receiver object, the secret signs the webhooks when set"
+//	@Param			Authorization	header		string																	true	"access token sent via headers"
+//	@Success		200				{object}	response.HTTPSuccessWithDataResponseModel{data=client.WebhookReceiver}	"success response"
+//	@Failure		400				{object}	response.HTTPFailureResponseModel										"bad request response"
+//	@Failure		401				{object}	response.HTTPFailureResponseModel										"unauthorized response"
+//	@Failure		403				{object}	response.HTTPFailureResponseModel										"forbidden response"
+//	@Failure		500				{object}	response.HTTPFailureResponseModel										"internal server error response"
+//	@Router			/v1/alerts/receivers [post]
+func (ac *AlertController) CreateReceiver(c *gin.Context) {
+	errMsg := "Failed to create webhook receiver"
+	succMsg := "Webhook receiver created successfully"
+
+	var receiver client.WebhookReceiver
+	if err := c.ShouldBindJSON(&receiver); err != nil {
+		response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ac.logger, Err: &e.Error{Type: e.BindingError, InternalErr: err, Msg: errMsg}})
+		return
+	}
+	if err := ac.validator.Struct(receiver); err != nil {
+		response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ac.logger, Err: &e.Error{Type: e.ValidationError, InternalErr: err, Msg: errMsg}})
+		return
+	}
+
+	created, addErr := ac.notifier.AddReceiver(receiver)
+	if addErr != nil {
+		errType := e.GenericError
+		if errors.Is(addErr, client.ErrInvalidReceiver) {
+			errType = e.ValidationError
+		}
+		err := &e.Error{Type: errType, InternalErr: addErr, Msg: fmt.Sprintf("%s: %s", errMsg, addErr)}
+		response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ac.logger, Err: err})
+		return
+	}
+	response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ac.logger, SuccMsg: succMsg, Data: created})
+}
+
+// ListReceivers godoc
+//
+//	@Summary		listReceivers
+//	@Description	list the webhook receivers, their secrets are never returned and the query of their urls is redacted
+//	@Tags			alerts
+//	@Produce		json
+//	@Param			Authorization	header		string																		true	"access token sent via headers"
+//	@Success		200				{object}	response.HTTPSuccessWithDataResponseModel{data=[]client.WebhookReceiver}	"success response"
+//	@Failure		401				{object}	response.HTTPFailureResponseModel											"unauthorized response"
+//	@Failure		403				{object}	response.HTTPFailureResponseModel											"forbidden response"
+//	@Failure		500				{object}	response.HTTPFailureResponseModel											"internal server error response"
+//	@Router			/v1/alerts/receivers [get]
+func (ac *AlertController) ListReceivers(c *gin.Context) {
+	succMsg := "Webhook receivers fetched successfully"
+	errMsg := "Failed to list webhook receivers"
+	receivers, listErr := ac.notifier.Receivers()
+	if listErr != nil {
+		err := &e.Error{Type: e.GenericError, InternalErr: listErr, Msg: errMsg}
+		response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ac.logger, Err: err})
+		return
+	}
+	response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ac.logger, SuccMsg: succMsg, Data: receivers})
+}
+
+// DeleteReceiver godoc
+//
+//	@Summary		deleteReceiver
+//	@Description	delete a webhook receiver, its delivery log is kept
+//	@Tags			alerts
+//	@Produce		json
+//	@Param			receiver_id		path		string								true	"receiver id"
+//	@Param			Authorization	header		string								true	"access token sent via headers"
+//	@Success		200				{object}	response.HTTPSuccessResponseModel	"success response"
+//	@Failure		401				{object}	response.HTTPFailureResponseModel	"unauthorized response"
+//	@Failure		403				{object}	response.HTTPFailureResponseModel	"forbidden response"
+//	@Failure		404				{object}	response.HTTPFailureResponseModel	"not found response"
+//	@Failure		500				{object}	response.HTTPFailureResponseModel	"internal server error response"
+//	@Router			/v1/alerts/receivers/{receiver_id} [delete]
+func (ac *AlertController) DeleteReceiver(c *gin.Context) {
+	receiverID := c.Param("receiver_id")
+	succMsg := "Webhook receiver deleted successfully"
+	errMsg := "Failed to delete webhook receiver"
+	removed, removeErr := ac.notifier.RemoveReceiver(receiverID)
+	if removeErr != nil {
+		err := &e.Error{Type: e.GenericError, InternalErr: removeErr, Msg: errMsg}
+		response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ac.logger, Err: err})
+		return
+	}
+	if !removed {
+		msg := fmt.Sprintf("webhook receiver %s not found", receiverID)
+		err := &e.Error{Type: e.NotFoundError, Msg: errMsg + ": " + msg, Log: msg}
+		response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ac.logger, Err: err})
+		return
+	}
+	response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ac.logger, SuccMsg: succMsg})
+}
+
+// ListDeliveries godoc
+//
+//	@Summary		listDeliveries
+//	@Description	list the most recent webhook deliveries first
+//	@Tags			alerts
+//	@Produce		json
+//	@Param			receiver_id		query		string																	false	"receiver id for which the deliveries have to be returned"
+//	@Param			endpoint_id		query		string																	false	"endpoint id for which the deliveries have to be returned"
+//	@Param			state			query		string																	false	"delivery state, one of pending, delivered, failed, dropped or suppressed"
+//	@Param			limit			query		int																		false	"maximum number of deliveries returned"
+//	@Param			Authorization	header		string																	true	"access token sent via headers"
+//	@Success		200				{object}	response.HTTPSuccessWithDataResponseModel{data=[]client.AlertDelivery}	"success response"
+//	@Failure		400				{object}	response.HTTPFailureResponseModel										"bad request response"
+//	@Failure		401				{object}	response.HTTPFailureResponseModel										"unauthorized response"
+//	@Failure		403				{object}	response.HTTPFailureResponseModel										"forbidden response"
+//	@Failure		500				{object}	response.HTTPFailureResponseModel										"internal server error response"
+//	@Router			/v1/alerts/deliveries [get]
+func (ac *AlertController) ListDeliveries(c *gin.Context) {
+	succMsg := "Webhook deliveries fetched successfully"
+	errMsg := "Failed to list webhook deliveries"
+	filter := client.DeliveryFilter{
+		ReceiverID: c.Query("receiver_id"),
+		EndpointID: c.Query("endpoint_id"),
+		State:      client.DeliveryState(c.Query("state")),
+	}
+	switch filter.State {
+	case "", client.DeliveryPending, client.DeliveryDelivered, client.DeliveryFailed, client.DeliveryDropped, client.DeliverySuppressed:
+	default:
+		msg := fmt.Sprintf("invalid delivery state %s", filter.State)
+		err := &e.Error{Type: e.ValidationError, Msg: errMsg + ": " + msg, Log: msg}
+		response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ac.logger, Err: err})
+		return
+	}
+	if limit := c.Query("limit"); limit != "" {
+		var parseErr error
+		filter.Limit, parseErr = strconv.Atoi(limit)
+		if parseErr != nil || filter.Limit < 0 {
+			err := &e.Error{Type: e.ParsingError, InternalErr: parseErr, Msg: errMsg + ": limit must be a positive number"}
+			response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ac.logger, Err: err})
+			return
+		}
+	}
+	deliveries, listErr := ac.notifier.Deliveries(filter)
+	if listErr != nil {
+		err := &e.Error{Type: e.GenericError, InternalErr: listErr, Msg: errMsg}
+		response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ac.logger, Err: err})
+		return
+	}
+	response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ac.logger, SuccMsg: succMsg, Data: deliveries})
+}
@@ -0,0 +1,163 @@
+package v1_test
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nutanix-core/nai-api/common/logger"
+	v1 "github.com/nutanix-core/nai-api/iep/api/v1"
+	"github.com/nutanix-core/nai-api/iep/internal/client"
+	auth "github.com/nutanix-core/nai-api/iep/internal/middleware"
+	naivalidator "github.com/nutanix-core/nai-api/iep/internal/validator"
+	mock_middleware "github.com/nutanix-core/nai-api/iep/mocks/middleware"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.uber.org/mock/gomock"
+)
+
+var _ = Describe("Test Alert Controller", func() {
+
+	var (
+		mockCtrl               *gomock.Controller
+		mockAuthService        *mock_middleware.MockIAuthenticationMiddleware
+		notifier               client.IAlertNotifier
+		logger                 = logger.NewZAPLogger()
+		alertValidator         = naivalidator.NewValidator(logger)
+		correctReceiverRequest = `
+			{
+				"name": "ops",
+				"url": "https://hooks.example.com/nai",
+				"secret": "s3cr3t",
+				"severities": ["critical"],
+				"repeatIntervalSeconds": 3600
+			}`
+		invalidReceiverRequest = `
+			{
+				"name": "ops",
+				"url": "not a url"
+			}`
+		unknownSeverityRequest = `
+			{
+				"name": "ops",
+				"url": "https://hooks.example.com/nai",
+				"severities": ["fatal"]
+			}`
+		wrongReceiverRequest = `
+			{
+				"name": "ops",
+				"url": "https://hooks.example.com/nai",
+			}`
+	)
+
+	BeforeEach(func() {
+		gin.SetMode(gin.TestMode)
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockAuthService = mock_middleware.NewMockIAuthenticationMiddleware(mockCtrl)
+		notifier = client.NewAlertNotifier(client.NewClient(), client.AlertNotifierConfig{})
+		validateAccessTokenHandler := func(c *gin.Context) {
+			c.Next()
+		}
+		mockAuthService.EXPECT().ValidateAccessToken(auth.AllowAdmin).Return(gin.HandlerFunc(validateAccessTokenHandler)).Times(1)
+	})
+
+	Context("Test Create Receiver Request", func() {
+		It("Create Receiver Successful", func() {
+			validContext, router := getContext("v1/alerts/receivers", correctReceiverRequest, "POST")
+			testAlertController := v1.NewAlertController(router.Group("/v1"), logger, alertValidator, notifier, mockAuthService)
+			testAlertController.CreateReceiver(validContext)
+			Expect(validContext.IsAborted()).Should(BeFalse())
+			Expect(validContext.Writer.Status()).Should(Equal(http.StatusOK))
+			Expect(notifier.Receivers()).To(ConsistOf(HaveField("Signed", true)))
+		})
+
+		It("Create Receiver unsuccessful: Binding error", func() {
+			validContext, router := getContext("v1/alerts/receivers", wrongReceiverRequest, "POST")
+			testAlertController := v1.NewAlertController(router.Group("/v1"), logger, alertValidator, notifier, mockAuthService)
+			testAlertController.CreateReceiver(validContext)
+			Expect(validContext.IsAborted()).Should(BeTrue())
+			Expect(validContext.Writer.Status()).Should(Equal(http.StatusBadRequest))
+		})
+
+		It("Create Receiver unsuccessful: Validation error", func() {
+			validContext, router := getContext("v1/alerts/receivers", invalidReceiverRequest, "POST")
+			testAlertController := v1.NewAlertController(router.Group("/v1"), logger, alertValidator, notifier, mockAuthService)
+			testAlertController.CreateReceiver(validContext)
+			Expect(validContext.IsAborted()).Should(BeTrue())
+			Expect(validContext.Writer.Status()).Should(Equal(http.StatusBadRequest))
+			Expect(notifier.Receivers()).To(BeEmpty())
+		})
+
+		It("Create Receiver unsuccessful: unknown severity", func() {
+			validContext, router := getContext("v1/alerts/receivers", unknownSeverityRequest, "POST")
+			testAlertController := v1.NewAlertController(router.Group("/v1"), logger, alertValidator, notifier, mockAuthService)
+			testAlertController.CreateReceiver(validContext)
+			Expect(validContext.IsAborted()).Should(BeTrue())
+			Expect(validContext.Writer.Status()).Should(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Context("Test List Receivers Request", func() {
+		It("List Receivers Successful", func() {
+			_, err := notifier.AddReceiver(client.WebhookReceiver{Name: "ops", URL: "https://hooks.example.com/nai"})
+			Expect(err).ToNot(HaveOccurred())
+			validContext, router := getContext("v1/alerts/receivers", "", "GET")
+			testAlertController := v1.NewAlertController(router.Group("/v1"), logger, alertValidator, notifier, mockAuthService)
+			testAlertController.ListReceivers(validContext)
+			Expect(validContext.IsAborted()).Should(BeFalse())
+			Expect(validContext.Writer.Status()).Should(Equal(http.StatusOK))
+		})
+	})
+
+	Context("Test Delete Receiver Request", func() {
+		It("Delete Receiver Successful", func() {
+			receiver, err := notifier.AddReceiver(client.WebhookReceiver{Name: "ops", URL: "https://hooks.example.com/nai"})
+			Expect(err).ToNot(HaveOccurred())
+			validContext, router := getContext("v1/alerts/receivers/", "", "DELETE")
+			validContext.Params = append(validContext.Params, gin.Param{Key: "receiver_id", Value: receiver.ID})
+			testAlertController := v1.NewAlertController(router.Group("/v1"), logger, alertValidator, notifier, mockAuthService)
+			testAlertController.DeleteReceiver(validContext)
+			Expect(validContext.IsAborted()).Should(BeFalse())
+			Expect(validContext.Writer.Status()).Should(Equal(http.StatusOK))
+			Expect(notifier.Receivers()).To(BeEmpty())
+		})
+
+		It("Delete Receiver unsuccessful: receiver not found", func() {
+			validContext, router := getContext("v1/alerts/receivers/", "", "DELETE")
+			validContext.Params = append(validContext.Params, gin.Param{Key: "receiver_id", Value: "unknown"})
+			testAlertController := v1.NewAlertController(router.Group("/v1"), logger, alertValidator, notifier, mockAuthService)
+			testAlertController.DeleteReceiver(validContext)
+			Expect(validContext.IsAborted()).Should(BeTrue())
+			Expect(validContext.Writer.Status()).Should(Equal(http.StatusNotFound))
+		})
+	})
+
+	Context("Test List Deliveries Request", func() {
+		It("List Deliveries Successful", func() {
+			_, err := notifier.AddReceiver(client.WebhookReceiver{Name: "ops", URL: "https://hooks.example.com/nai"})
+			Expect(err).ToNot(HaveOccurred())
+			notifier.Notify(client.HealthTransition{EndpointID: "endpoint-1", From: client.StateDegraded, To: client.StateCritical, At: time.Now()})
+			validContext, router := getContext("v1/alerts/deliveries?endpoint_id=endpoint-1&state=pending&limit=10", "", "GET")
+			testAlertController := v1.NewAlertController(router.Group("/v1"), logger, alertValidator, notifier, mockAuthService)
+			testAlertController.ListDeliveries(validContext)
+			Expect(validContext.IsAborted()).Should(BeFalse())
+			Expect(validContext.Writer.Status()).Should(Equal(http.StatusOK))
+		})
+
+		It("List Deliveries unsuccessful: invalid state", func() {
+			validContext, router := getContext("v1/alerts/deliveries?state=lost", "", "GET")
+			testAlertController := v1.NewAlertController(router.Group("/v1"), logger, alertValidator, notifier, mockAuthService)
+			testAlertController.ListDeliveries(validContext)
+			Expect(validContext.IsAborted()).Should(BeTrue())
+			Expect(validContext.Writer.Status()).Should(Equal(http.StatusBadRequest))
+		})
+
+		It("List Deliveries unsuccessful: invalid limit", func() {
+			validContext, router := getContext("v1/alerts/deliveries?limit=ten", "", "GET")
+			testAlertController := v1.NewAlertController(router.Group("/v1"), logger, alertValidator, notifier, mockAuthService)
+			testAlertController.ListDeliveries(validContext)
+			Expect(validContext.IsAborted()).Should(BeTrue())
+			Expect(validContext.Writer.Status()).Should(Equal(http.StatusBadRequest))
+		})
+	})
+})
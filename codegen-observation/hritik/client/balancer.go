@@ -0,0 +1,368 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultMaxBackendFailures is the number of consecutive failures ejecting a backend
+	DefaultMaxBackendFailures = 3
+	// DefaultEjectionTimeout is the time an ejected backend waits before being checked for re-admission
+	DefaultEjectionTimeout = 30 * time.Second
+)
+
+// ErrNoBackend is matched by errors returned when every backend of a target is ejected or was tried
+var ErrNoBackend = errors.New("no backend available")
+
+// BalancingStrategy selects the backend of a request among the available backends of a target
+type BalancingStrategy int
+
+const (
+	// RoundRobin selects the backends in turn
+	RoundRobin BalancingStrategy = iota
+	// LeastInFlight selects the backend with the fewest requests in flight
+	LeastInFlight
+	// ConsistentHash selects the same backend for the same hash key as long as it is available
+	ConsistentHash
+)
+
+// Target is a named destination reachable through several base URLs
+type Target struct {
+	// Name is matched against the host of request urls, as in http://<name>/v1/models
+	Name     string
+	BaseURLs []string
+	Strategy BalancingStrategy
+	// HashKey returns the key of a request for ConsistentHash, the url path by default
+	HashKey func(*http.Request) string
+	// HealthPath is appended to a base URL to check an ejected backend before re-admitting it
+	HealthPath string
+}
+
+// BalancerConfig configures a balanced client
+type BalancerConfig struct {
+	Targets []Target
+	// MaxFailures is the number of consecutive failures ejecting a backend, DefaultMaxBackendFailures by default
+	MaxFailures int
+	// EjectionTimeout is the time before an ejected backend is checked for re-admission, DefaultEjectionTimeout by default
+	EjectionTimeout time.Duration
+	// HealthClient checks ejected backends once the ejection timeout elapsed and re-admits the healthy ones.
+	// Without it backends are re-admitted once the ejection timeout elapsed.
+	HealthClient IHealthClient
+	// IsFailure tells whether a request outcome counts as a backend failure, DefaultCircuitFailureClassifier by default
+	IsFailure func(resp *http.Response, err error) bool
+}
+
+// BackendStatus is the state of a backend of a target
+type BackendStatus struct {
+	BaseURL  string
+	Ejected  bool
+	InFlight int
+}
+
+// IBalancedClient is an IClient spreading the requests to named targets over their backends
+type IBalancedClient interface {
+	IClient
+	// Backends returns the state of the backends of target
+	Backends(target string) []BackendStatus
+}
+
+// balancedClient wraps an IClient rewriting requests to a target to one of its backends
+type balancedClient struct {
+	next    IClient
+	config  BalancerConfig
+	mu      sync.Mutex
+	targets map[string]*balancedTarget
+}
+
+type balancedTarget struct {
+	Target
+	backends []*backend
+	next     int
+}
+
+type backend struct {
+	baseURL   *url.URL
+	inFlight  int
+	failures  int
+	ejected   bool
+	ejectedAt time.Time
+	checking  bool
+}
+
+// NewBalancedClient returns an IClient sending the requests whose host is a target name to the backends of the target.
+// A request failing without response is sent to another backend when it can be replayed and is idempotent or was refused.
+// Requests to other hosts are sent unchanged.
+func NewBalancedClient(next IClient, config BalancerConfig) (IBalancedClient, error) {
+	if config.MaxFailures <= 0 {
+		config.MaxFailures = DefaultMaxBackendFailures
+	}
+	if config.EjectionTimeout <= 0 {
+		config.EjectionTimeout = DefaultEjectionTimeout
+	}
+	if config.IsFailure == nil {
+		config.IsFailure = DefaultCircuitFailureClassifier
+	}
+	targets := make(map[string]*balancedTarget, len(config.Targets))
+	for _, target := range config.Targets {
+		if len(target.BaseURLs) == 0 {
+			return nil, fmt.Errorf("target %s has no base URL", target.Name)
+		}
+		if target.HashKey == nil {
+			target.HashKey = func(req *http.Request) string {
+				return req.URL.Path
+			}
+		}
+		balanced := &balancedTarget{Target: target}
+		for _, baseURL := range target.BaseURLs {
+			parsed, err := url.Parse(baseURL)
+			if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+				return nil, fmt.Errorf("invalid base URL %q for target %s", baseURL, target.Name)
+			}
+			balanced.backends = append(balanced.backends, &backend{baseURL: parsed})
+		}
+		targets[target.Name] = balanced
+	}
+	return &balancedClient{next: next, config: config, targets: targets}, nil
+}
+
+func (b *balancedClient) SetTimeout(timeout time.Duration) {
+	b.next.SetTimeout(timeout)
+}
+
+func (b *balancedClient) GetTimeout() time.Duration {
+	return b.next.GetTimeout()
+}
+
+// Do makes the http request, to a backend when its host is a target name
+func (b *balancedClient) Do(ctx context.Context, req *http.Request) (*http.Response, []byte, error) {
+	target, ok := b.targets[req.URL.Host]
+	if !ok {
+		return b.next.Do(ctx, req)
+	}
+	resp, body, release, err := balance(ctx, b, target, req, b.next.Do)
+	release()
+	return resp, body, err
+}
+
+// DoStream makes the http request, to a backend when its host is a target name.
+// The request counts as in flight until the returned body is closed.
+func (b *balancedClient) DoStream(ctx context.Context, req *http.Request) (*http.Response, io.ReadCloser, error) {
+	target, ok := b.targets[req.URL.Host]
+	if !ok {
+		return b.next.DoStream(ctx, req)
+	}
+	resp, body, release, err := balance(ctx, b, target, req, b.next.DoStream)
+	if err != nil || body == nil {
+		release()
+		return resp, body, err
+	}
+	body = &onCloseBody{ReadCloser: body, onClose: release}
+	resp.Body = body
+	return resp, body, nil
+}
+
+func (b *balancedClient) MakeRequestWithRetry(ctx context.Context, url string, method string, reqBody *bytes.Buffer, headers map[string]string, maxRetries int, retryDelay time.Duration) error {
+	return makeRequestWithPolicy(ctx, b.Do, url, method, reqBody, headers, NewRetryPolicy(maxRetries, retryDelay))
+}
+
+func (b *balancedClient) MakeRequestWithPolicy(ctx context.Context, url string, method string, reqBody *bytes.Buffer, headers map[string]string, policy RetryPolicy) error {
+	return makeRequestWithPolicy(ctx, b.Do, url, method, reqBody, headers, policy)
+}
+
+func (b *balancedClient) DoWithRetry(ctx context.Context, req *Request, policy RetryPolicy) (*Result, error) {
+	return doWithRetry(ctx, b.Do, req, policy)
+}
+
+// Backends returns the state of the backends of target
+func (b *balancedClient) Backends(target string) []BackendStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	balanced, ok := b.targets[target]
+	if !ok {
+		return nil
+	}
+	statuses := make([]BackendStatus, 0, len(balanced.backends))
+	for _, be := range balanced.backends {
+		statuses = append(statuses, BackendStatus{BaseURL: be.baseURL.String(), Ejected: be.ejected, InFlight: be.inFlight})
+	}
+	return statuses
+}
+
+// balance sends req to a backend of target, failing over to another backend when allowed.
+// The returned function ends the request in flight on the selected backend.
+func balance[B any](ctx context.Context, b *balancedClient, target *balancedTarget, req *http.Request,
+	do func(context.Context, *http.Request) (*http.Response, B, error)) (*http.Response, B, func(), error) {
+	if ctx == nil {
+		ctx = req.Context()
+	}
+	tried := map[*backend]bool{}
+	var (
+		resp *http.Response
+		body B
+		err  error
+	)
+	for {
+		be := b.pick(target, req, tried)
+		if be == nil {
+			if len(tried) == 0 {
+				err = fmt.Errorf("%w for target %s", ErrNoBackend, target.Name)
+			}
+			return resp, body, func() {}, err
+		}
+		tried[be] = true
+
+		outgoing := req.Clone(ctx)
+		outgoing.URL = backendURL(be.baseURL, req.URL)
+		outgoing.Host = ""
+		if len(tried) > 1 && req.GetBody != nil {
+			if outgoing.Body, err = req.GetBody(); err != nil {
+				b.end(be)
+				return nil, body, func() {}, err
+			}
+		}
+		resp, body, err = do(ctx, outgoing)
+		if !errors.Is(err, context.Canceled) {
+			b.record(be, b.config.IsFailure(resp, err))
+		}
+		if err == nil || resp != nil || !canFailover(ctx, req, err) {
+			return resp, body, func() { b.end(be) }, err
+		}
+		b.end(be)
+	}
+}
+
+// canFailover reports whether a request failing without response can be sent to another backend
+func canFailover(ctx context.Context, req *http.Request, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	// a refused connection never reached the backend
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// backendURL returns u sent to the backend at base
+func backendURL(base, u *url.URL) *url.URL {
+	rewritten := *u
+	rewritten.Scheme = base.Scheme
+	rewritten.Host = base.Host
+	rewritten.User = base.User
+	rewritten.Path = strings.TrimSuffix(base.Path, "/") + "/" + strings.TrimPrefix(u.Path, "/")
+	rewritten.RawPath = ""
+	return &rewritten
+}
+
+// pick selects an available backend not tried yet and counts the request in flight on it, or returns nil
+func (b *balancedClient) pick(target *balancedTarget, req *http.Request, tried map[*backend]bool) *backend {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	var available []*backend
+	for _, be := range target.backends {
+		if be.ejected && now.Sub(be.ejectedAt) >= b.config.EjectionTimeout {
+			b.readmit(target, be)
+		}
+		if !be.ejected && !tried[be] {
+			available = append(available, be)
+		}
+	}
+	if len(available) == 0 {
+		return nil
+	}
+
+	var selected *backend
+	switch target.Strategy {
+	case LeastInFlight:
+		offset := target.next % len(available)
+		target.next++
+		for i := range available {
+			be := available[(offset+i)%len(available)]
+			if selected == nil || be.inFlight < selected.inFlight {
+				selected = be
+			}
+		}
+	case ConsistentHash:
+		// rendezvous hashing only moves the keys of a backend that becomes unavailable
+		key := target.HashKey(req)
+		var best uint64
+		for _, be := range available {
+			hash := fnv.New64a()
+			_, _ = hash.Write([]byte(key + "|" + be.baseURL.String()))
+			if score := hash.Sum64(); selected == nil || score > best {
+				selected, best = be, score
+			}
+		}
+	default:
+		selected = available[target.next%len(available)]
+		target.next++
+	}
+	selected.inFlight++
+	return selected
+}
+
+// readmit re-admits an ejected backend, after checking its health when a health client is configured, b.mu must be held
+func (b *balancedClient) readmit(target *balancedTarget, be *backend) {
+	if b.config.HealthClient == nil {
+		be.ejected = false
+		be.failures = 0
+		return
+	}
+	if be.checking {
+		return
+	}
+	be.checking = true
+	healthURL := backendURL(be.baseURL, &url.URL{Path: target.HealthPath}).String()
+	go func() {
+		// slow backends still serve requests, ejecting them would only move their load to the others
+		healthy := isAvailable(b.config.HealthClient.CheckHealth(healthURL))
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		be.checking = false
+		if healthy {
+			be.ejected = false
+			be.failures = 0
+			return
+		}
+		// check again once the ejection timeout elapsed again
+		be.ejectedAt = time.Now()
+	}()
+}
+
+// record adds the outcome of a request to be and ejects it after too many consecutive failures
+func (b *balancedClient) record(be *backend, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		be.failures = 0
+		return
+	}
+	be.failures++
+	if !be.ejected && be.failures >= b.config.MaxFailures {
+		be.ejected = true
+		be.ejectedAt = time.Now()
+	}
+}
+
+// end ends a request in flight on be
+func (b *balancedClient) end(be *backend) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	be.inFlight--
+}
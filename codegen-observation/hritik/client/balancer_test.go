@@ -0,0 +1,212 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nutanix-core/nai-api/iep/constants/enum"
+	"github.com/nutanix-core/nai-api/iep/internal/client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// healthFunc adapts a function to the client.IHealthClient interface
+type healthFunc func(healthCheckURL string) enum.ServiceHealthStatusCode
+
+func (f healthFunc) CheckHealth(healthCheckURL string) enum.ServiceHealthStatusCode {
+	return f(healthCheckURL)
+}
+
+func (f healthFunc) CheckHealthWithOptions(_ context.Context, healthCheckURL string, _ client.HealthCheckOptions) client.HealthCheckResult {
+	return client.HealthCheckResult{Status: f(healthCheckURL), Attempts: 1}
+}
+
+func (f healthFunc) CheckHealthBatch(_ context.Context, healthCheckURLs []string, _ ...client.BatchOption) map[string]client.HealthCheckResult {
+	results := make(map[string]client.HealthCheckResult, len(healthCheckURLs))
+	for _, healthCheckURL := range healthCheckURLs {
+		results[healthCheckURL] = client.HealthCheckResult{Status: f(healthCheckURL), Attempts: 1}
+	}
+	return results
+}
+
+func (f healthFunc) Probe(_ context.Context, baseURL string, _ client.ProbeProfile) client.ProbeResult {
+	if f(baseURL) == enum.HealthyStatusCode {
+		return client.ProbeResult{State: client.ProbeReady, URL: baseURL}
+	}
+	return client.ProbeResult{State: client.ProbeFailed, URL: baseURL}
+}
+
+var _ = Describe("Test balanced client", func() {
+
+	var (
+		backends []*httptest.Server
+		mu       sync.Mutex
+		paths    []string
+		hits     []*atomic.Int32
+		failing  atomic.Bool
+		ctx      = context.Background()
+	)
+
+	BeforeEach(func() {
+		backends, paths, hits = nil, nil, nil
+		failing.Store(false)
+		for i := 0; i < 2; i++ {
+			count := &atomic.Int32{}
+			failFirst := i == 0
+			hits = append(hits, count)
+			backends = append(backends, httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				count.Add(1)
+				mu.Lock()
+				paths = append(paths, r.URL.RequestURI())
+				mu.Unlock()
+				if failFirst && failing.Load() {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				body, _ := io.ReadAll(r.Body)
+				_, _ = w.Write(body)
+			})))
+		}
+	})
+
+	AfterEach(func() {
+		for _, backend := range backends {
+			backend.Close()
+		}
+	})
+
+	newClient := func(strategy client.BalancingStrategy, config client.BalancerConfig) client.IBalancedClient {
+		config.Targets = []client.Target{{
+			Name:       "registry",
+			BaseURLs:   []string{backends[0].URL + "/api", backends[1].URL + "/api"},
+			Strategy:   strategy,
+			HealthPath: "/health",
+		}}
+		c, err := client.NewBalancedClient(client.NewClient(), config)
+		Expect(err).ToNot(HaveOccurred())
+		return c
+	}
+
+	get := func(c client.IClient, path string) error {
+		req, err := http.NewRequest(http.MethodGet, "http://registry"+path, nil)
+		Expect(err).ToNot(HaveOccurred())
+		_, _, err = c.Do(ctx, req)
+		return err
+	}
+
+	It("should spread requests in round robin and rewrite their url", func() {
+		c := newClient(client.RoundRobin, client.BalancerConfig{})
+		for i := 0; i < 4; i++ {
+			Expect(get(c, "/v1/models?page=1")).To(Succeed())
+		}
+		Expect(hits[0].Load()).To(Equal(int32(2)))
+		Expect(hits[1].Load()).To(Equal(int32(2)))
+		Expect(paths).To(HaveEach("/api/v1/models?page=1"))
+	})
+
+	It("should send requests to the backend with the fewest requests in flight", func() {
+		c := newClient(client.LeastInFlight, client.BalancerConfig{})
+		req, err := http.NewRequest(http.MethodGet, "http://registry/v1/models", nil)
+		Expect(err).ToNot(HaveOccurred())
+		_, body, err := c.DoStream(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		busy := 0
+		if hits[1].Load() == 1 {
+			busy = 1
+		}
+		Expect(c.Backends("registry")[busy].InFlight).To(Equal(1))
+
+		for i := 0; i < 3; i++ {
+			Expect(get(c, "/v1/models")).To(Succeed())
+		}
+		Expect(hits[busy].Load()).To(Equal(int32(1)))
+		Expect(hits[1-busy].Load()).To(Equal(int32(3)))
+		Expect(body.Close()).To(Succeed())
+		Expect(c.Backends("registry")[busy].InFlight).To(BeZero())
+	})
+
+	It("should send requests with the same hash key to the same backend", func() {
+		c := newClient(client.ConsistentHash, client.BalancerConfig{})
+		for i := 0; i < 5; i++ {
+			Expect(get(c, "/v1/models/llama")).To(Succeed())
+		}
+		Expect([]int32{hits[0].Load(), hits[1].Load()}).To(ContainElement(int32(5)))
+	})
+
+	It("should fail over and eject a backend that cannot be reached", func() {
+		c := newClient(client.RoundRobin, client.BalancerConfig{MaxFailures: 2})
+		backends[0].Close()
+		for i := 0; i < 4; i++ {
+			Expect(get(c, "/v1/models")).To(Succeed())
+		}
+		req, err := http.NewRequest(http.MethodPost, "http://registry/v1/models", bytes.NewBufferString("model"))
+		Expect(err).ToNot(HaveOccurred())
+		_, body, err := c.Do(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(body)).To(Equal("model"))
+
+		Expect(hits[1].Load()).To(Equal(int32(5)))
+		Expect(c.Backends("registry")[0].Ejected).To(BeTrue())
+	})
+
+	It("should re-admit an ejected backend once it is healthy", func() {
+		var healthy atomic.Bool
+		var checked atomic.Value
+		c := newClient(client.RoundRobin, client.BalancerConfig{
+			MaxFailures:     1,
+			EjectionTimeout: 10 * time.Millisecond,
+			HealthClient: healthFunc(func(healthCheckURL string) enum.ServiceHealthStatusCode {
+				checked.Store(healthCheckURL)
+				if healthy.Load() {
+					return enum.HealthyStatusCode
+				}
+				return enum.CriticalStatusCode
+			}),
+		})
+		failing.Store(true)
+		Expect(get(c, "/v1/models")).To(Succeed())
+		Expect(c.Backends("registry")[0].Ejected).To(BeTrue())
+
+		time.Sleep(20 * time.Millisecond)
+		Expect(get(c, "/v1/models")).To(Succeed())
+		Eventually(checked.Load).Should(Equal(backends[0].URL + "/api/health"))
+		Consistently(func() bool { return c.Backends("registry")[0].Ejected }, 50*time.Millisecond).Should(BeTrue())
+
+		failing.Store(false)
+		healthy.Store(true)
+		Eventually(func() bool {
+			Expect(get(c, "/v1/models")).To(Succeed())
+			return c.Backends("registry")[0].Ejected
+		}).Should(BeFalse())
+	})
+
+	It("should fail when every backend is ejected", func() {
+		c := newClient(client.RoundRobin, client.BalancerConfig{MaxFailures: 1})
+		backends[0].Close()
+		backends[1].Close()
+		Expect(get(c, "/v1/models")).ToNot(Succeed())
+		Expect(get(c, "/v1/models")).To(MatchError(client.ErrNoBackend))
+	})
+
+	It("should send requests to other hosts unchanged", func() {
+		c := newClient(client.RoundRobin, client.BalancerConfig{})
+		req, err := http.NewRequest(http.MethodGet, backends[1].URL+"/direct", nil)
+		Expect(err).ToNot(HaveOccurred())
+		_, _, err = c.Do(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(paths).To(Equal([]string{"/direct"}))
+	})
+
+	It("should reject targets without valid base URLs", func() {
+		_, err := client.NewBalancedClient(client.NewClient(), client.BalancerConfig{Targets: []client.Target{{Name: "registry"}}})
+		Expect(err).To(HaveOccurred())
+		_, err = client.NewBalancedClient(client.NewClient(), client.BalancerConfig{Targets: []client.Target{{Name: "registry", BaseURLs: []string{"registry:8080"}}}})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,332 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCircuitWindowSize is the number of recent requests used to compute the failure rate
+	DefaultCircuitWindowSize = 20
+	// DefaultCircuitMinRequests is the number of requests needed in the window before a circuit can open
+	DefaultCircuitMinRequests = 10
+	// DefaultCircuitFailureRateThreshold opens a circuit once half of the recent requests failed
+	DefaultCircuitFailureRateThreshold = 0.5
+	// DefaultCircuitOpenTimeout is how long a circuit stays open before probe requests are let through
+	DefaultCircuitOpenTimeout = 30 * time.Second
+	// DefaultCircuitHalfOpenMaxRequests is the number of probe requests that must succeed to close a circuit
+	DefaultCircuitHalfOpenMaxRequests = 1
+)
+
+// ErrCircuitOpen is wrapped by the error returned when a request is rejected by an open circuit
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitState is the state of the circuit of a single host
+type CircuitState int
+
+const (
+	// CircuitClosed lets every request through
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects every request
+	CircuitOpen
+	// CircuitHalfOpen lets a limited number of probe requests through
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitOpenError is returned for requests rejected by an open circuit
+type CircuitOpenError struct {
+	Host string
+	// RetryAt is the time at which the circuit lets probe requests through again
+	RetryAt time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s for host %s until %s", ErrCircuitOpen, e.Host, e.RetryAt.Format(time.RFC3339))
+}
+
+func (e *CircuitOpenError) Unwrap() error {
+	return ErrCircuitOpen
+}
+
+// CircuitBreakerConfig configures the circuit breaker client, zero values are replaced by the defaults
+type CircuitBreakerConfig struct {
+	// WindowSize is the number of recent requests used to compute the failure rate
+	WindowSize int
+	// MinRequests is the number of requests needed in the window before the circuit can open
+	MinRequests int
+	// FailureRateThreshold opens the circuit once the failure ratio of the window reaches it, between 0 and 1
+	FailureRateThreshold float64
+	// OpenTimeout is how long the circuit stays open before probe requests are let through
+	OpenTimeout time.Duration
+	// HalfOpenMaxRequests is the number of probe requests let through, they must all succeed to close the circuit
+	HalfOpenMaxRequests int
+	// IsFailure classifies the outcome of a request, DefaultCircuitFailureClassifier is used when nil
+	IsFailure func(resp *http.Response, err error) bool
+	// OnStateChange is called after every state transition of a host circuit
+	OnStateChange func(host string, from CircuitState, to CircuitState)
+}
+
+// ICircuitBreakerClient is an IClient failing fast for hosts that keep failing
+type ICircuitBreakerClient interface {
+	IClient
+	State(host string) CircuitState
+}
+
+// circuitBreakerClient wraps an IClient with one circuit per host
+type circuitBreakerClient struct {
+	next     IClient
+	config   CircuitBreakerConfig
+	mu       sync.Mutex
+	circuits map[string]*hostCircuit
+}
+
+// hostCircuit keeps the state and the recent outcomes of a single host
+type hostCircuit struct {
+	state    CircuitState
+	outcomes []bool
+	next     int
+	count    int
+	failures int
+	openedAt time.Time
+	// probes and successes count the half-open requests in flight and succeeded
+	probes    int
+	successes int
+}
+
+// transition is a state change that is reported once the lock is released
+type transition struct {
+	host     string
+	from, to CircuitState
+}
+
+// NewCircuitBreakerClient returns an IClient that stops calling hosts whose failure rate is too high
+func NewCircuitBreakerClient(next IClient, config CircuitBreakerConfig) ICircuitBreakerClient {
+	if config.WindowSize <= 0 {
+		config.WindowSize = DefaultCircuitWindowSize
+	}
+	if config.MinRequests <= 0 {
+		config.MinRequests = DefaultCircuitMinRequests
+	}
+	if config.MinRequests > config.WindowSize {
+		config.MinRequests = config.WindowSize
+	}
+	if config.FailureRateThreshold <= 0 || config.FailureRateThreshold > 1 {
+		config.FailureRateThreshold = DefaultCircuitFailureRateThreshold
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = DefaultCircuitOpenTimeout
+	}
+	if config.HalfOpenMaxRequests <= 0 {
+		config.HalfOpenMaxRequests = DefaultCircuitHalfOpenMaxRequests
+	}
+	if config.IsFailure == nil {
+		config.IsFailure = DefaultCircuitFailureClassifier
+	}
+	return &circuitBreakerClient{
+		next:     next,
+		config:   config,
+		circuits: map[string]*hostCircuit{},
+	}
+}
+
+// DefaultCircuitFailureClassifier counts transport errors, 429 and 5xx responses as failures
+func DefaultCircuitFailureClassifier(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError)
+}
+
+func (b *circuitBreakerClient) SetTimeout(timeout time.Duration) {
+	b.next.SetTimeout(timeout)
+}
+
+func (b *circuitBreakerClient) GetTimeout() time.Duration {
+	return b.next.GetTimeout()
+}
+
+// Do makes the http request unless the circuit of its host is open
+func (b *circuitBreakerClient) Do(ctx context.Context, req *http.Request) (*http.Response, []byte, error) {
+	host := req.URL.Host
+	if err := b.allow(host); err != nil {
+		return nil, nil, err
+	}
+	resp, body, err := b.next.Do(ctx, req)
+	if errors.Is(err, context.Canceled) {
+		// the caller gave up, the outcome says nothing about the host
+		b.release(host)
+		return resp, body, err
+	}
+	b.record(host, b.config.IsFailure(resp, err))
+	return resp, body, err
+}
+
+// DoStream makes the http request unless the circuit of its host is open.
+// The outcome is recorded once the response headers are received.
+func (b *circuitBreakerClient) DoStream(ctx context.Context, req *http.Request) (*http.Response, io.ReadCloser, error) {
+	host := req.URL.Host
+	if err := b.allow(host); err != nil {
+		return nil, nil, err
+	}
+	resp, body, err := b.next.DoStream(ctx, req)
+	if errors.Is(err, context.Canceled) {
+		b.release(host)
+		return resp, body, err
+	}
+	b.record(host, b.config.IsFailure(resp, err))
+	return resp, body, err
+}
+
+func (b *circuitBreakerClient) MakeRequestWithRetry(ctx context.Context, url string, method string, reqBody *bytes.Buffer, headers map[string]string, maxRetries int, retryDelay time.Duration) error {
+	return makeRequestWithPolicy(ctx, b.Do, url, method, reqBody, headers, NewRetryPolicy(maxRetries, retryDelay))
+}
+
+func (b *circuitBreakerClient) MakeRequestWithPolicy(ctx context.Context, url string, method string, reqBody *bytes.Buffer, headers map[string]string, policy RetryPolicy) error {
+	return makeRequestWithPolicy(ctx, b.Do, url, method, reqBody, headers, policy)
+}
+
+func (b *circuitBreakerClient) DoWithRetry(ctx context.Context, req *Request, policy RetryPolicy) (*Result, error) {
+	return doWithRetry(ctx, b.Do, req, policy)
+}
+
+// State returns the current state of the circuit of host
+func (b *circuitBreakerClient) State(host string) CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	circuit, ok := b.circuits[host]
+	if !ok {
+		return CircuitClosed
+	}
+	return circuit.state
+}
+
+// allow reports whether a request to host may be sent
+func (b *circuitBreakerClient) allow(host string) error {
+	var changes []transition
+	defer func() { b.notify(changes) }()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	circuit := b.circuit(host)
+
+	if circuit.state == CircuitOpen {
+		retryAt := circuit.openedAt.Add(b.config.OpenTimeout)
+		if time.Now().Before(retryAt) {
+			return &CircuitOpenError{Host: host, RetryAt: retryAt}
+		}
+		changes = append(changes, b.setState(host, circuit, CircuitHalfOpen))
+	}
+
+	if circuit.state == CircuitHalfOpen {
+		if circuit.probes >= b.config.HalfOpenMaxRequests {
+			return &CircuitOpenError{Host: host, RetryAt: time.Now().Add(b.config.OpenTimeout)}
+		}
+		circuit.probes++
+	}
+	return nil
+}
+
+// record adds the outcome of a request to host and moves its circuit accordingly
+func (b *circuitBreakerClient) record(host string, failed bool) {
+	var changes []transition
+	defer func() { b.notify(changes) }()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	circuit := b.circuit(host)
+
+	switch circuit.state {
+	case CircuitHalfOpen:
+		if failed {
+			changes = append(changes, b.setState(host, circuit, CircuitOpen))
+			return
+		}
+		circuit.successes++
+		if circuit.successes >= b.config.HalfOpenMaxRequests {
+			changes = append(changes, b.setState(host, circuit, CircuitClosed))
+		}
+	case CircuitClosed:
+		if circuit.count == len(circuit.outcomes) && circuit.outcomes[circuit.next] {
+			circuit.failures--
+		}
+		circuit.outcomes[circuit.next] = failed
+		circuit.next = (circuit.next + 1) % len(circuit.outcomes)
+		if circuit.count < len(circuit.outcomes) {
+			circuit.count++
+		}
+		if failed {
+			circuit.failures++
+		}
+		if circuit.count >= b.config.MinRequests && float64(circuit.failures)/float64(circuit.count) >= b.config.FailureRateThreshold {
+			changes = append(changes, b.setState(host, circuit, CircuitOpen))
+		}
+	case CircuitOpen:
+		// requests started before the circuit opened do not change its state
+	}
+}
+
+// release frees the probe slot of a request whose outcome is not recorded
+func (b *circuitBreakerClient) release(host string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if circuit := b.circuit(host); circuit.state == CircuitHalfOpen && circuit.probes > 0 {
+		circuit.probes--
+	}
+}
+
+func (b *circuitBreakerClient) circuit(host string) *hostCircuit {
+	circuit, ok := b.circuits[host]
+	if !ok {
+		circuit = &hostCircuit{state: CircuitClosed, outcomes: make([]bool, b.config.WindowSize)}
+		b.circuits[host] = circuit
+	}
+	return circuit
+}
+
+// setState moves circuit to the given state and resets the counters of the new state, b.mu must be held
+func (b *circuitBreakerClient) setState(host string, circuit *hostCircuit, state CircuitState) transition {
+	change := transition{host: host, from: circuit.state, to: state}
+	circuit.state = state
+	circuit.probes = 0
+	circuit.successes = 0
+	switch state {
+	case CircuitOpen:
+		circuit.openedAt = time.Now()
+	case CircuitClosed:
+		circuit.outcomes = make([]bool, b.config.WindowSize)
+		circuit.next = 0
+		circuit.count = 0
+		circuit.failures = 0
+	case CircuitHalfOpen:
+	}
+	return change
+}
+
+// notify reports state transitions, it is called without holding b.mu so callbacks may use the client
+func (b *circuitBreakerClient) notify(changes []transition) {
+	if b.config.OnStateChange == nil {
+		return
+	}
+	for _, change := range changes {
+		b.config.OnStateChange(change.host, change.from, change.to)
+	}
+}
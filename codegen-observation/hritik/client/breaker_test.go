@@ -0,0 +1,132 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nutanix-core/nai-api/iep/internal/client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Test circuit breaker client", func() {
+
+	var (
+		testServer  *httptest.Server
+		host        string
+		statusCode  atomic.Int32
+		hits        atomic.Int32
+		mu          sync.Mutex
+		transitions []client.CircuitState
+		breaker     client.ICircuitBreakerClient
+		ctx         = context.Background()
+	)
+
+	get := func() (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodGet, testServer.URL, nil)
+		Expect(err).ToNot(HaveOccurred())
+		resp, _, err := breaker.Do(ctx, req)
+		return resp, err
+	}
+
+	BeforeEach(func() {
+		statusCode.Store(http.StatusInternalServerError)
+		hits.Store(0)
+		transitions = nil
+		testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			hits.Add(1)
+			w.WriteHeader(int(statusCode.Load()))
+		}))
+		serverURL, err := url.Parse(testServer.URL)
+		Expect(err).ToNot(HaveOccurred())
+		host = serverURL.Host
+		breaker = client.NewCircuitBreakerClient(client.NewClient(), client.CircuitBreakerConfig{
+			WindowSize:           4,
+			MinRequests:          4,
+			FailureRateThreshold: 0.5,
+			OpenTimeout:          100 * time.Millisecond,
+			OnStateChange: func(_ string, _ client.CircuitState, to client.CircuitState) {
+				mu.Lock()
+				defer mu.Unlock()
+				transitions = append(transitions, to)
+			},
+		})
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	It("should stay closed below the minimum number of requests", func() {
+		for i := 0; i < 3; i++ {
+			_, err := get()
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(breaker.State(host)).To(Equal(client.CircuitClosed))
+	})
+
+	It("should open once the failure rate is reached and fail fast", func() {
+		for i := 0; i < 4; i++ {
+			_, err := get()
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(breaker.State(host)).To(Equal(client.CircuitOpen))
+
+		_, err := get()
+		Expect(err).To(MatchError(client.ErrCircuitOpen))
+		var circuitErr *client.CircuitOpenError
+		Expect(errors.As(err, &circuitErr)).To(BeTrue())
+		Expect(circuitErr.Host).To(Equal(host))
+		Expect(hits.Load()).To(Equal(int32(4)))
+	})
+
+	It("should close after a successful probe", func() {
+		for i := 0; i < 4; i++ {
+			_, _ = get()
+		}
+		statusCode.Store(http.StatusOK)
+		time.Sleep(150 * time.Millisecond)
+
+		resp, err := get()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(breaker.State(host)).To(Equal(client.CircuitClosed))
+		mu.Lock()
+		defer mu.Unlock()
+		Expect(transitions).To(Equal([]client.CircuitState{client.CircuitOpen, client.CircuitHalfOpen, client.CircuitClosed}))
+	})
+
+	It("should open again after a failed probe", func() {
+		for i := 0; i < 4; i++ {
+			_, _ = get()
+		}
+		time.Sleep(150 * time.Millisecond)
+
+		_, err := get()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(breaker.State(host)).To(Equal(client.CircuitOpen))
+		_, err = get()
+		Expect(err).To(MatchError(client.ErrCircuitOpen))
+	})
+
+	It("should not count client errors as failures", func() {
+		statusCode.Store(http.StatusBadRequest)
+		for i := 0; i < 4; i++ {
+			_, _ = get()
+		}
+		Expect(breaker.State(host)).To(Equal(client.CircuitClosed))
+	})
+
+	It("should stop retrying once the circuit opens", func() {
+		policy := client.RetryPolicy{MaxAttempts: 10, InitialInterval: time.Millisecond}
+		_, err := breaker.DoWithRetry(ctx, client.NewRequest(http.MethodGet, testServer.URL, nil), policy)
+		Expect(err).To(MatchError(client.ErrCircuitOpen))
+		Expect(hits.Load()).To(Equal(int32(4)))
+	})
+})
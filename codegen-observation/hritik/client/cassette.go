@@ -0,0 +1,348 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrCassetteNoMatch is returned in replay mode when no recorded interaction matches the request
+var ErrCassetteNoMatch = errors.New("no recorded interaction matches the request")
+
+// RedactedValue replaces redacted header and query values in recorded interactions
+const RedactedValue = "REDACTED"
+
+// redactedQueryParams are the query parameters always recorded as RedactedValue
+var redactedQueryParams = []string{"access_token", "api_key", "apikey", "key", "password", "secret", "sig", "signature", "token"}
+
+// CassetteMode tells whether a cassette records real interactions or replays recorded ones
+type CassetteMode int
+
+const (
+	// CassetteReplay serves recorded interactions without sending any request
+	CassetteReplay CassetteMode = iota
+	// CassetteRecord sends requests and records the interactions
+	CassetteRecord
+)
+
+// CassetteFault is a failure injected when replaying an interaction
+type CassetteFault string
+
+const (
+	// FaultConnectionReset fails the request with a connection reset error
+	FaultConnectionReset CassetteFault = "reset"
+	// FaultTimeout blocks the request until its context is done, as an engine that never answers
+	FaultTimeout CassetteFault = "timeout"
+	// FaultTruncatedBody cuts the response body in half and fails reading it with io.ErrUnexpectedEOF
+	FaultTruncatedBody CassetteFault = "truncate"
+)
+
+// Interaction is a recorded request and its response
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+	// Latency delays the replayed response
+	Latency time.Duration `json:"latency,omitempty"`
+	// Fault fails the replayed request or response
+	Fault CassetteFault `json:"fault,omitempty"`
+}
+
+// RecordedRequest is a request as stored in a cassette, bodies are stored as text
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// RecordedResponse is a response as stored in a cassette, bodies are stored as text
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// CassetteMatcher tells whether a recorded request matches the request being replayed, body is the request body.
+// The url, headers and body of the request are redacted like the recorded ones before they are matched.
+type CassetteMatcher func(req *http.Request, body []byte, recorded RecordedRequest) bool
+
+// CassetteConfig configures a cassette
+type CassetteConfig struct {
+	Mode CassetteMode
+	// Path is the file the cassette is loaded from in replay mode and saved to in record mode
+	Path string
+	// RedactHeaders are recorded as RedactedValue, in addition to the Authorization,
+	// Proxy-Authorization, Cookie and Set-Cookie headers which are always redacted
+	RedactHeaders []string
+	// RedactQuery are query parameters recorded as RedactedValue, in addition to the access_token, api_key,
+	// apikey, key, password, secret, sig, signature and token parameters which are always redacted.
+	// The user info of the url is never recorded.
+	RedactQuery []string
+	// RedactBody rewrites the request and response bodies before they are recorded
+	RedactBody func(body []byte) []byte
+	// Matcher selects the interactions replayed for a request, DefaultCassetteMatcher by default
+	Matcher CassetteMatcher
+	// Latency delays every replayed response, in addition to the latency of the interaction
+	Latency time.Duration
+	// RepeatLast replays the last matching interaction again once every matching interaction was replayed
+	RepeatLast bool
+	// Transport sends the recorded requests, http.DefaultTransport by default
+	Transport http.RoundTripper
+}
+
+// Cassette is a round tripper recording interactions or replaying them deterministically.
+// Interactions matching a request are replayed in the order they were recorded, so that
+// sequences such as an engine failing before recovering can be replayed.
+// Pass it to NewClient with WithTransport.
+type Cassette struct {
+	config       CassetteConfig
+	mu           sync.Mutex
+	interactions []Interaction
+	replayed     []bool
+}
+
+// DefaultCassetteMatcher matches requests with the same method, url and body
+func DefaultCassetteMatcher(req *http.Request, body []byte, recorded RecordedRequest) bool {
+	return req.Method == recorded.Method && req.URL.String() == recorded.URL && string(body) == recorded.Body
+}
+
+// NewCassette returns a cassette, in replay mode the interactions are loaded from config.Path
+func NewCassette(config CassetteConfig) (*Cassette, error) {
+	if config.Matcher == nil {
+		config.Matcher = DefaultCassetteMatcher
+	}
+	if config.Transport == nil {
+		config.Transport = http.DefaultTransport
+	}
+	c := &Cassette{config: config}
+	if config.Mode == CassetteRecord {
+		return c, nil
+	}
+	data, err := os.ReadFile(config.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette %s: %w", config.Path, err)
+	}
+	if err = json.Unmarshal(data, &c.interactions); err != nil {
+		return nil, fmt.Errorf("failed to parse cassette %s: %w", config.Path, err)
+	}
+	c.replayed = make([]bool, len(c.interactions))
+	return c, nil
+}
+
+// Interactions returns a copy of the recorded or loaded interactions
+func (c *Cassette) Interactions() []Interaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Interaction(nil), c.interactions...)
+}
+
+// Save writes the recorded interactions to the cassette file
+func (c *Cassette) Save() error {
+	c.mu.Lock()
+	data, err := json.MarshalIndent(c.interactions, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(c.config.Path, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("failed to write cassette %s: %w", c.config.Path, err)
+	}
+	return nil
+}
+
+// RoundTrip records or replays the request depending on the cassette mode
+func (c *Cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	if c.config.Mode == CassetteRecord {
+		return c.record(req, body)
+	}
+	return c.replay(req, body)
+}
+
+func (c *Cassette) record(req *http.Request, body []byte) (*http.Response, error) {
+	outgoing := req.Clone(req.Context())
+	outgoing.Body = io.NopCloser(bytes.NewReader(body))
+	resp, err := c.config.Transport.RoundTrip(outgoing)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	interaction := Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    c.redactURL(req.URL).String(),
+			Header: c.redactHeader(req.Header),
+			Body:   string(c.redactBody(body)),
+		},
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     c.redactHeader(resp.Header),
+			Body:       string(c.redactBody(respBody)),
+		},
+	}
+	c.mu.Lock()
+	c.interactions = append(c.interactions, interaction)
+	c.mu.Unlock()
+	return resp, nil
+}
+
+func (c *Cassette) replay(req *http.Request, body []byte) (*http.Response, error) {
+	// recorded requests are redacted, redact the request the same way so that it can match them
+	redacted := req.Clone(req.Context())
+	redacted.URL = c.redactURL(req.URL)
+	redacted.Header = c.redactHeader(req.Header)
+	interaction, ok := c.next(redacted, c.redactBody(body))
+	if !ok {
+		return nil, fmt.Errorf("%w: %s %s", ErrCassetteNoMatch, req.Method, redacted.URL)
+	}
+
+	if latency := c.config.Latency + interaction.Latency; latency > 0 {
+		timer := time.NewTimer(latency)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+
+	switch interaction.Fault {
+	case FaultConnectionReset:
+		return nil, &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	case FaultTimeout:
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}
+
+	respBody := io.Reader(strings.NewReader(interaction.Response.Body))
+	contentLength := int64(len(interaction.Response.Body))
+	if interaction.Fault == FaultTruncatedBody {
+		respBody = io.MultiReader(
+			strings.NewReader(interaction.Response.Body[:len(interaction.Response.Body)/2]),
+			errorReader{err: io.ErrUnexpectedEOF},
+		)
+	}
+	header := interaction.Response.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.Response.StatusCode, http.StatusText(interaction.Response.StatusCode)),
+		StatusCode:    interaction.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(respBody),
+		ContentLength: contentLength,
+		Request:       req,
+	}, nil
+}
+
+// next returns the first matching interaction not replayed yet
+func (c *Cassette) next(req *http.Request, body []byte) (Interaction, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	last := -1
+	for i, interaction := range c.interactions {
+		if !c.config.Matcher(req, body, interaction.Request) {
+			continue
+		}
+		if !c.replayed[i] {
+			c.replayed[i] = true
+			return interaction, true
+		}
+		last = i
+	}
+	if c.config.RepeatLast && last >= 0 {
+		return c.interactions[last], true
+	}
+	return Interaction{}, false
+}
+
+// redactURL returns a copy of u without user info and with the values of the redacted query parameters replaced
+func (c *Cassette) redactURL(u *url.URL) *url.URL {
+	redacted := *u
+	redacted.User = nil
+	query := redacted.Query()
+	changed := false
+	for key, values := range query {
+		if !c.redactedQueryParam(key) {
+			continue
+		}
+		for i := range values {
+			values[i] = RedactedValue
+		}
+		changed = true
+	}
+	if changed {
+		redacted.RawQuery = query.Encode()
+	}
+	return &redacted
+}
+
+func (c *Cassette) redactedQueryParam(key string) bool {
+	for _, param := range append(redactedQueryParams, c.config.RedactQuery...) {
+		if strings.EqualFold(key, param) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Cassette) redactHeader(header http.Header) http.Header {
+	redacted := header.Clone()
+	for _, key := range append([]string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}, c.config.RedactHeaders...) {
+		if redacted.Get(key) != "" {
+			redacted.Set(key, RedactedValue)
+		}
+	}
+	return redacted
+}
+
+func (c *Cassette) redactBody(body []byte) []byte {
+	if c.config.RedactBody == nil || len(body) == 0 {
+		return body
+	}
+	return c.config.RedactBody(body)
+}
+
+// readRequestBody reads and closes the body of req, as a round tripper must do
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	defer req.Body.Close() //nolint:errcheck
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	return body, nil
+}
+
+type errorReader struct {
+	err error
+}
+
+func (r errorReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
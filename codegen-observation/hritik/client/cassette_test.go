@@ -0,0 +1,221 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nutanix-core/nai-api/iep/constants/enum"
+	"github.com/nutanix-core/nai-api/iep/internal/client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Test cassette transport", func() {
+
+	var (
+		path string
+		ctx  = context.Background()
+	)
+
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "cassette.json")
+	})
+
+	writeCassette := func(interactions []client.Interaction) {
+		data, err := json.Marshal(interactions)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(os.WriteFile(path, data, 0o600)).To(Succeed())
+	}
+
+	replayClient := func(config client.CassetteConfig) client.IClient {
+		config.Path = path
+		cassette, err := client.NewCassette(config)
+		Expect(err).ToNot(HaveOccurred())
+		return client.NewClient(client.WithTransport(cassette))
+	}
+
+	It("should record interactions and replay them without the server", func() {
+		redactBody := func(body []byte) []byte {
+			return bytes.ReplaceAll(bytes.ReplaceAll(body, []byte("secret"), []byte("xxx")), []byte("SECRET"), []byte("XXX"))
+		}
+		testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("Set-Cookie", "session=secret")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write(bytes.ToUpper(body))
+		}))
+		cassette, err := client.NewCassette(client.CassetteConfig{
+			Mode:          client.CassetteRecord,
+			Path:          path,
+			RedactHeaders: []string{"X-Api-Key"},
+			RedactBody:    redactBody,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		c := client.NewClient(client.WithTransport(cassette))
+		req, err := http.NewRequest(http.MethodPost, testServer.URL+"/v1/completions", bytes.NewBufferString(`{"prompt":"secret"}`))
+		Expect(err).ToNot(HaveOccurred())
+		req.Header.Set("Authorization", "Bearer token")
+		req.Header.Set("X-Api-Key", "key")
+		resp, body, err := c.Do(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+		Expect(string(body)).To(Equal(`{"PROMPT":"SECRET"}`))
+		Expect(cassette.Save()).To(Succeed())
+		testServer.Close()
+
+		interactions := cassette.Interactions()
+		Expect(interactions).To(HaveLen(1))
+		Expect(interactions[0].Request.Header.Get("Authorization")).To(Equal(client.RedactedValue))
+		Expect(interactions[0].Request.Header.Get("X-Api-Key")).To(Equal(client.RedactedValue))
+		Expect(interactions[0].Request.Body).To(Equal(`{"prompt":"xxx"}`))
+		Expect(interactions[0].Response.Header.Get("Set-Cookie")).To(Equal(client.RedactedValue))
+		Expect(interactions[0].Response.Body).To(Equal(`{"PROMPT":"XXX"}`))
+
+		// the same request matches its redacted recording
+		c = replayClient(client.CassetteConfig{
+			RedactHeaders: []string{"X-Api-Key"},
+			RedactBody:    redactBody,
+			Matcher: func(req *http.Request, body []byte, recorded client.RecordedRequest) bool {
+				return client.DefaultCassetteMatcher(req, body, recorded) && req.Header.Get("X-Api-Key") == recorded.Header.Get("X-Api-Key")
+			},
+		})
+		req, err = http.NewRequest(http.MethodPost, testServer.URL+"/v1/completions", bytes.NewBufferString(`{"prompt":"secret"}`))
+		Expect(err).ToNot(HaveOccurred())
+		req.Header.Set("Authorization", "Bearer token")
+		req.Header.Set("X-Api-Key", "key")
+		resp, body, err = c.Do(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+		Expect(string(body)).To(Equal(`{"PROMPT":"XXX"}`))
+	})
+
+	It("should redact the url", func() {
+		testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer testServer.Close()
+		cassette, err := client.NewCassette(client.CassetteConfig{
+			Mode:        client.CassetteRecord,
+			Path:        path,
+			RedactQuery: []string{"tenant"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		rawURL := strings.Replace(testServer.URL, "http://", "http://user:password@", 1) + "/v1/models?model=llama&token=secret&tenant=acme"
+		_, _, err = client.NewClient(client.WithTransport(cassette)).Do(ctx, newGetRequest(rawURL))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cassette.Save()).To(Succeed())
+
+		interactions := cassette.Interactions()
+		Expect(interactions).To(HaveLen(1))
+		Expect(interactions[0].Request.URL).To(Equal(testServer.URL + "/v1/models?model=llama&tenant=REDACTED&token=REDACTED"))
+
+		// the same request matches its redacted recording, and other ones are not reported with their credentials
+		cassette, err = client.NewCassette(client.CassetteConfig{Path: path, RedactQuery: []string{"tenant"}})
+		Expect(err).ToNot(HaveOccurred())
+		resp, err := cassette.RoundTrip(newGetRequest(rawURL))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.Body.Close()).To(Succeed())
+		_, err = cassette.RoundTrip(newGetRequest(rawURL + "&stream=true"))
+		Expect(err).To(MatchError(client.ErrCassetteNoMatch))
+		Expect(err.Error()).ToNot(ContainSubstring("secret"))
+		Expect(err.Error()).ToNot(ContainSubstring("password"))
+	})
+
+	It("should replay matching interactions in order", func() {
+		writeCassette([]client.Interaction{
+			{Request: client.RecordedRequest{Method: http.MethodGet, URL: "http://engine/health"}, Response: client.RecordedResponse{StatusCode: 503}},
+			{Request: client.RecordedRequest{Method: http.MethodGet, URL: "http://engine/health"}, Response: client.RecordedResponse{StatusCode: 200}},
+		})
+		c := replayClient(client.CassetteConfig{})
+		req, err := http.NewRequest(http.MethodGet, "http://engine/health", nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		result, err := c.DoWithRetry(ctx, client.NewRequest(http.MethodGet, "http://engine/health", nil), client.NewRetryPolicy(3, time.Millisecond))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Attempts).To(Equal(2))
+
+		_, _, err = c.Do(ctx, req)
+		Expect(err).To(MatchError(client.ErrCassetteNoMatch))
+	})
+
+	It("should repeat the last interaction when asked to", func() {
+		writeCassette([]client.Interaction{
+			{Request: client.RecordedRequest{Method: http.MethodGet, URL: "http://engine/health"}, Response: client.RecordedResponse{StatusCode: 200}},
+		})
+		c := replayClient(client.CassetteConfig{RepeatLast: true})
+		healthClient := client.NewHealthClient(c)
+		for i := 0; i < 3; i++ {
+			Expect(healthClient.CheckHealth("http://engine/health")).To(Equal(enum.HealthyStatusCode))
+		}
+	})
+
+	It("should use a custom matcher", func() {
+		writeCassette([]client.Interaction{
+			{Request: client.RecordedRequest{Method: http.MethodGet, URL: "http://engine/v1/models"}, Response: client.RecordedResponse{StatusCode: 200, Body: `{"data":[]}`}},
+		})
+		c := replayClient(client.CassetteConfig{Matcher: func(req *http.Request, _ []byte, recorded client.RecordedRequest) bool {
+			return req.URL.Path == "/v1/models" && recorded.Method == req.Method
+		}})
+		req, err := http.NewRequest(http.MethodGet, "http://other-engine:8080/v1/models?page=2", nil)
+		Expect(err).ToNot(HaveOccurred())
+		_, body, err := c.Do(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(body)).To(Equal(`{"data":[]}`))
+	})
+
+	It("should inject latency", func() {
+		writeCassette([]client.Interaction{
+			{Request: client.RecordedRequest{Method: http.MethodGet, URL: "http://engine/health"}, Response: client.RecordedResponse{StatusCode: 200}, Latency: 50 * time.Millisecond},
+			{Request: client.RecordedRequest{Method: http.MethodGet, URL: "http://engine/health"}, Response: client.RecordedResponse{StatusCode: 200}, Latency: time.Minute},
+		})
+		c := replayClient(client.CassetteConfig{Latency: 50 * time.Millisecond})
+		req, err := http.NewRequest(http.MethodGet, "http://engine/health", nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		start := time.Now()
+		_, _, err = c.Do(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(time.Since(start)).To(BeNumerically(">=", 100*time.Millisecond))
+
+		_, _, err = c.Do(client.WithRequestTimeout(ctx, 50*time.Millisecond), req)
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+	})
+
+	It("should inject faults", func() {
+		writeCassette([]client.Interaction{
+			{Request: client.RecordedRequest{Method: http.MethodGet, URL: "http://engine/health"}, Fault: client.FaultConnectionReset},
+			{Request: client.RecordedRequest{Method: http.MethodGet, URL: "http://engine/health"}, Fault: client.FaultTimeout},
+			{Request: client.RecordedRequest{Method: http.MethodGet, URL: "http://engine/health"}, Response: client.RecordedResponse{StatusCode: 200, Body: `{"live":true}`}, Fault: client.FaultTruncatedBody},
+		})
+		c := replayClient(client.CassetteConfig{})
+		req, err := http.NewRequest(http.MethodGet, "http://engine/health", nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, _, err = c.Do(ctx, req)
+		Expect(err).To(MatchError(syscall.ECONNRESET))
+		_, _, err = c.Do(client.WithRequestTimeout(ctx, 50*time.Millisecond), req)
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+		_, _, err = c.Do(ctx, req)
+		Expect(err).To(MatchError(io.ErrUnexpectedEOF))
+	})
+
+	It("should fail when the cassette cannot be loaded", func() {
+		_, err := client.NewCassette(client.CassetteConfig{Path: path})
+		Expect(err).To(MatchError(ContainSubstring("failed to read cassette")))
+	})
+})
+
+func newGetRequest(rawURL string) *http.Request {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	Expect(err).ToNot(HaveOccurred())
+	return req
+}
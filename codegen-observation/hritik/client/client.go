@@ -5,82 +5,303 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/nutanix-core/nai-api/iep/internal/tracing"
 )
 
 // IClient is the interface for an API client.
 type IClient interface {
 	Do(context.Context, *http.Request) (*http.Response, []byte, error)
+	DoStream(context.Context, *http.Request) (*http.Response, io.ReadCloser, error)
 	MakeRequestWithRetry(ctx context.Context, url string, method string, reqBody *bytes.Buffer, headers map[string]string, maxRetries int, retryDelay time.Duration) error
+	MakeRequestWithPolicy(ctx context.Context, url string, method string, reqBody *bytes.Buffer, headers map[string]string, policy RetryPolicy) error
+	DoWithRetry(ctx context.Context, req *Request, policy RetryPolicy) (*Result, error)
+	// SetTimeout sets the default timeout of requests whose context carries none, see WithRequestTimeout
 	SetTimeout(timeout time.Duration)
 	GetTimeout() time.Duration
 }
 
+// DefaultMaxResponseBodySize is the largest response body Do reads unless configured otherwise
+const DefaultMaxResponseBodySize int64 = 32 << 20
+
+// ErrResponseTooLarge is matched by errors returned when a response body exceeds the configured maximum
+var ErrResponseTooLarge = errors.New("response body too large")
+
+// ResponseTooLargeError is returned by Do when the response body is larger than the configured maximum
+type ResponseTooLargeError struct {
+	Limit int64
+}
+
+func (e *ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("%s, limit is %d bytes", ErrResponseTooLarge, e.Limit)
+}
+
+func (e *ResponseTooLargeError) Unwrap() error {
+	return ErrResponseTooLarge
+}
+
 // Client struct has http client object
 type client struct {
-	httpClient *http.Client
+	httpClient      *http.Client
+	maxResponseSize int64
+	// timeout is the default request timeout, it is read by every request and may be changed concurrently
+	timeout atomic.Int64
 }
 
-// NewClient returns a new http client
-func NewClient() IClient {
-	return &client{
-		httpClient: &http.Client{},
+// Option configures the client returned by NewClient and NewClientWithOptions
+type Option func(*clientOptions)
+
+type clientOptions struct {
+	transport         http.RoundTripper
+	interceptors      []Interceptor
+	timeout           time.Duration
+	maxResponseSize   int64
+	tls               tlsOptions
+	tlsReloadInterval time.Duration
+	proxyURL          *url.URL
+	noProxy           string
+	unixSocket        string
+}
+
+// WithTransport sets the round tripper used to send requests, http.DefaultTransport is used by default.
+// TLS, proxy and unix socket options require transport to be an *http.Transport, NewClientWithOptions
+// returns an error otherwise.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(o *clientOptions) {
+		o.transport = transport
+	}
+}
+
+// WithInterceptors appends interceptors to the chain, the first interceptor sees requests first
+func WithInterceptors(interceptors ...Interceptor) Option {
+	return func(o *clientOptions) {
+		o.interceptors = append(o.interceptors, interceptors...)
 	}
 }
 
+// WithTimeout sets the initial default request timeout
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *clientOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithMaxResponseBodySize sets the largest response body Do reads, a limit of zero or less disables the check
+func WithMaxResponseBodySize(size int64) Option {
+	return func(o *clientOptions) {
+		o.maxResponseSize = size
+	}
+}
+
+// NewClient returns a new http client for options that always apply. Options that may conflict, such as
+// WithProxy with WithUnixSocket or TLS options with WithTransport, must be given to NewClientWithOptions:
+// NewClient panics when they cannot be applied.
+func NewClient(opts ...Option) IClient {
+	c, err := NewClientWithOptions(opts...)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// NewClientWithOptions returns a new http client, or an error matching ErrInvalidOptions when the TLS, proxy
+// or unix socket options cannot be applied, see WithTransport and WithUnixSocket.
+func NewClientWithOptions(opts ...Option) (IClient, error) {
+	options := clientOptions{maxResponseSize: DefaultMaxResponseBodySize, tlsReloadInterval: DefaultTLSReloadInterval}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	transport, err := newTransport(options)
+	if err != nil {
+		return nil, err
+	}
+	c := &client{
+		httpClient: &http.Client{
+			Transport: chain(transport, options.interceptors),
+		},
+		maxResponseSize: options.maxResponseSize,
+	}
+	c.timeout.Store(int64(options.timeout))
+	return c, nil
+}
+
+// SetTimeout sets the default timeout applied to every request, it does not affect requests in flight
 func (c *client) SetTimeout(timeout time.Duration) {
-	c.httpClient.Timeout = timeout
+	c.timeout.Store(int64(timeout))
 }
 
 func (c *client) GetTimeout() time.Duration {
-	return c.httpClient.Timeout
+	return time.Duration(c.timeout.Load())
 }
 
-// Do makes http request to a server
+// Do makes http request to a server and reads the whole response body.
+// A *ResponseTooLargeError is returned along with the response when the body exceeds the maximum size.
 func (c *client) Do(ctx context.Context, req *http.Request) (*http.Response, []byte, error) {
-	if ctx != nil {
-		req = req.WithContext(ctx)
+	if ctx == nil {
+		ctx = req.Context()
+	}
+	timeout, ok := requestTimeout(ctx)
+	if !ok {
+		timeout = c.GetTimeout()
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.send(ctx, req)
 	if err != nil {
 		return nil, nil, err
 	}
 	defer resp.Body.Close() //nolint:errcheck
+	if c.maxResponseSize > 0 && resp.ContentLength > c.maxResponseSize {
+		return resp, nil, &ResponseTooLargeError{Limit: c.maxResponseSize}
+	}
+	reader := io.Reader(resp.Body)
+	if c.maxResponseSize > 0 {
+		// read one byte past the limit to tell a body of exactly the maximum size from a larger one
+		reader = io.LimitReader(resp.Body, c.maxResponseSize+1)
+	}
 	var buf bytes.Buffer
 	var body []byte
-	_, err = buf.ReadFrom(resp.Body)
+	_, err = buf.ReadFrom(reader)
 	if err == nil {
 		body = buf.Bytes()
 	}
+	if c.maxResponseSize > 0 && int64(len(body)) > c.maxResponseSize {
+		return resp, nil, &ResponseTooLargeError{Limit: c.maxResponseSize}
+	}
 	return resp, body, err
 }
 
-func (c *client) MakeRequestWithRetry(ctx context.Context, url string, method string, reqBody *bytes.Buffer, headers map[string]string, maxRetries int, retryDelay time.Duration) error {
-	var resp *http.Response
-	for i := 0; i < maxRetries; i++ {
-		req, err := http.NewRequest(method, url, reqBody)
-		if err != nil {
-			return err
-		}
-		for key, value := range headers {
-			req.Header.Set(key, value)
+// DoStream makes http request to a server without reading the response body.
+// The caller must close the returned body, which is also the body of the returned response.
+// The maximum body size does not apply, the caller decides how much to read.
+// A timeout set with WithRequestTimeout bounds the whole stream, while the default timeout
+// only bounds the wait for the response headers so that long lived streams are not cut.
+func (c *client) DoStream(ctx context.Context, req *http.Request) (*http.Response, io.ReadCloser, error) {
+	if ctx == nil {
+		ctx = req.Context()
+	}
+	timeout, ok := requestTimeout(ctx)
+	if ok {
+		if timeout <= 0 {
+			return c.stream(ctx, req, func() {})
 		}
-		// Call the Do function to make the HTTP request
-		resp, _, err = c.Do(ctx, req)
-		if err == nil && resp != nil && resp.StatusCode == http.StatusOK {
-			// Request succeeded, return
-			return nil
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		return c.stream(ctx, req, cancel)
+	}
+
+	timeout = c.GetTimeout()
+	if timeout <= 0 {
+		return c.stream(ctx, req, func() {})
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	timer := time.AfterFunc(timeout, func() {
+		cancel(errResponseHeaderTimeout)
+	})
+	resp, body, err := c.stream(ctx, req, func() { cancel(nil) })
+	if !timer.Stop() {
+		if err == nil {
+			// the headers arrived as the timer fired, the body can no longer be read
+			_ = body.Close()
 		}
-		defer func() {
-			if resp != nil {
-				resp.Body.Close() //nolint:errcheck,gosec
-			}
-		}()
-		if i < maxRetries {
-			time.Sleep(retryDelay)
+		return nil, nil, fmt.Errorf("%s %s: %w", req.Method, redactURL(req), context.Cause(ctx))
+	}
+	return resp, body, err
+}
+
+// stream sends req under ctx and calls cancel once the response body is closed, or right away on error
+func (c *client) stream(ctx context.Context, req *http.Request, cancel func()) (*http.Response, io.ReadCloser, error) {
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	resp.Body = &onCloseBody{ReadCloser: resp.Body, onClose: cancel}
+	return resp, resp.Body, nil
+}
+
+// send sends req under ctx, propagating the trace of the caller
+func (c *client) send(ctx context.Context, req *http.Request) (*http.Response, error) {
+	req = req.WithContext(ctx)
+	if _, ok := tracing.SpanContextFromContext(req.Context()); ok {
+		// propagate the trace of the caller, on a copy as the caller owns the request headers
+		req = req.Clone(req.Context())
+		tracing.Inject(req.Context(), req.Header)
+	}
+	return c.httpClient.Do(req)
+}
+
+// MakeRequestWithRetry makes at most maxRetries attempts, backing off exponentially from retryDelay
+func (c *client) MakeRequestWithRetry(ctx context.Context, url string, method string, reqBody *bytes.Buffer, headers map[string]string, maxRetries int, retryDelay time.Duration) error {
+	return makeRequestWithPolicy(ctx, c.Do, url, method, reqBody, headers, NewRetryPolicy(maxRetries, retryDelay))
+}
+
+// MakeRequestWithPolicy makes the request until it succeeds or the retry policy gives up.
+// The returned error wraps the error or status code of the last attempt.
+func (c *client) MakeRequestWithPolicy(ctx context.Context, url string, method string, reqBody *bytes.Buffer, headers map[string]string, policy RetryPolicy) error {
+	return makeRequestWithPolicy(ctx, c.Do, url, method, reqBody, headers, policy)
+}
+
+// DoWithRetry sends req until it succeeds or the retry policy gives up, replaying its body on every attempt.
+// The result of the last attempt is returned whenever a response was received, including on error.
+func (c *client) DoWithRetry(ctx context.Context, req *Request, policy RetryPolicy) (*Result, error) {
+	return doWithRetry(ctx, c.Do, req, policy)
+}
+
+// doFunc has the signature of IClient.Do, IClient decorators pass their own Do
+// to the retry helpers so that every attempt goes through the decorator
+type doFunc func(context.Context, *http.Request) (*http.Response, []byte, error)
+
+func makeRequestWithPolicy(ctx context.Context, do doFunc, url string, method string, reqBody *bytes.Buffer, headers map[string]string, policy RetryPolicy) error {
+	var body []byte
+	if reqBody != nil {
+		// Bytes does not drain the buffer, the request keeps its own copy to replay on every attempt
+		body = bytes.Clone(reqBody.Bytes())
+	}
+	_, err := doWithRetry(ctx, do, NewRequest(method, url, body).SetHeaders(headers), policy)
+	return err
+}
+
+func doWithRetry(ctx context.Context, do doFunc, req *Request, policy RetryPolicy) (*Result, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var body []byte
+	resp, attempts, err := policy.run(ctx, func(attempt int) (*http.Response, error) {
+		ctx := withAttempt(ctx, attempt)
+		httpReq, err := req.build(ctx)
+		if err != nil {
+			return nil, err
 		}
+		// Call the Do function to make the HTTP request, the response body is already consumed and closed
+		var resp *http.Response
+		resp, body, err = do(ctx, httpReq)
+		return resp, err
+	})
+	if resp == nil {
+		return nil, err
 	}
-	// If all retries failed, return the last error
-	return errors.New("failed to make request after multiple retries")
+	return &Result{Response: resp, Body: body, Attempts: attempts}, err
+}
+
+// onCloseBody calls onClose the first time the body is closed
+type onCloseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	onClose func()
+}
+
+func (b *onCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.onClose)
+	return err
 }
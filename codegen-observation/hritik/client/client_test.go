@@ -5,8 +5,16 @@ import (
 
 	"bytes"
 	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"syscall"
 
 	"github.com/nutanix-core/nai-api/iep/internal/client"
 	. "github.com/onsi/ginkgo/v2"
@@ -95,7 +103,10 @@ var _ = Describe("Test client functions", func() {
 
 			err := c.MakeRequestWithRetry(ctx, url, method, reqBody, headers, maxRetries, retryDelay)
 			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(Equal("failed to make request after multiple retries"))
+			Expect(err).To(MatchError(client.ErrRetriesExhausted))
+			var statusErr *client.StatusError
+			Expect(errors.As(err, &statusErr)).To(BeTrue())
+			Expect(statusErr.StatusCode).To(Equal(http.StatusInternalServerError))
 		})
 
 		It("should return an error when request creation fails", func() {
@@ -106,4 +117,192 @@ var _ = Describe("Test client functions", func() {
 			Expect(err).To(HaveOccurred())
 		})
 	})
+
+	Context("Test MakeRequestWithPolicy function", func() {
+		var (
+			policy = client.RetryPolicy{MaxAttempts: 3, InitialInterval: 10 * time.Millisecond, Multiplier: 2}
+			ctx    = context.Background()
+		)
+
+		It("should not retry a non retryable status code", func() {
+			attempts := 0
+			testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				attempts++
+				w.WriteHeader(http.StatusUnauthorized)
+			}))
+
+			err := c.MakeRequestWithPolicy(ctx, testServer.URL, http.MethodGet, nil, nil, policy)
+			Expect(err).To(HaveOccurred())
+			Expect(err).ToNot(MatchError(client.ErrRetriesExhausted))
+			var statusErr *client.StatusError
+			Expect(errors.As(err, &statusErr)).To(BeTrue())
+			Expect(statusErr.StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(attempts).To(Equal(1))
+		})
+
+		It("should honor the Retry-After header", func() {
+			attempts := 0
+			testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				attempts++
+				if attempts == 1 {
+					w.Header().Set("Retry-After", "1")
+					w.WriteHeader(http.StatusTooManyRequests)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			start := time.Now()
+			err := c.MakeRequestWithPolicy(ctx, testServer.URL, http.MethodGet, nil, nil, policy)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(attempts).To(Equal(2))
+			Expect(time.Since(start)).To(BeNumerically(">=", time.Second))
+		})
+
+		It("should give up once the max elapsed time would be exceeded", func() {
+			attempts := 0
+			testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				attempts++
+				w.Header().Set("Retry-After", "60")
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+
+			elapsedPolicy := policy
+			elapsedPolicy.MaxElapsedTime = time.Second
+			err := c.MakeRequestWithPolicy(ctx, testServer.URL, http.MethodGet, nil, nil, elapsedPolicy)
+			Expect(err).To(MatchError(client.ErrRetriesExhausted))
+			Expect(attempts).To(Equal(1))
+		})
+
+		It("should stop waiting when the context is cancelled", func() {
+			testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+			}))
+
+			cancelCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+			defer cancel()
+			slowPolicy := client.RetryPolicy{MaxAttempts: 5, InitialInterval: time.Minute}
+			start := time.Now()
+			err := c.MakeRequestWithPolicy(cancelCtx, testServer.URL, http.MethodGet, nil, nil, slowPolicy)
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+			Expect(time.Since(start)).To(BeNumerically("<", time.Minute))
+		})
+
+		It("should use a custom classifier", func() {
+			attempts := 0
+			testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				attempts++
+				w.WriteHeader(http.StatusConflict)
+			}))
+
+			customPolicy := policy
+			customPolicy.Classifier = func(resp *http.Response, _ error) bool {
+				return resp != nil && resp.StatusCode == http.StatusConflict
+			}
+			err := c.MakeRequestWithPolicy(ctx, testServer.URL, http.MethodGet, nil, nil, customPolicy)
+			Expect(err).To(MatchError(client.ErrRetriesExhausted))
+			Expect(attempts).To(Equal(3))
+		})
+
+		It("should cap the Retry-After delay", func() {
+			attempts := 0
+			testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				attempts++
+				w.Header().Set("Retry-After", "86400")
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+
+			cappedPolicy := policy
+			cappedPolicy.MaxInterval = 50 * time.Millisecond
+			start := time.Now()
+			err := c.MakeRequestWithPolicy(ctx, testServer.URL, http.MethodGet, nil, nil, cappedPolicy)
+			Expect(err).To(MatchError(client.ErrRetriesExhausted))
+			Expect(attempts).To(Equal(3))
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		})
+
+		It("should not overflow the backoff of an uncapped policy", func() {
+			attempts := 0
+			testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				attempts++
+				w.WriteHeader(http.StatusBadGateway)
+			}))
+
+			cancelCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+			defer cancel()
+			hugePolicy := client.RetryPolicy{MaxAttempts: 5, InitialInterval: time.Millisecond, Multiplier: math.MaxFloat64}
+			err := c.MakeRequestWithPolicy(cancelCtx, testServer.URL, http.MethodGet, nil, nil, hugePolicy)
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+			Expect(attempts).To(Equal(2))
+		})
+
+		It("should only retry network errors that can succeed on the next attempt", func() {
+			dial := func(err error) error {
+				return &url.Error{Op: "Get", URL: "http://engine", Err: &net.OpError{Op: "dial", Net: "tcp", Err: err}}
+			}
+			Expect(client.DefaultRetryClassifier(nil, dial(syscall.ECONNREFUSED))).To(BeTrue())
+			Expect(client.DefaultRetryClassifier(nil, dial(os.NewSyscallError("read", syscall.ECONNRESET)))).To(BeTrue())
+			Expect(client.DefaultRetryClassifier(nil, dial(&net.DNSError{Err: "i/o timeout", Name: "engine", IsTimeout: true}))).To(BeTrue())
+			Expect(client.DefaultRetryClassifier(nil, dial(&net.DNSError{Err: "no such host", Name: "engine", IsNotFound: true}))).To(BeFalse())
+			Expect(client.DefaultRetryClassifier(nil, dial(x509.UnknownAuthorityError{}))).To(BeFalse())
+		})
+	})
+
+	Context("Test response body size", func() {
+		var ctx = context.Background()
+
+		BeforeEach(func() {
+			testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("chunked") != "" {
+					// flushing before writing the body leaves the content length unknown
+					w.(http.Flusher).Flush()
+				}
+				_, _ = w.Write(bytes.Repeat([]byte("a"), 16))
+			}))
+		})
+
+		It("should read a body of exactly the maximum size", func() {
+			c := client.NewClient(client.WithMaxResponseBodySize(16))
+			req, err := http.NewRequest(http.MethodGet, testServer.URL, nil)
+			Expect(err).ToNot(HaveOccurred())
+			_, body, err := c.Do(ctx, req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(body).To(HaveLen(16))
+		})
+
+		It("should reject a body larger than the maximum size", func() {
+			c := client.NewClient(client.WithMaxResponseBodySize(8))
+			for _, url := range []string{testServer.URL, testServer.URL + "?chunked=true"} {
+				req, err := http.NewRequest(http.MethodGet, url, nil)
+				Expect(err).ToNot(HaveOccurred())
+				resp, body, err := c.Do(ctx, req)
+				Expect(err).To(MatchError(client.ErrResponseTooLarge))
+				var tooLarge *client.ResponseTooLargeError
+				Expect(errors.As(err, &tooLarge)).To(BeTrue())
+				Expect(tooLarge.Limit).To(Equal(int64(8)))
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(body).To(BeNil())
+			}
+		})
+
+		It("should not retry a body larger than the maximum size", func() {
+			c := client.NewClient(client.WithMaxResponseBodySize(8))
+			err := c.MakeRequestWithPolicy(ctx, testServer.URL, http.MethodGet, nil, nil, client.NewRetryPolicy(3, time.Millisecond))
+			Expect(err).To(MatchError(client.ErrResponseTooLarge))
+			Expect(err).ToNot(MatchError(client.ErrRetriesExhausted))
+		})
+
+		It("should stream the body without a size limit", func() {
+			c := client.NewClient(client.WithMaxResponseBodySize(8))
+			req, err := http.NewRequest(http.MethodGet, testServer.URL+"?chunked=true", nil)
+			Expect(err).ToNot(HaveOccurred())
+			resp, body, err := c.DoStream(ctx, req)
+			Expect(err).ToNot(HaveOccurred())
+			defer body.Close() //nolint:errcheck
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			data, err := io.ReadAll(body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(HaveLen(16))
+		})
+	})
 })
@@ -0,0 +1,231 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHedgeDelay is the delay before a hedge when neither a delay nor enough latencies are known
+	DefaultHedgeDelay = 100 * time.Millisecond
+	// DefaultHedgeWindowSize is the number of recent latencies the hedge percentile is computed from
+	DefaultHedgeWindowSize = 100
+	// MinHedgeSamples is the number of latencies needed before the hedge percentile is used
+	MinHedgeSamples = 10
+)
+
+// HedgeConfig configures a hedged client
+type HedgeConfig struct {
+	// Delay is the time waited before sending a hedge, DefaultHedgeDelay by default
+	Delay time.Duration
+	// Percentile, such as 0.95, sends a hedge once a request takes longer than this percentile
+	// of the recent latencies. Delay is used until MinHedgeSamples latencies were observed.
+	Percentile float64
+	// MaxHedges is the number of duplicate requests sent at most for a request, 1 by default
+	MaxHedges int
+	// WindowSize is the number of recent latencies the percentile is computed from, DefaultHedgeWindowSize by default
+	WindowSize int
+}
+
+// hedgedClient wraps an IClient sending duplicate requests when the first one is slow
+type hedgedClient struct {
+	next   IClient
+	config HedgeConfig
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	nextIndex int
+}
+
+// NewHedgedClient returns an IClient that sends a duplicate of a GET, HEAD or OPTIONS request
+// when no response arrived after the hedge delay, uses the first response received and cancels
+// the other requests. Other methods and requests whose body cannot be replayed are sent once.
+func NewHedgedClient(next IClient, config HedgeConfig) IClient {
+	if config.Delay <= 0 {
+		config.Delay = DefaultHedgeDelay
+	}
+	if config.MaxHedges <= 0 {
+		config.MaxHedges = 1
+	}
+	if config.WindowSize <= 0 {
+		config.WindowSize = DefaultHedgeWindowSize
+	}
+	return &hedgedClient{next: next, config: config}
+}
+
+func (h *hedgedClient) SetTimeout(timeout time.Duration) {
+	h.next.SetTimeout(timeout)
+}
+
+func (h *hedgedClient) GetTimeout() time.Duration {
+	return h.next.GetTimeout()
+}
+
+// Do makes the http request, hedging it when it is idempotent
+func (h *hedgedClient) Do(ctx context.Context, req *http.Request) (*http.Response, []byte, error) {
+	if !hedgeable(req) {
+		return h.next.Do(ctx, req)
+	}
+	resp, body, done, err := hedge(ctx, h, req, h.next.Do, func([]byte) {})
+	// the body is already read, the request of the winner can be released
+	done()
+	return resp, body, err
+}
+
+// DoStream makes the http request, hedging it until the response headers arrive when it is idempotent
+func (h *hedgedClient) DoStream(ctx context.Context, req *http.Request) (*http.Response, io.ReadCloser, error) {
+	if !hedgeable(req) {
+		return h.next.DoStream(ctx, req)
+	}
+	resp, body, done, err := hedge(ctx, h, req, h.next.DoStream, func(body io.ReadCloser) {
+		if body != nil {
+			_ = body.Close()
+		}
+	})
+	if err != nil {
+		done()
+		return resp, body, err
+	}
+	body = &onCloseBody{ReadCloser: body, onClose: done}
+	resp.Body = body
+	return resp, body, nil
+}
+
+func (h *hedgedClient) MakeRequestWithRetry(ctx context.Context, url string, method string, reqBody *bytes.Buffer, headers map[string]string, maxRetries int, retryDelay time.Duration) error {
+	return makeRequestWithPolicy(ctx, h.Do, url, method, reqBody, headers, NewRetryPolicy(maxRetries, retryDelay))
+}
+
+func (h *hedgedClient) MakeRequestWithPolicy(ctx context.Context, url string, method string, reqBody *bytes.Buffer, headers map[string]string, policy RetryPolicy) error {
+	return makeRequestWithPolicy(ctx, h.Do, url, method, reqBody, headers, policy)
+}
+
+func (h *hedgedClient) DoWithRetry(ctx context.Context, req *Request, policy RetryPolicy) (*Result, error) {
+	return doWithRetry(ctx, h.Do, req, policy)
+}
+
+// hedgeable reports whether req is idempotent and can be sent more than once
+func hedgeable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// hedgeResult is the outcome of one of the requests sent for a hedged request
+type hedgeResult[B any] struct {
+	resp    *http.Response
+	body    B
+	err     error
+	latency time.Duration
+	// index is the position of the request in the order they were sent
+	index int
+}
+
+// hedge sends req and up to MaxHedges duplicates of it, each one after the hedge delay, until a response arrives.
+// The other requests are cancelled and the bodies they may still return are passed to discard.
+// The returned function releases the context of the winning request.
+func hedge[B any](ctx context.Context, h *hedgedClient, req *http.Request,
+	do func(context.Context, *http.Request) (*http.Response, B, error), discard func(B)) (*http.Response, B, func(), error) {
+	if ctx == nil {
+		ctx = req.Context()
+	}
+	results := make(chan hedgeResult[B], h.config.MaxHedges+1)
+	var cancels []context.CancelFunc
+	send := func() {
+		index := len(cancels)
+		attemptCtx, cancel := context.WithCancel(ctx)
+		cancels = append(cancels, cancel)
+		attemptReq := req.Clone(attemptCtx)
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				results <- hedgeResult[B]{err: err, index: index}
+				return
+			}
+			attemptReq.Body = body
+		}
+		go func() {
+			start := time.Now()
+			resp, body, err := do(attemptCtx, attemptReq)
+			results <- hedgeResult[B]{resp: resp, body: body, err: err, latency: time.Since(start), index: index}
+		}()
+	}
+
+	send()
+	pending := 1
+	timer := time.NewTimer(h.delay())
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if len(cancels) <= h.config.MaxHedges {
+				send()
+				pending++
+				timer.Reset(h.delay())
+			}
+		case result := <-results:
+			pending--
+			if result.err != nil && pending > 0 {
+				// another request may still succeed
+				cancels[result.index]()
+				continue
+			}
+			if result.err == nil {
+				h.observe(result.latency)
+			}
+			for i, cancel := range cancels {
+				if i != result.index {
+					cancel()
+				}
+			}
+			go func(pending int) {
+				for ; pending > 0; pending-- {
+					if lost := <-results; lost.err == nil {
+						discard(lost.body)
+					}
+				}
+			}(pending)
+			return result.resp, result.body, cancels[result.index], result.err
+		}
+	}
+}
+
+// delay returns the time to wait before sending a hedge
+func (h *hedgedClient) delay() time.Duration {
+	if h.config.Percentile <= 0 {
+		return h.config.Delay
+	}
+	h.mu.Lock()
+	if len(h.latencies) < MinHedgeSamples {
+		h.mu.Unlock()
+		return h.config.Delay
+	}
+	latencies := append([]time.Duration(nil), h.latencies...)
+	h.mu.Unlock()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	index := int(h.config.Percentile * float64(len(latencies)))
+	if index >= len(latencies) {
+		index = len(latencies) - 1
+	}
+	return latencies[index]
+}
+
+// observe adds the latency of a successful request to the window
+func (h *hedgedClient) observe(latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.latencies) < h.config.WindowSize {
+		h.latencies = append(h.latencies, latency)
+		return
+	}
+	h.latencies[h.nextIndex] = latency
+	h.nextIndex = (h.nextIndex + 1) % h.config.WindowSize
+}
@@ -0,0 +1,123 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"github.com/nutanix-core/nai-api/iep/internal/client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Test hedged client", func() {
+
+	var (
+		testServer *httptest.Server
+		requests   atomic.Int32
+		cancelled  atomic.Int32
+		// slowRequests is the number of first requests answered slowly
+		slowRequests atomic.Int32
+		ctx          = context.Background()
+	)
+
+	BeforeEach(func() {
+		requests.Store(0)
+		cancelled.Store(0)
+		slowRequests.Store(1)
+		testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			if requests.Add(1) <= slowRequests.Load() {
+				select {
+				case <-r.Context().Done():
+					cancelled.Add(1)
+					return
+				case <-time.After(time.Second):
+				}
+			}
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(body)
+		}))
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	It("should use the response of the hedge and cancel the slow request", func() {
+		c := client.NewHedgedClient(client.NewClient(), client.HedgeConfig{Delay: 20 * time.Millisecond})
+		req, err := http.NewRequest(http.MethodGet, testServer.URL, bytes.NewBufferString("ping"))
+		Expect(err).ToNot(HaveOccurred())
+
+		start := time.Now()
+		resp, body, err := c.Do(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(string(body)).To(Equal("ping"))
+		Expect(time.Since(start)).To(BeNumerically("<", 500*time.Millisecond))
+		Expect(requests.Load()).To(Equal(int32(2)))
+		Eventually(cancelled.Load).Should(Equal(int32(1)))
+	})
+
+	It("should not hedge fast requests", func() {
+		slowRequests.Store(0)
+		c := client.NewHedgedClient(client.NewClient(), client.HedgeConfig{Delay: 100 * time.Millisecond})
+		req, err := http.NewRequest(http.MethodGet, testServer.URL, nil)
+		Expect(err).ToNot(HaveOccurred())
+		_, _, err = c.Do(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Consistently(requests.Load, 150*time.Millisecond).Should(Equal(int32(1)))
+	})
+
+	It("should not hedge non idempotent requests", func() {
+		c := client.NewHedgedClient(client.NewClient(), client.HedgeConfig{Delay: 10 * time.Millisecond})
+		err := c.MakeRequestWithRetry(ctx, testServer.URL, http.MethodPost, bytes.NewBufferString("ping"), nil, 1, time.Millisecond)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(requests.Load()).To(Equal(int32(1)))
+	})
+
+	It("should cap the number of hedges", func() {
+		slowRequests.Store(10)
+		c := client.NewHedgedClient(client.NewClient(), client.HedgeConfig{Delay: 10 * time.Millisecond, MaxHedges: 2})
+		req, err := http.NewRequest(http.MethodGet, testServer.URL, nil)
+		Expect(err).ToNot(HaveOccurred())
+		_, _, err = c.Do(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(requests.Load()).To(Equal(int32(3)))
+	})
+
+	It("should hedge after the latency percentile", func() {
+		slowRequests.Store(0)
+		c := client.NewHedgedClient(client.NewClient(), client.HedgeConfig{Delay: time.Minute, Percentile: 0.9})
+		for i := 0; i < client.MinHedgeSamples; i++ {
+			req, err := http.NewRequest(http.MethodGet, testServer.URL, nil)
+			Expect(err).ToNot(HaveOccurred())
+			_, _, err = c.Do(ctx, req)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		slowRequests.Store(client.MinHedgeSamples + 1)
+		req, err := http.NewRequest(http.MethodGet, testServer.URL, nil)
+		Expect(err).ToNot(HaveOccurred())
+		start := time.Now()
+		_, _, err = c.Do(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(time.Since(start)).To(BeNumerically("<", 500*time.Millisecond))
+	})
+
+	It("should hedge streams until the headers arrive", func() {
+		c := client.NewHedgedClient(client.NewClient(), client.HedgeConfig{Delay: 20 * time.Millisecond})
+		req, err := http.NewRequest(http.MethodGet, testServer.URL, bytes.NewBufferString("ping"))
+		Expect(err).ToNot(HaveOccurred())
+		_, body, err := c.DoStream(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		data, err := io.ReadAll(body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(data)).To(Equal("ping"))
+		Expect(body.Close()).To(Succeed())
+	})
+})
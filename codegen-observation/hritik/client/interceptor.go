@@ -0,0 +1,125 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nutanix-core/nai-api/common/logger"
+	"github.com/nutanix-core/nai-api/iep/internal/tracing"
+)
+
+// Interceptor wraps a round tripper to add behavior to every request sent by the client
+type Interceptor func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc adapts a function to the http.RoundTripper interface
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip calls f(req)
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// chain wraps transport with interceptors so that the first interceptor is the outermost one
+func chain(transport http.RoundTripper, interceptors []Interceptor) http.RoundTripper {
+	if transport == nil && len(interceptors) == 0 {
+		// let http.Client fall back to http.DefaultTransport
+		return nil
+	}
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		transport = interceptors[i](transport)
+	}
+	return transport
+}
+
+// HeaderInterceptor sets headers on every request that does not already carry them
+func HeaderInterceptor(headers map[string]string) Interceptor {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			// round trippers must not modify the request they are given
+			req = req.Clone(req.Context())
+			for key, value := range headers {
+				if req.Header.Get(key) == "" {
+					req.Header.Set(key, value)
+				}
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// BearerTokenInterceptor sets the Authorization header of requests that do not carry one
+// with the token returned by token, it is TokenSourceInterceptor with a source calling token
+func BearerTokenInterceptor(token func(ctx context.Context) (string, error)) Interceptor {
+	return TokenSourceInterceptor(tokenFunc(token))
+}
+
+// LoggingInterceptor logs every request with its outcome and duration.
+// Query parameters and user info are left out as they may carry credentials.
+func LoggingInterceptor(log logger.Logger) Interceptor {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			target := redactURL(req)
+			start := time.Now()
+			resp, err := next.RoundTrip(req)
+			duration := time.Since(start).Milliseconds()
+			if err != nil {
+				log.Error(fmt.Sprintf("Outbound request %s %s failed after %d ms: %v", req.Method, target, duration, err))
+				return resp, err
+			}
+			log.Debug(fmt.Sprintf("Outbound request %s %s returned %d in %d ms", req.Method, target, resp.StatusCode, duration))
+			return resp, err
+		})
+	}
+}
+
+// TracingInterceptor records a client span for every request and propagates it to the server
+func TracingInterceptor(tracer *tracing.Tracer) Interceptor {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			ctx, span := tracer.Start(req.Context(), "HTTP "+req.Method, tracing.SpanKindClient)
+			defer span.End()
+			span.SetAttribute("http.request.method", req.Method)
+			span.SetAttribute("server.address", req.URL.Host)
+			span.SetAttribute("url.full", redactURL(req))
+
+			req = req.Clone(ctx)
+			tracing.Inject(ctx, req.Header)
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				span.SetError(err.Error())
+				return resp, err
+			}
+			span.SetAttribute("http.response.status_code", resp.StatusCode)
+			if resp.StatusCode >= http.StatusInternalServerError {
+				span.SetError(http.StatusText(resp.StatusCode))
+			}
+			return resp, err
+		})
+	}
+}
+
+// redactURL returns the request url without user info, query and fragment
+func redactURL(req *http.Request) string {
+	u := *req.URL
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
+
+// redactRawURL is redactURL for urls that are not parsed yet, the ones that cannot be parsed are cut at their query
+func redactRawURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		rawURL, _, _ = strings.Cut(rawURL, "?")
+		return rawURL
+	}
+	return redactURL(&http.Request{URL: u})
+}
@@ -0,0 +1,221 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/nutanix-core/nai-api/common/logger"
+	"github.com/nutanix-core/nai-api/iep/internal/client"
+	"github.com/nutanix-core/nai-api/iep/internal/tracing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Test client interceptors", func() {
+
+	var (
+		testServer *httptest.Server
+		ctx        = context.Background()
+	)
+
+	BeforeEach(func() {
+		testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Test-Header", r.Header.Get("X-Test-Header"))
+			w.Header().Set("X-Authorization", r.Header.Get("Authorization"))
+			if r.URL.Path == "/protected" && r.Header.Get("Authorization") == "Bearer rejected" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	recordingInterceptor := func(name string, calls *[]string) client.Interceptor {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return client.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				*calls = append(*calls, name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+
+	It("should keep the default client working without options", func() {
+		c := client.NewClient()
+		req, err := http.NewRequest(http.MethodGet, testServer.URL, nil)
+		Expect(err).ToNot(HaveOccurred())
+		resp, _, err := c.Do(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	})
+
+	It("should set the initial timeout", func() {
+		c := client.NewClient(client.WithTimeout(3 * time.Second))
+		Expect(c.GetTimeout()).To(Equal(3 * time.Second))
+	})
+
+	It("should call interceptors in order", func() {
+		var calls []string
+		c := client.NewClient(
+			client.WithInterceptors(recordingInterceptor("first", &calls), recordingInterceptor("second", &calls)),
+			client.WithInterceptors(recordingInterceptor("third", &calls)),
+		)
+		req, err := http.NewRequest(http.MethodGet, testServer.URL, nil)
+		Expect(err).ToNot(HaveOccurred())
+		_, _, err = c.Do(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(calls).To(Equal([]string{"first", "second", "third"}))
+	})
+
+	It("should use a custom transport", func() {
+		transport := client.RoundTripperFunc(func(_ *http.Request) (*http.Response, error) {
+			return httptest.NewRecorder().Result(), nil
+		})
+		c := client.NewClient(client.WithTransport(transport))
+		req, err := http.NewRequest(http.MethodGet, "http://engine.invalid/health", nil)
+		Expect(err).ToNot(HaveOccurred())
+		resp, _, err := c.Do(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	})
+
+	It("should inject headers without overriding request headers", func() {
+		c := client.NewClient(client.WithInterceptors(client.HeaderInterceptor(map[string]string{"X-Test-Header": "default"})))
+		req, err := http.NewRequest(http.MethodGet, testServer.URL, nil)
+		Expect(err).ToNot(HaveOccurred())
+		resp, _, err := c.Do(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.Header.Get("X-Test-Header")).To(Equal("default"))
+		Expect(req.Header.Get("X-Test-Header")).To(BeEmpty())
+
+		req.Header.Set("X-Test-Header", "custom")
+		resp, _, err = c.Do(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.Header.Get("X-Test-Header")).To(Equal("custom"))
+	})
+
+	It("should source bearer tokens", func() {
+		c := client.NewClient(client.WithInterceptors(client.BearerTokenInterceptor(func(context.Context) (string, error) {
+			return "mock_token", nil
+		})))
+		req, err := http.NewRequest(http.MethodGet, testServer.URL, nil)
+		Expect(err).ToNot(HaveOccurred())
+		resp, _, err := c.Do(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.Header.Get("X-Authorization")).To(Equal("Bearer mock_token"))
+	})
+
+	It("should retry rejected bearer tokens once with a new token", func() {
+		tokens := []string{"rejected", "mock_token"}
+		c := client.NewClient(client.WithInterceptors(client.BearerTokenInterceptor(func(context.Context) (string, error) {
+			token := tokens[0]
+			tokens = tokens[1:]
+			return token, nil
+		})))
+		req, err := http.NewRequest(http.MethodGet, testServer.URL+"/protected", nil)
+		Expect(err).ToNot(HaveOccurred())
+		resp, _, err := c.Do(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("X-Authorization")).To(Equal("Bearer mock_token"))
+	})
+
+	It("should fail the request when no token can be sourced", func() {
+		tokenErr := errors.New("token unavailable")
+		c := client.NewClient(client.WithInterceptors(client.BearerTokenInterceptor(func(context.Context) (string, error) {
+			return "", tokenErr
+		})))
+		req, err := http.NewRequest(http.MethodGet, testServer.URL, nil)
+		Expect(err).ToNot(HaveOccurred())
+		_, _, err = c.Do(ctx, req)
+		Expect(err).To(MatchError(tokenErr))
+	})
+
+	It("should log requests without changing the outcome", func() {
+		log := &recordingLogger{Logger: logger.NewZAPLogger()}
+		c := client.NewClient(client.WithInterceptors(client.LoggingInterceptor(log)))
+		req, err := http.NewRequest(http.MethodGet, testServer.URL+"/v1/models?token=secret", nil)
+		Expect(err).ToNot(HaveOccurred())
+		resp, _, err := c.Do(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(log.debugs).To(HaveExactElements(MatchRegexp(`^Outbound request GET %s/v1/models returned 200 in \d+ ms$`, testServer.URL)))
+
+		testServer.Close()
+		_, _, err = c.Do(ctx, req)
+		Expect(err).To(HaveOccurred())
+		Expect(log.errors).To(HaveExactElements(MatchRegexp(`^Outbound request GET %s/v1/models failed after \d+ ms: .+`, testServer.URL)))
+		Expect(append(log.debugs, log.errors...)).ToNot(ContainElement(ContainSubstring("secret")))
+	})
+
+	It("should propagate the trace context of the caller", func() {
+		var received string
+		testServer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r.Header.Get(tracing.TraceparentHeader)
+			w.WriteHeader(http.StatusOK)
+		})
+		spanCtx, span := tracing.NewTracer("nai-api", nil).Start(ctx, "parent", tracing.SpanKindServer)
+		req, err := http.NewRequest(http.MethodGet, testServer.URL, nil)
+		Expect(err).ToNot(HaveOccurred())
+		_, _, err = client.NewClient().Do(spanCtx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(received).To(Equal(span.SpanContext().Traceparent()))
+		Expect(req.Header.Get(tracing.TraceparentHeader)).To(BeEmpty())
+	})
+
+	It("should record client spans", func() {
+		var (
+			received string
+			spans    []tracing.SpanData
+		)
+		testServer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r.Header.Get(tracing.TraceparentHeader)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+		tracer := tracing.NewTracer("nai-api", exporterFunc(func(data tracing.SpanData) error {
+			spans = append(spans, data)
+			return nil
+		}))
+		spanCtx, parent := tracer.Start(ctx, "parent", tracing.SpanKindServer)
+		c := client.NewClient(client.WithInterceptors(client.TracingInterceptor(tracer)))
+		req, err := http.NewRequest(http.MethodGet, testServer.URL+"/v2/health/ready", nil)
+		Expect(err).ToNot(HaveOccurred())
+		_, _, err = c.Do(spanCtx, req)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(spans).To(HaveLen(1))
+		Expect(spans[0].Kind).To(Equal(tracing.SpanKindClient))
+		Expect(spans[0].ParentSpanID).To(Equal(parent.SpanContext().SpanID))
+		Expect(spans[0].Attributes).To(HaveKeyWithValue("http.response.status_code", http.StatusServiceUnavailable))
+		Expect(spans[0].Error).To(BeTrue())
+		Expect(received).To(Equal(spans[0].SpanContext.Traceparent()))
+	})
+})
+
+// recordingLogger keeps the debug and error messages logged
+type recordingLogger struct {
+	logger.Logger
+	debugs []string
+	errors []string
+}
+
+func (l *recordingLogger) Debug(msg string) {
+	l.debugs = append(l.debugs, msg)
+}
+
+func (l *recordingLogger) Error(msg string) {
+	l.errors = append(l.errors, msg)
+}
+
+// exporterFunc adapts a function to the tracing.Exporter interface
+type exporterFunc func(tracing.SpanData) error
+
+func (f exporterFunc) ExportSpan(data tracing.SpanData) error {
+	return f(data)
+}
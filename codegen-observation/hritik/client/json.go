@@ -0,0 +1,169 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	// MaxHTTPErrorBodySize is the number of response body bytes kept in an HTTPError
+	MaxHTTPErrorBodySize = 1024
+
+	contentTypeJSON = "application/json"
+)
+
+// HTTPError is returned by the JSON helpers when the server answers with a non 2xx status code
+type HTTPError struct {
+	StatusCode int
+	Method     string
+	// URL is the request url without user info, query and fragment, which often carry credentials
+	URL string
+	// Body is the response body, truncated to MaxHTTPErrorBodySize bytes
+	Body string
+	// Message is the error message found in a JSON error body, if any
+	Message string
+	// Attempts is the number of attempts made before giving up
+	Attempts int
+	// Err is the retry error of the last attempt
+	Err error
+}
+
+func (e *HTTPError) Error() string {
+	msg := fmt.Sprintf("%s %s failed with status code %d after %d attempt(s)", e.Method, e.URL, e.StatusCode, e.Attempts)
+	if e.Message != "" {
+		return msg + ": " + e.Message
+	}
+	return msg
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// JSONOption configures a single JSON call
+type JSONOption func(*jsonOptions)
+
+type jsonOptions struct {
+	headers map[string]string
+	policy  RetryPolicy
+}
+
+// WithJSONHeaders adds headers to the request, they override the default content-type and accept headers
+func WithJSONHeaders(headers map[string]string) JSONOption {
+	return func(o *jsonOptions) {
+		o.headers = headers
+	}
+}
+
+// WithJSONRetryPolicy retries the call with the given policy, by default a single attempt is made
+func WithJSONRetryPolicy(policy RetryPolicy) JSONOption {
+	return func(o *jsonOptions) {
+		o.policy = policy
+	}
+}
+
+// DoJSON encodes reqBody as JSON, sends it and decodes a successful response body into Resp.
+// Unsuccessful responses are returned as *HTTPError.
+func DoJSON[Req any, Resp any](ctx context.Context, c IClient, method string, url string, reqBody Req, opts ...JSONOption) (Resp, error) {
+	body, err := json.Marshal(reqBody)
+	if err != nil {
+		var resp Resp
+		return resp, fmt.Errorf("failed to encode request for %s: %w", url, err)
+	}
+	return doJSON[Resp](ctx, c, NewRequest(method, url, body), opts...)
+}
+
+// PostJSON sends reqBody as JSON with a POST request and decodes the response into Resp
+func PostJSON[Req any, Resp any](ctx context.Context, c IClient, url string, reqBody Req, opts ...JSONOption) (Resp, error) {
+	return DoJSON[Req, Resp](ctx, c, http.MethodPost, url, reqBody, opts...)
+}
+
+// GetJSON sends a GET request and decodes the response into Resp
+func GetJSON[Resp any](ctx context.Context, c IClient, url string, opts ...JSONOption) (Resp, error) {
+	return doJSON[Resp](ctx, c, NewRequest(http.MethodGet, url, nil), opts...)
+}
+
+func doJSON[Resp any](ctx context.Context, c IClient, req *Request, opts ...JSONOption) (Resp, error) {
+	var resp Resp
+	options := jsonOptions{policy: RetryPolicy{MaxAttempts: 1}}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if req.Body() != nil {
+		req.Header.Set("Content-Type", contentTypeJSON)
+	}
+	req.Header.Set("Accept", contentTypeJSON)
+	req.SetHeaders(options.headers)
+
+	result, err := c.DoWithRetry(ctx, req, options.policy)
+	if result != nil && (result.Response.StatusCode < 200 || result.Response.StatusCode > 299) {
+		return resp, newHTTPError(req, result, err)
+	}
+	if err != nil {
+		return resp, err
+	}
+
+	if len(result.Body) == 0 {
+		return resp, nil
+	}
+	if err := json.Unmarshal(result.Body, &resp); err != nil {
+		return resp, fmt.Errorf("failed to decode response from %s: %w", redactRawURL(req.URL), err)
+	}
+	return resp, nil
+}
+
+func newHTTPError(req *Request, result *Result, err error) *HTTPError {
+	body := result.Body
+	if len(body) > MaxHTTPErrorBodySize {
+		body = body[:MaxHTTPErrorBodySize]
+	}
+	if err == nil {
+		err = &StatusError{StatusCode: result.Response.StatusCode}
+	}
+	return &HTTPError{
+		StatusCode: result.Response.StatusCode,
+		Method:     req.Method,
+		URL:        redactRawURL(req.URL),
+		Body:       string(body),
+		Message:    errorMessage(result.Body),
+		Attempts:   result.Attempts,
+		Err:        err,
+	}
+}
+
+// errorMessage extracts the message of the common JSON error shapes returned by
+// this API, OpenAI compatible engines, KServe and the HF hub
+func errorMessage(body []byte) string {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return ""
+	}
+	for _, key := range []string{"error", "message", "msg", "detail"} {
+		raw, ok := payload[key]
+		if !ok {
+			continue
+		}
+		var msg string
+		if err := json.Unmarshal(raw, &msg); err == nil && msg != "" {
+			return msg
+		}
+		// OpenAI style errors nest the message, e.g. {"error": {"message": "..."}}
+		var nested struct {
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(raw, &nested); err == nil && nested.Message != "" {
+			return nested.Message
+		}
+	}
+	return ""
+}
+
+// IsHTTPStatus reports whether err is an HTTPError with the given status code
+func IsHTTPStatus(err error, statusCode int) bool {
+	var httpErr *HTTPError
+	return errors.As(err, &httpErr) && httpErr.StatusCode == statusCode
+}
@@ -0,0 +1,153 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/nutanix-core/nai-api/iep/internal/client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type jsonTestRequest struct {
+	Prompt string `json:"prompt"`
+}
+
+type jsonTestResponse struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+}
+
+var _ = Describe("Test JSON helpers", func() {
+
+	var (
+		testServer *httptest.Server
+		c          client.IClient
+		ctx        = context.Background()
+	)
+
+	BeforeEach(func() {
+		c = client.NewClient()
+	})
+
+	AfterEach(func() {
+		if testServer != nil {
+			testServer.Close()
+		}
+	})
+
+	Context("Test PostJSON function", func() {
+		It("should encode the request and decode the response", func() {
+			testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.Method).To(Equal(http.MethodPost))
+				Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
+				Expect(r.Header.Get("Accept")).To(Equal("application/json"))
+				Expect(r.Header.Get("Authorization")).To(Equal("Bearer mock_token"))
+				var req jsonTestRequest
+				Expect(json.NewDecoder(r.Body).Decode(&req)).To(Succeed())
+				_ = json.NewEncoder(w).Encode(jsonTestResponse{ID: "1", Text: req.Prompt})
+			}))
+
+			resp, err := client.PostJSON[jsonTestRequest, jsonTestResponse](ctx, c, testServer.URL, jsonTestRequest{Prompt: "text"},
+				client.WithJSONHeaders(map[string]string{"Authorization": "Bearer mock_token"}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp).To(Equal(jsonTestResponse{ID: "1", Text: "text"}))
+		})
+
+		It("should return a decode error for an invalid body", func() {
+			testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte(`not json`))
+			}))
+
+			_, err := client.PostJSON[jsonTestRequest, jsonTestResponse](ctx, c, testServer.URL, jsonTestRequest{Prompt: "text"})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("failed to decode response"))
+		})
+	})
+
+	Context("Test GetJSON function", func() {
+		It("should not send a content type without a body", func() {
+			testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.Header.Get("Content-Type")).To(BeEmpty())
+				_ = json.NewEncoder(w).Encode(jsonTestResponse{ID: "1"})
+			}))
+
+			resp, err := client.GetJSON[jsonTestResponse](ctx, c, testServer.URL)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.ID).To(Equal("1"))
+		})
+
+		It("should return the zero value for an empty body", func() {
+			testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}))
+
+			resp, err := client.GetJSON[jsonTestResponse](ctx, c, testServer.URL)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp).To(Equal(jsonTestResponse{}))
+		})
+	})
+
+	Context("Test HTTPError", func() {
+		It("should decode an OpenAI style error body", func() {
+			testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error": {"message": "invalid prompt", "type": "invalid_request_error"}}`))
+			}))
+
+			_, err := client.PostJSON[jsonTestRequest, jsonTestResponse](ctx, c, testServer.URL, jsonTestRequest{})
+			var httpErr *client.HTTPError
+			Expect(errors.As(err, &httpErr)).To(BeTrue())
+			Expect(httpErr.StatusCode).To(Equal(http.StatusBadRequest))
+			Expect(httpErr.Method).To(Equal(http.MethodPost))
+			Expect(httpErr.URL).To(Equal(testServer.URL))
+			Expect(httpErr.Message).To(Equal("invalid prompt"))
+			Expect(httpErr.Attempts).To(Equal(1))
+			Expect(client.IsHTTPStatus(err, http.StatusBadRequest)).To(BeTrue())
+		})
+
+		It("should not keep the credentials of the url", func() {
+			testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusForbidden)
+			}))
+
+			_, err := client.GetJSON[jsonTestResponse](ctx, c, testServer.URL+"/v1/models?token=secret")
+			var httpErr *client.HTTPError
+			Expect(errors.As(err, &httpErr)).To(BeTrue())
+			Expect(httpErr.URL).To(Equal(testServer.URL + "/v1/models"))
+			Expect(err.Error()).ToNot(ContainSubstring("secret"))
+		})
+
+		It("should truncate the body and count the attempts", func() {
+			testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = w.Write([]byte(strings.Repeat("a", 2*client.MaxHTTPErrorBodySize)))
+			}))
+
+			policy := client.RetryPolicy{MaxAttempts: 2, InitialInterval: 10 * time.Millisecond}
+			_, err := client.GetJSON[jsonTestResponse](ctx, c, testServer.URL, client.WithJSONRetryPolicy(policy))
+			var httpErr *client.HTTPError
+			Expect(errors.As(err, &httpErr)).To(BeTrue())
+			Expect(httpErr.Body).To(HaveLen(client.MaxHTTPErrorBodySize))
+			Expect(httpErr.Message).To(BeEmpty())
+			Expect(httpErr.Attempts).To(Equal(2))
+			Expect(err).To(MatchError(client.ErrRetriesExhausted))
+		})
+
+		It("should return transport errors as they are", func() {
+			testServer = httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+			url := testServer.URL
+			testServer.Close()
+
+			_, err := client.GetJSON[jsonTestResponse](ctx, c, url)
+			Expect(err).To(HaveOccurred())
+			var httpErr *client.HTTPError
+			Expect(errors.As(err, &httpErr)).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,211 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// OtherDestination labels requests whose url matches no destination template
+	OtherDestination = "other"
+	// StatusClassError is the status class of requests that received no response
+	StatusClassError = "error"
+)
+
+// RequestMetrics are the metrics of a single outbound request attempt
+type RequestMetrics struct {
+	// Destination is the destination template matched by the request url, or OtherDestination
+	Destination string
+	Method      string
+	// StatusClass is the class of the response status code such as 2xx, or StatusClassError
+	StatusClass string
+	// Attempt is the 1-based attempt number of the request, attempts above 1 are retries
+	Attempt int
+	// LatencyMs is the time from sending the request to the end of the response body
+	LatencyMs     int64
+	BytesSent     int64
+	BytesReceived int64
+}
+
+// IMetricsRecorder records the metrics of outbound requests, see NewInstrumentationMetricsRecorder
+type IMetricsRecorder interface {
+	RecordRequestMetrics(ctx context.Context, metrics RequestMetrics)
+}
+
+// IInstrumentationService is the part of service.IMetricInstrumentationService recording metrics
+type IInstrumentationService interface {
+	RecordInferenceMetrics(c *gin.Context, endpointID string, status string, latency int64)
+}
+
+// NewInstrumentationMetricsRecorder records the metrics of outbound requests through service.IMetricInstrumentationService.
+// A service implementing IMetricsRecorder records them itself. Otherwise every attempt is recorded with
+// RecordInferenceMetrics, its destination as endpoint id and its status class as status. The attempt and byte
+// counts have no label there and are dropped. The gin context of the inbound request the outbound one is made
+// for is passed along, requests made outside of one get a gin context wrapping their context.
+func NewInstrumentationMetricsRecorder(service IInstrumentationService) IMetricsRecorder {
+	if recorder, ok := service.(IMetricsRecorder); ok {
+		return recorder
+	}
+	return &instrumentationMetricsRecorder{service: service}
+}
+
+type instrumentationMetricsRecorder struct {
+	service IInstrumentationService
+}
+
+func (r *instrumentationMetricsRecorder) RecordRequestMetrics(ctx context.Context, metrics RequestMetrics) {
+	c, ok := ctx.Value(gin.ContextKey).(*gin.Context)
+	if !ok {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+		c = &gin.Context{Request: req}
+	}
+	r.service.RecordInferenceMetrics(c, metrics.Destination, metrics.StatusClass, metrics.LatencyMs)
+}
+
+// MetricsInterceptor records the metrics of every request once its response body is read or closed.
+// Destinations are labeled with the first template matching the host and path of the request url,
+// which keeps the label cardinality low. Templates have the form "{endpoint}.nai-admin.svc.cluster.local/v2/health/live"
+// or "huggingface.co/api/models/{org}/{model}", where a {placeholder} matches one host label or path segment,
+// and a final {placeholder...} matches the rest of the path. A template host without port matches any port.
+func MetricsInterceptor(recorder IMetricsRecorder, templates ...string) Interceptor {
+	parsed := make([]destinationTemplate, 0, len(templates))
+	for _, template := range templates {
+		parsed = append(parsed, parseDestinationTemplate(template))
+	}
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			metrics := RequestMetrics{
+				Destination: destination(parsed, req),
+				Method:      req.Method,
+				Attempt:     AttemptFromContext(req.Context()),
+			}
+			if req.ContentLength > 0 {
+				metrics.BytesSent = req.ContentLength
+			}
+			start := time.Now()
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				metrics.StatusClass = StatusClassError
+				metrics.LatencyMs = time.Since(start).Milliseconds()
+				recorder.RecordRequestMetrics(req.Context(), metrics)
+				return resp, err
+			}
+			metrics.StatusClass = statusClass(resp.StatusCode)
+			resp.Body = &meteredBody{ReadCloser: resp.Body, done: func(received int64) {
+				metrics.LatencyMs = time.Since(start).Milliseconds()
+				metrics.BytesReceived = received
+				recorder.RecordRequestMetrics(req.Context(), metrics)
+			}}
+			return resp, nil
+		})
+	}
+}
+
+// meteredBody counts the bytes read and calls done once the body is read to its end or closed
+type meteredBody struct {
+	io.ReadCloser
+	read int64
+	once sync.Once
+	done func(received int64)
+}
+
+func (b *meteredBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if err != nil {
+		b.once.Do(func() { b.done(b.read) })
+	}
+	return n, err
+}
+
+func (b *meteredBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.done(b.read) })
+	return err
+}
+
+func statusClass(code int) string {
+	if code < 100 || code >= 600 {
+		return StatusClassError
+	}
+	return fmt.Sprintf("%dxx", code/100)
+}
+
+// destinationTemplate is a parsed destination template
+type destinationTemplate struct {
+	name   string
+	host   []string
+	port   string
+	path   []string
+	prefix bool
+}
+
+func parseDestinationTemplate(template string) destinationTemplate {
+	t := destinationTemplate{name: template}
+	host, path, _ := strings.Cut(template, "/")
+	if name, port, ok := strings.Cut(host, ":"); ok {
+		host, t.port = name, port
+	}
+	t.host = strings.Split(strings.ToLower(host), ".")
+	if path != "" {
+		t.path = strings.Split(path, "/")
+		last := t.path[len(t.path)-1]
+		if isPlaceholder(last) && strings.HasSuffix(last, "...}") {
+			t.prefix = true
+			t.path = t.path[:len(t.path)-1]
+		}
+	}
+	return t
+}
+
+func (t destinationTemplate) matches(req *http.Request) bool {
+	if t.port != "" && t.port != req.URL.Port() {
+		return false
+	}
+	if !matchSegments(t.host, strings.Split(strings.ToLower(req.URL.Hostname()), ".")) {
+		return false
+	}
+	path := strings.Trim(req.URL.Path, "/")
+	var segments []string
+	if path != "" {
+		segments = strings.Split(path, "/")
+	}
+	if t.prefix {
+		// the rest of the path matches one or more segments
+		return len(segments) > len(t.path) && matchSegments(t.path, segments[:len(t.path)])
+	}
+	return matchSegments(t.path, segments)
+}
+
+func matchSegments(template, segments []string) bool {
+	if len(template) != len(segments) {
+		return false
+	}
+	for i, segment := range template {
+		if !isPlaceholder(segment) && segment != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func isPlaceholder(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
+
+// destination returns the name of the first template matching req
+func destination(templates []destinationTemplate, req *http.Request) string {
+	for _, template := range templates {
+		if template.matches(req) {
+			return template.name
+		}
+	}
+	return OtherDestination
+}
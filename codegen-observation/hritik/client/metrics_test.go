@@ -0,0 +1,189 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nutanix-core/nai-api/iep/internal/client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// metricsRecorder keeps the recorded metrics in memory
+type metricsRecorder struct {
+	mu      sync.Mutex
+	metrics []client.RequestMetrics
+}
+
+func (r *metricsRecorder) RecordRequestMetrics(_ context.Context, metrics client.RequestMetrics) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, metrics)
+}
+
+func (r *metricsRecorder) recorded() []client.RequestMetrics {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]client.RequestMetrics(nil), r.metrics...)
+}
+
+// instrumentationService keeps the inference metrics recorded through it in memory
+type instrumentationService struct {
+	contexts []*gin.Context
+	calls    []string
+}
+
+func (s *instrumentationService) RecordInferenceMetrics(c *gin.Context, endpointID string, status string, _ int64) {
+	s.contexts = append(s.contexts, c)
+	s.calls = append(s.calls, endpointID+" "+status)
+}
+
+var _ = Describe("Test client metrics", func() {
+
+	var (
+		testServer *httptest.Server
+		recorder   *metricsRecorder
+		ctx        = context.Background()
+	)
+
+	BeforeEach(func() {
+		recorder = &metricsRecorder{}
+		testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("status") == "unavailable" {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"live":true}`))
+		}))
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	It("should record the metrics of a request", func() {
+		c := client.NewClient(client.WithInterceptors(client.MetricsInterceptor(recorder, "127.0.0.1/v2/models/{model}/infer")))
+		req, err := http.NewRequest(http.MethodPost, testServer.URL+"/v2/models/llama/infer", bytes.NewBufferString(`{"inputs":[]}`))
+		Expect(err).ToNot(HaveOccurred())
+		_, _, err = c.Do(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+
+		metrics := recorder.recorded()
+		Expect(metrics).To(HaveLen(1))
+		Expect(metrics[0].Destination).To(Equal("127.0.0.1/v2/models/{model}/infer"))
+		Expect(metrics[0].Method).To(Equal(http.MethodPost))
+		Expect(metrics[0].StatusClass).To(Equal("2xx"))
+		Expect(metrics[0].Attempt).To(Equal(1))
+		Expect(metrics[0].BytesSent).To(Equal(int64(len(`{"inputs":[]}`))))
+		Expect(metrics[0].BytesReceived).To(Equal(int64(len(`{"live":true}`))))
+	})
+
+	It("should record every retry attempt", func() {
+		c := client.NewClient(client.WithInterceptors(client.MetricsInterceptor(recorder)))
+		err := c.MakeRequestWithPolicy(ctx, testServer.URL+"?status=unavailable", http.MethodGet, nil, nil, client.NewRetryPolicy(3, time.Millisecond))
+		Expect(err).To(MatchError(client.ErrRetriesExhausted))
+
+		metrics := recorder.recorded()
+		Expect(metrics).To(HaveLen(3))
+		for i, m := range metrics {
+			Expect(m.Attempt).To(Equal(i + 1))
+			Expect(m.StatusClass).To(Equal("5xx"))
+			Expect(m.Destination).To(Equal(client.OtherDestination))
+		}
+	})
+
+	It("should record requests failing without response", func() {
+		c := client.NewClient(client.WithInterceptors(client.MetricsInterceptor(recorder)))
+		testServer.Close()
+		req, err := http.NewRequest(http.MethodGet, testServer.URL, nil)
+		Expect(err).ToNot(HaveOccurred())
+		_, _, err = c.Do(ctx, req)
+		Expect(err).To(HaveOccurred())
+		Expect(recorder.recorded()).To(ConsistOf(HaveField("StatusClass", client.StatusClassError)))
+	})
+
+	It("should record the metrics through the instrumentation service", func() {
+		service := &instrumentationService{}
+		c := client.NewClient(client.WithInterceptors(client.MetricsInterceptor(
+			client.NewInstrumentationMetricsRecorder(service), "127.0.0.1/v2/health/live")))
+		ginCtx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ginCtx.Request = httptest.NewRequest(http.MethodPost, "/api/v1/chat/completions", nil)
+
+		_, _, err := c.Do(ginCtx, newGetRequest(testServer.URL+"/v2/health/live"))
+		Expect(err).ToNot(HaveOccurred())
+		_, _, err = c.Do(ctx, newGetRequest(testServer.URL+"/v2/health/live?status=unavailable"))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(service.calls).To(Equal([]string{"127.0.0.1/v2/health/live 2xx", "127.0.0.1/v2/health/live 5xx"}))
+		// the inbound request is passed along, requests made outside of one get their own gin context
+		Expect(service.contexts[0]).To(BeIdenticalTo(ginCtx))
+		Expect(service.contexts[1]).ToNot(BeNil())
+		Expect(service.contexts[1].Request).ToNot(BeNil())
+	})
+
+	It("should use the instrumentation service recorder when it has one", func() {
+		Expect(client.NewInstrumentationMetricsRecorder(&recordingInstrumentationService{})).To(BeAssignableToTypeOf(&recordingInstrumentationService{}))
+	})
+
+	It("should record streamed responses once their body is closed", func() {
+		c := client.NewClient(client.WithInterceptors(client.MetricsInterceptor(recorder)))
+		req, err := http.NewRequest(http.MethodGet, testServer.URL, nil)
+		Expect(err).ToNot(HaveOccurred())
+		_, body, err := c.DoStream(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(recorder.recorded()).To(BeEmpty())
+		Expect(body.Close()).To(Succeed())
+		Expect(recorder.recorded()).To(HaveLen(1))
+	})
+
+	DescribeTable("should label destinations with URL templates",
+		func(rawURL string, expected string) {
+			templates := []string{
+				"{endpoint}.nai-admin.svc.cluster.local/v2/health/live",
+				"huggingface.co/api/models/{org}/{model}",
+				"kserve:8080/v1/{path...}",
+			}
+			var destination string
+			c := client.NewClient(
+				client.WithTransport(client.RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+					return httptest.NewRecorder().Result(), nil
+				})),
+				client.WithInterceptors(client.MetricsInterceptor(recorderFunc(func(m client.RequestMetrics) {
+					destination = m.Destination
+				}), templates...)),
+			)
+			u, err := url.Parse(rawURL)
+			Expect(err).ToNot(HaveOccurred())
+			_, _, err = c.Do(ctx, &http.Request{Method: http.MethodGet, URL: u, Header: http.Header{}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(destination).To(Equal(expected))
+		},
+		Entry("host placeholder", "http://endpoint-1.nai-admin.svc.cluster.local/v2/health/live", "{endpoint}.nai-admin.svc.cluster.local/v2/health/live"),
+		Entry("host placeholder with port", "http://endpoint-2.nai-admin.svc.cluster.local:8080/v2/health/live", "{endpoint}.nai-admin.svc.cluster.local/v2/health/live"),
+		Entry("path placeholders", "https://huggingface.co/api/models/meta/llama?revision=main", "huggingface.co/api/models/{org}/{model}"),
+		Entry("too many path segments", "https://huggingface.co/api/models/meta/llama/tree", client.OtherDestination),
+		Entry("rest of the path", "http://kserve:8080/v1/models/llama/ready", "kserve:8080/v1/{path...}"),
+		Entry("other port", "http://kserve:9090/v1/models", client.OtherDestination),
+		Entry("unknown host", "http://example.com/", client.OtherDestination),
+	)
+})
+
+// recorderFunc adapts a function to the client.IMetricsRecorder interface
+type recorderFunc func(client.RequestMetrics)
+
+func (f recorderFunc) RecordRequestMetrics(_ context.Context, metrics client.RequestMetrics) {
+	f(metrics)
+}
+
+// recordingInstrumentationService is an instrumentation service recording the request metrics itself
+type recordingInstrumentationService struct {
+	instrumentationService
+	metricsRecorder
+}
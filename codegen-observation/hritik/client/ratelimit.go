@@ -0,0 +1,289 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is wrapped by the error returned when a request is not allowed by the rate limits
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// RateLimit limits the requests sent to a single destination
+type RateLimit struct {
+	// RequestsPerSecond is the token bucket refill rate, zero means no rate limit
+	RequestsPerSecond float64
+	// Burst is the token bucket size, it defaults to 1 when a rate is set
+	Burst int
+	// MaxInFlight caps the number of concurrent requests, zero means no cap
+	MaxInFlight int
+}
+
+// RateLimitConfig configures the rate limited client
+type RateLimitConfig struct {
+	// Default applies to every destination without its own limit
+	Default RateLimit
+	// Destinations maps destination names to their limits
+	Destinations map[string]RateLimit
+	// Destination names the destination of a request, the request host is used when nil
+	Destination func(*http.Request) string
+	// FailFast rejects requests that would have to wait instead of waiting under the caller's context
+	FailFast bool
+}
+
+// RateLimitError is returned for requests rejected by the rate limits
+type RateLimitError struct {
+	Destination string
+	// Err is the context error when the caller stopped waiting, nil when the request failed fast
+	Err error
+}
+
+func (e *RateLimitError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s for destination %s: %v", ErrRateLimited, e.Destination, e.Err)
+	}
+	return fmt.Sprintf("%s for destination %s", ErrRateLimited, e.Destination)
+}
+
+// Unwrap exposes ErrRateLimited and the context error to errors.Is
+func (e *RateLimitError) Unwrap() []error {
+	if e.Err != nil {
+		return []error{ErrRateLimited, e.Err}
+	}
+	return []error{ErrRateLimited}
+}
+
+// IRateLimitedClient is an IClient enforcing rate and concurrency limits per destination
+type IRateLimitedClient interface {
+	IClient
+	// SetLimit changes the limit of a destination, requests already waiting pick it up
+	SetLimit(destination string, limit RateLimit)
+	// SetDefaultLimit changes the limit of every destination without its own limit
+	SetDefaultLimit(limit RateLimit)
+}
+
+// rateLimitedClient wraps an IClient with one limiter per destination
+type rateLimitedClient struct {
+	next     IClient
+	config   RateLimitConfig
+	mu       sync.Mutex
+	limiters map[string]*limiter
+}
+
+// limiter is a token bucket combined with a cap on requests in flight
+type limiter struct {
+	mu       sync.Mutex
+	limit    RateLimit
+	explicit bool
+	tokens   float64
+	last     time.Time
+	inFlight int
+	// changed is closed and replaced every time a waiting request may be able to proceed
+	changed chan struct{}
+}
+
+// NewRateLimitedClient returns an IClient that limits how hard every destination is hit
+func NewRateLimitedClient(next IClient, config RateLimitConfig) IRateLimitedClient {
+	destinations := make(map[string]RateLimit, len(config.Destinations))
+	for name, limit := range config.Destinations {
+		destinations[name] = limit
+	}
+	config.Destinations = destinations
+	if config.Destination == nil {
+		config.Destination = func(req *http.Request) string {
+			return req.URL.Host
+		}
+	}
+	return &rateLimitedClient{
+		next:     next,
+		config:   config,
+		limiters: map[string]*limiter{},
+	}
+}
+
+func (r *rateLimitedClient) SetTimeout(timeout time.Duration) {
+	r.next.SetTimeout(timeout)
+}
+
+func (r *rateLimitedClient) GetTimeout() time.Duration {
+	return r.next.GetTimeout()
+}
+
+// Do waits for the limits of the request destination and makes the http request
+func (r *rateLimitedClient) Do(ctx context.Context, req *http.Request) (*http.Response, []byte, error) {
+	if ctx == nil {
+		ctx = req.Context()
+	}
+	destination := r.config.Destination(req)
+	l := r.limiter(destination)
+	if ok, err := l.acquire(ctx, r.config.FailFast); !ok {
+		return nil, nil, &RateLimitError{Destination: destination, Err: err}
+	}
+	defer l.release()
+	return r.next.Do(ctx, req)
+}
+
+// DoStream waits for the limits of the request destination and makes the http request.
+// The in flight slot is held until the returned body is closed.
+func (r *rateLimitedClient) DoStream(ctx context.Context, req *http.Request) (*http.Response, io.ReadCloser, error) {
+	if ctx == nil {
+		ctx = req.Context()
+	}
+	destination := r.config.Destination(req)
+	l := r.limiter(destination)
+	if ok, err := l.acquire(ctx, r.config.FailFast); !ok {
+		return nil, nil, &RateLimitError{Destination: destination, Err: err}
+	}
+	resp, body, err := r.next.DoStream(ctx, req)
+	if err != nil || body == nil {
+		l.release()
+		return resp, body, err
+	}
+	body = &onCloseBody{ReadCloser: body, onClose: l.release}
+	resp.Body = body
+	return resp, body, nil
+}
+
+func (r *rateLimitedClient) MakeRequestWithRetry(ctx context.Context, url string, method string, reqBody *bytes.Buffer, headers map[string]string, maxRetries int, retryDelay time.Duration) error {
+	return makeRequestWithPolicy(ctx, r.Do, url, method, reqBody, headers, NewRetryPolicy(maxRetries, retryDelay))
+}
+
+func (r *rateLimitedClient) MakeRequestWithPolicy(ctx context.Context, url string, method string, reqBody *bytes.Buffer, headers map[string]string, policy RetryPolicy) error {
+	return makeRequestWithPolicy(ctx, r.Do, url, method, reqBody, headers, policy)
+}
+
+func (r *rateLimitedClient) DoWithRetry(ctx context.Context, req *Request, policy RetryPolicy) (*Result, error) {
+	return doWithRetry(ctx, r.Do, req, policy)
+}
+
+func (r *rateLimitedClient) SetLimit(destination string, limit RateLimit) {
+	r.mu.Lock()
+	r.config.Destinations[destination] = limit
+	l, ok := r.limiters[destination]
+	r.mu.Unlock()
+	if ok {
+		l.setLimit(limit, true)
+	}
+}
+
+func (r *rateLimitedClient) SetDefaultLimit(limit RateLimit) {
+	r.mu.Lock()
+	r.config.Default = limit
+	limiters := make([]*limiter, 0, len(r.limiters))
+	for _, l := range r.limiters {
+		limiters = append(limiters, l)
+	}
+	r.mu.Unlock()
+	for _, l := range limiters {
+		l.setLimit(limit, false)
+	}
+}
+
+// limiter returns the limiter of destination, creating it on first use
+func (r *rateLimitedClient) limiter(destination string) *limiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l, ok := r.limiters[destination]
+	if !ok {
+		limit, explicit := r.config.Destinations[destination]
+		if !explicit {
+			limit = r.config.Default
+		}
+		l = &limiter{limit: limit, explicit: explicit, last: time.Now(), changed: make(chan struct{})}
+		l.tokens = float64(l.burst())
+		r.limiters[destination] = l
+	}
+	return l
+}
+
+// acquire takes a token and an in flight slot, waiting for them under ctx unless failFast is set.
+// It returns false with the context error when the caller stopped waiting, or with nil when failing fast.
+func (l *limiter) acquire(ctx context.Context, failFast bool) (bool, error) {
+	for {
+		l.mu.Lock()
+		l.refill(time.Now())
+		hasSlot := l.limit.MaxInFlight <= 0 || l.inFlight < l.limit.MaxInFlight
+		hasToken := l.limit.RequestsPerSecond <= 0 || l.tokens >= 1
+		if hasSlot && hasToken {
+			if l.limit.RequestsPerSecond > 0 {
+				l.tokens--
+			}
+			l.inFlight++
+			l.mu.Unlock()
+			return true, nil
+		}
+		if failFast {
+			l.mu.Unlock()
+			return false, nil
+		}
+
+		changed := l.changed
+		// without a free slot only a release or a limit change can unblock the request,
+		// otherwise the missing token is available after a known delay
+		wait := time.Duration(math.MaxInt64)
+		if hasSlot {
+			wait = time.Duration((1 - l.tokens) / l.limit.RequestsPerSecond * float64(time.Second))
+		}
+		l.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false, ctx.Err()
+		case <-changed:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// release frees the in flight slot taken by acquire
+func (l *limiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	l.broadcast()
+}
+
+func (l *limiter) setLimit(limit RateLimit, explicit bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// a default limit never overrides a limit set for the destination itself
+	if l.explicit && !explicit {
+		return
+	}
+	l.refill(time.Now())
+	l.limit = limit
+	l.explicit = explicit
+	l.tokens = math.Min(l.tokens, float64(l.burst()))
+	l.broadcast()
+}
+
+func (l *limiter) burst() int {
+	if l.limit.Burst > 0 {
+		return l.limit.Burst
+	}
+	return 1
+}
+
+// refill adds the tokens earned since the last refill, l.mu must be held
+func (l *limiter) refill(now time.Time) {
+	if l.limit.RequestsPerSecond > 0 {
+		elapsed := now.Sub(l.last).Seconds()
+		l.tokens = math.Min(float64(l.burst()), l.tokens+elapsed*l.limit.RequestsPerSecond)
+	}
+	l.last = now
+}
+
+// broadcast wakes up every waiting request, l.mu must be held
+func (l *limiter) broadcast() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
@@ -0,0 +1,214 @@
+package client_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/nutanix-core/nai-api/iep/internal/client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Test rate limited client", func() {
+
+	var (
+		testServer *httptest.Server
+		release    chan struct{}
+		started    chan struct{}
+		ctx        = context.Background()
+	)
+
+	get := func(c client.IClient, ctx context.Context) error {
+		req, err := http.NewRequest(http.MethodGet, testServer.URL, nil)
+		Expect(err).ToNot(HaveOccurred())
+		_, _, err = c.Do(ctx, req)
+		return err
+	}
+
+	BeforeEach(func() {
+		release = make(chan struct{})
+		started = make(chan struct{}, 10)
+		testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("block") != "" {
+				started <- struct{}{}
+				<-release
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	blockingGet := func(c client.IClient) chan error {
+		done := make(chan error, 1)
+		go func() {
+			defer GinkgoRecover()
+			req, err := http.NewRequest(http.MethodGet, testServer.URL+"?block=true", nil)
+			Expect(err).ToNot(HaveOccurred())
+			_, _, err = c.Do(ctx, req)
+			done <- err
+		}()
+		Eventually(started).Should(Receive())
+		return done
+	}
+
+	Context("Test concurrency limit", func() {
+		It("should fail fast when no slot is free", func() {
+			c := client.NewRateLimitedClient(client.NewClient(), client.RateLimitConfig{
+				Default:  client.RateLimit{MaxInFlight: 1},
+				FailFast: true,
+			})
+			done := blockingGet(c)
+
+			err := get(c, ctx)
+			Expect(err).To(MatchError(client.ErrRateLimited))
+
+			close(release)
+			Expect(<-done).ToNot(HaveOccurred())
+			Expect(get(c, ctx)).To(Succeed())
+		})
+
+		It("should wait for a free slot under the caller context", func() {
+			c := client.NewRateLimitedClient(client.NewClient(), client.RateLimitConfig{
+				Default: client.RateLimit{MaxInFlight: 1},
+			})
+			done := blockingGet(c)
+
+			timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+			defer cancel()
+			err := get(c, timeoutCtx)
+			Expect(err).To(MatchError(client.ErrRateLimited))
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+
+			waiting := make(chan error, 1)
+			go func() {
+				waiting <- get(c, ctx)
+			}()
+			Consistently(waiting, 50*time.Millisecond).ShouldNot(Receive())
+			close(release)
+			Expect(<-done).ToNot(HaveOccurred())
+			Eventually(waiting).Should(Receive(BeNil()))
+		})
+
+		It("should wake up waiting requests when the limit is raised", func() {
+			c := client.NewRateLimitedClient(client.NewClient(), client.RateLimitConfig{
+				Default: client.RateLimit{MaxInFlight: 1},
+			})
+			done := blockingGet(c)
+
+			waiting := make(chan error, 1)
+			go func() {
+				waiting <- get(c, ctx)
+			}()
+			Consistently(waiting, 50*time.Millisecond).ShouldNot(Receive())
+			c.SetDefaultLimit(client.RateLimit{MaxInFlight: 2})
+			Eventually(waiting).Should(Receive(BeNil()))
+
+			close(release)
+			Expect(<-done).ToNot(HaveOccurred())
+		})
+	})
+
+	Context("Test streamed responses", func() {
+		It("should hold the slot until the body is closed", func() {
+			c := client.NewRateLimitedClient(client.NewClient(), client.RateLimitConfig{
+				Default:  client.RateLimit{MaxInFlight: 1},
+				FailFast: true,
+			})
+			req, err := http.NewRequest(http.MethodGet, testServer.URL, nil)
+			Expect(err).ToNot(HaveOccurred())
+			_, body, err := c.DoStream(ctx, req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(get(c, ctx)).To(MatchError(client.ErrRateLimited))
+
+			Expect(body.Close()).To(Succeed())
+			Expect(body.Close()).To(Succeed())
+			Expect(get(c, ctx)).To(Succeed())
+			Expect(get(c, ctx)).To(Succeed())
+		})
+	})
+
+	Context("Test request rate", func() {
+		It("should space requests according to the rate", func() {
+			c := client.NewRateLimitedClient(client.NewClient(), client.RateLimitConfig{
+				Default: client.RateLimit{RequestsPerSecond: 20, Burst: 1},
+			})
+
+			start := time.Now()
+			for i := 0; i < 3; i++ {
+				Expect(get(c, ctx)).To(Succeed())
+			}
+			Expect(time.Since(start)).To(BeNumerically(">=", 90*time.Millisecond))
+		})
+
+		It("should allow a burst without waiting", func() {
+			c := client.NewRateLimitedClient(client.NewClient(), client.RateLimitConfig{
+				Default:  client.RateLimit{RequestsPerSecond: 1, Burst: 3},
+				FailFast: true,
+			})
+
+			for i := 0; i < 3; i++ {
+				Expect(get(c, ctx)).To(Succeed())
+			}
+			Expect(get(c, ctx)).To(MatchError(client.ErrRateLimited))
+		})
+
+		It("should apply named destination limits", func() {
+			c := client.NewRateLimitedClient(client.NewClient(), client.RateLimitConfig{
+				Destinations: map[string]client.RateLimit{"engine": {RequestsPerSecond: 1, Burst: 1}},
+				Destination: func(*http.Request) string {
+					return "engine"
+				},
+				FailFast: true,
+			})
+
+			Expect(get(c, ctx)).To(Succeed())
+			Expect(get(c, ctx)).To(MatchError(client.ErrRateLimited))
+			c.SetLimit("engine", client.RateLimit{})
+			Expect(get(c, ctx)).To(Succeed())
+		})
+	})
+
+	Context("Test concurrent use", func() {
+		It("should never exceed the concurrency limit", func() {
+			var (
+				mu       sync.Mutex
+				inFlight int
+				maxSeen  int
+			)
+			testServer.Close()
+			testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				mu.Lock()
+				inFlight++
+				if inFlight > maxSeen {
+					maxSeen = inFlight
+				}
+				mu.Unlock()
+				time.Sleep(5 * time.Millisecond)
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+				w.WriteHeader(http.StatusOK)
+			}))
+			c := client.NewRateLimitedClient(client.NewClient(), client.RateLimitConfig{
+				Default: client.RateLimit{MaxInFlight: 3},
+			})
+
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_ = get(c, ctx)
+				}()
+			}
+			wg.Wait()
+			Expect(maxSeen).To(BeNumerically("<=", 3))
+		})
+	})
+})
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+)
+
+// Request describes an HTTP request that can be sent several times.
+// Its body is kept in memory and replayed on every attempt.
+type Request struct {
+	Method string
+	URL    string
+	Header http.Header
+	body   []byte
+}
+
+// Result is the outcome of the last attempt made for a request
+type Result struct {
+	// Response is the last response received, its body is already consumed and closed
+	Response *http.Response
+	// Body holds the bytes read from the last response body
+	Body []byte
+	// Attempts is the number of attempts made
+	Attempts int
+}
+
+// NewRequest returns a replayable request, body may be nil
+func NewRequest(method string, url string, body []byte) *Request {
+	return &Request{
+		Method: method,
+		URL:    url,
+		Header: http.Header{},
+		body:   body,
+	}
+}
+
+// NewRequestFromReader reads body fully and returns a replayable request
+func NewRequestFromReader(method string, url string, body io.Reader) (*Request, error) {
+	if body == nil {
+		return NewRequest(method, url, nil), nil
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	return NewRequest(method, url, data), nil
+}
+
+// SetHeaders sets every given header on the request and returns it
+func (r *Request) SetHeaders(headers map[string]string) *Request {
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+	return r
+}
+
+// Body returns the request body
+func (r *Request) Body() []byte {
+	return r.body
+}
+
+// build returns a new http request for a single attempt, with a fresh body reader
+func (r *Request) build(ctx context.Context) (*http.Request, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var body io.Reader
+	if r.body != nil {
+		body = bytes.NewReader(r.body)
+	}
+	req, err := http.NewRequestWithContext(ctx, r.Method, r.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	if r.Header != nil {
+		req.Header = r.Header.Clone()
+	}
+	return req, nil
+}
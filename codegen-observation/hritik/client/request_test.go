@@ -0,0 +1,112 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/nutanix-core/nai-api/iep/internal/client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Test replayable requests", func() {
+
+	var (
+		testServer *httptest.Server
+		c          client.IClient
+		ctx        = context.Background()
+		policy     = client.RetryPolicy{MaxAttempts: 3, InitialInterval: 10 * time.Millisecond}
+	)
+
+	BeforeEach(func() {
+		c = client.NewClient()
+	})
+
+	AfterEach(func() {
+		if testServer != nil {
+			testServer.Close()
+		}
+	})
+
+	Context("Test NewRequestFromReader function", func() {
+		It("should keep the whole body", func() {
+			req, err := client.NewRequestFromReader(http.MethodPost, "http://localhost", strings.NewReader(`{"key": "value"}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(req.Body())).To(Equal(`{"key": "value"}`))
+		})
+
+		It("should accept a nil body", func() {
+			req, err := client.NewRequestFromReader(http.MethodGet, "http://localhost", nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(req.Body()).To(BeNil())
+		})
+	})
+
+	Context("Test DoWithRetry function", func() {
+		It("should replay the body on every attempt", func() {
+			var bodies []string
+			testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				bodies = append(bodies, string(body))
+				if len(bodies) < 3 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				_, _ = w.Write([]byte(`{"id":"1"}`))
+			}))
+
+			req := client.NewRequest(http.MethodPost, testServer.URL, []byte(`{"prompt":"text"}`)).SetHeaders(map[string]string{"Content-Type": "application/json"})
+			result, err := c.DoWithRetry(ctx, req, policy)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(bodies).To(Equal([]string{`{"prompt":"text"}`, `{"prompt":"text"}`, `{"prompt":"text"}`}))
+			Expect(result.Attempts).To(Equal(3))
+			Expect(result.Response.StatusCode).To(Equal(http.StatusOK))
+			Expect(string(result.Body)).To(Equal(`{"id":"1"}`))
+		})
+
+		It("should return the last response on failure", func() {
+			testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error":"invalid prompt"}`))
+			}))
+
+			result, err := c.DoWithRetry(ctx, client.NewRequest(http.MethodPost, testServer.URL, nil), policy)
+			Expect(err).To(HaveOccurred())
+			Expect(result).ToNot(BeNil())
+			Expect(result.Attempts).To(Equal(1))
+			Expect(result.Response.StatusCode).To(Equal(http.StatusBadRequest))
+			Expect(string(result.Body)).To(Equal(`{"error":"invalid prompt"}`))
+		})
+
+		It("should return no result when the request cannot be built", func() {
+			result, err := c.DoWithRetry(ctx, client.NewRequest(http.MethodGet, ":", nil), policy)
+			Expect(err).To(HaveOccurred())
+			Expect(result).To(BeNil())
+		})
+	})
+
+	Context("Test MakeRequestWithRetry function", func() {
+		It("should send the same body on every retry", func() {
+			var bodies []string
+			testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				bodies = append(bodies, string(body))
+				if len(bodies) < 2 {
+					w.WriteHeader(http.StatusBadGateway)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			reqBody := bytes.NewBufferString(`{"key": "value"}`)
+			err := c.MakeRequestWithRetry(ctx, testServer.URL, http.MethodPost, reqBody, nil, 3, 10*time.Millisecond)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(bodies).To(Equal([]string{`{"key": "value"}`, `{"key": "value"}`}))
+		})
+	})
+})
@@ -0,0 +1,255 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultMaxRetryInterval caps the delay between two attempts of the default retry policy
+	DefaultMaxRetryInterval = 30 * time.Second
+	// DefaultRetryMultiplier is the factor by which the delay grows after every attempt
+	DefaultRetryMultiplier = 2.0
+	// DefaultRetryJitter randomizes every delay by +/- 20 percent
+	DefaultRetryJitter = 0.2
+)
+
+// ErrRetriesExhausted is wrapped by the error returned once a retry policy runs out of attempts or time
+var ErrRetriesExhausted = errors.New("failed to make request after multiple retries")
+
+// retryableStatusCodes are the response codes that are worth retrying by default
+var retryableStatusCodes = map[int]bool{
+	http.StatusRequestTimeout:      true,
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// RetryClassifier reports whether a failed attempt should be retried.
+// resp is nil when the request failed at the transport level.
+type RetryClassifier func(resp *http.Response, err error) bool
+
+// RetryPolicy describes how often and how fast a request is retried
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one
+	MaxAttempts int
+	// InitialInterval is the delay before the first retry
+	InitialInterval time.Duration
+	// MaxInterval caps the delay between two attempts, zero means no cap.
+	// It caps the Retry-After delays asked by servers too, DefaultMaxRetryInterval does when it is zero.
+	MaxInterval time.Duration
+	// Multiplier grows the delay after every attempt, values below 1 are treated as 1
+	Multiplier float64
+	// Jitter randomizes every delay by +/- Jitter*delay, it must be between 0 and 1
+	Jitter float64
+	// MaxElapsedTime stops retrying once the next attempt would start after it, zero means no limit
+	MaxElapsedTime time.Duration
+	// Classifier decides which failures are retried, DefaultRetryClassifier is used when nil
+	Classifier RetryClassifier
+}
+
+// NewRetryPolicy returns an exponential backoff policy making at most maxAttempts attempts
+func NewRetryPolicy(maxAttempts int, initialInterval time.Duration) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:     maxAttempts,
+		InitialInterval: initialInterval,
+		MaxInterval:     DefaultMaxRetryInterval,
+		Multiplier:      DefaultRetryMultiplier,
+		Jitter:          DefaultRetryJitter,
+	}
+}
+
+// StatusError is the error recorded for an attempt that got an unsuccessful response
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected response status code %d", e.StatusCode)
+}
+
+// RetryError is returned when a request did not succeed under a retry policy.
+// It wraps the error of the last attempt and, when the policy gave up, ErrRetriesExhausted.
+type RetryError struct {
+	// Attempts is the number of attempts made
+	Attempts int
+	// Err is the reason the request failed
+	Err error
+	// exhausted is set when the policy ran out of attempts or time
+	exhausted bool
+}
+
+func (e *RetryError) Error() string {
+	if e.exhausted {
+		return fmt.Sprintf("%s: %d attempt(s): %v", ErrRetriesExhausted, e.Attempts, e.Err)
+	}
+	return fmt.Sprintf("request failed after %d attempt(s): %v", e.Attempts, e.Err)
+}
+
+// Unwrap exposes the last attempt error and ErrRetriesExhausted to errors.Is and errors.As
+func (e *RetryError) Unwrap() []error {
+	if e.exhausted {
+		return []error{ErrRetriesExhausted, e.Err}
+	}
+	return []error{e.Err}
+}
+
+// DefaultRetryClassifier retries refused, reset and closed connections, timeouts and the
+// 408, 429, 500, 502, 503 and 504 status codes. Other network errors such as unknown hosts and
+// TLS handshake failures are not retried, they would fail the same way again.
+func DefaultRetryClassifier(resp *http.Response, err error) bool {
+	if err != nil {
+		return isRetryableError(err)
+	}
+	return resp != nil && retryableStatusCodes[resp.StatusCode]
+}
+
+func isRetryableError(err error) bool {
+	// cancellation comes from the caller, retrying would not help
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+type attemptKey struct{}
+
+// withAttempt returns a copy of ctx carrying the 1-based attempt number of a request made under a retry policy
+func withAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, attemptKey{}, attempt)
+}
+
+// AttemptFromContext returns the 1-based attempt number of the request made with ctx under a retry policy,
+// requests made without retry policy are first attempts
+func AttemptFromContext(ctx context.Context) int {
+	if attempt, ok := ctx.Value(attemptKey{}).(int); ok {
+		return attempt
+	}
+	return 1
+}
+
+// run calls attempt until it returns a successful response, a non retryable
+// failure occurs, the policy is exhausted or ctx is done.
+// attempt receives the 1-based attempt number.
+func (p RetryPolicy) run(ctx context.Context, attempt func(attempt int) (*http.Response, error)) (*http.Response, int, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	classify := p.Classifier
+	if classify == nil {
+		classify = DefaultRetryClassifier
+	}
+	maxAttempts := p.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	start := time.Now()
+	var lastErr error
+	for i := 1; ; i++ {
+		resp, err := attempt(i)
+		if err == nil && resp != nil && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return resp, i, nil
+		}
+
+		lastErr = err
+		if err == nil {
+			lastErr = &StatusError{StatusCode: resp.StatusCode}
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return resp, i, &RetryError{Attempts: i, Err: errors.Join(ctxErr, lastErr)}
+		}
+		if !classify(resp, err) {
+			return resp, i, &RetryError{Attempts: i, Err: lastErr}
+		}
+		if i >= maxAttempts {
+			return resp, i, &RetryError{Attempts: i, Err: lastErr, exhausted: true}
+		}
+
+		delay := p.backoff(i)
+		if retryAfter, ok := parseRetryAfter(resp, time.Now()); ok && retryAfter > delay {
+			delay = max(delay, min(retryAfter, p.maxRetryAfter()))
+		}
+		if p.MaxElapsedTime > 0 && time.Since(start)+delay > p.MaxElapsedTime {
+			return resp, i, &RetryError{Attempts: i, Err: lastErr, exhausted: true}
+		}
+
+		// do not keep sleeping once the caller is no longer interested in the result
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return resp, i, &RetryError{Attempts: i, Err: errors.Join(ctx.Err(), lastErr)}
+		case <-timer.C:
+		}
+	}
+}
+
+// maxRetryAfter caps the delays servers ask for, a server should not make its callers hang for hours
+func (p RetryPolicy) maxRetryAfter() time.Duration {
+	if p.MaxInterval > 0 {
+		return p.MaxInterval
+	}
+	return DefaultMaxRetryInterval
+}
+
+// backoff returns the delay to wait after the given 1-based attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	if p.InitialInterval <= 0 {
+		return 0
+	}
+	multiplier := math.Max(p.Multiplier, 1)
+	interval := float64(p.InitialInterval) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxInterval > 0 && interval > float64(p.MaxInterval) {
+		interval = float64(p.MaxInterval)
+	}
+	if p.Jitter > 0 {
+		delta := math.Min(p.Jitter, 1) * interval
+		interval = interval - delta + rand.Float64()*2*delta //nolint:gosec // jitter does not need a secure source
+	}
+	// an uncapped delay grows past the largest duration after enough attempts, converting it would overflow
+	if interval >= math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(interval)
+}
+
+// parseRetryAfter reads the Retry-After header, either as delay in seconds or as an HTTP date
+func parseRetryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := date.Sub(now); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,302 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultMaxEventSize is the largest event data an SSE decoder accepts unless configured otherwise
+	DefaultMaxEventSize = 1 << 20
+	// DefaultSSEReconnectDelay is the delay before reconnecting when the server did not set one
+	DefaultSSEReconnectDelay = time.Second
+	// SSEDoneSentinel is the data of the event ending OpenAI compatible streams
+	SSEDoneSentinel = "[DONE]"
+
+	contentTypeEventStream = "text/event-stream"
+	maxSSEFieldSize        = 64
+)
+
+// ErrEventTooLarge is returned when an event exceeds the maximum event size, the event is skipped
+var ErrEventTooLarge = errors.New("server-sent event too large")
+
+// Event is a server-sent event
+type Event struct {
+	// ID is the last event id received on the stream, events without id field keep the previous one
+	ID string
+	// Type is the event field, "message" when the event has none
+	Type string
+	// Data is the data of the event, multiple data fields are joined with a line feed
+	Data string
+	// Retry is the reconnection delay set by the event, if any
+	Retry time.Duration
+}
+
+// SSEDecoder decodes server-sent events as specified by the WHATWG HTML standard.
+// A data field equal to SSEDoneSentinel ends the stream as OpenAI compatible engines do.
+type SSEDecoder struct {
+	reader       *bufio.Reader
+	maxEventSize int
+	// idBuffer is the id field received last, it becomes the last event id once its event is dispatched
+	idBuffer    string
+	lastEventID string
+	started     bool
+	done        bool
+}
+
+// NewSSEDecoder returns a decoder reading events from r, a maxEventSize of zero or less uses DefaultMaxEventSize
+func NewSSEDecoder(r io.Reader, maxEventSize int) *SSEDecoder {
+	if maxEventSize <= 0 {
+		maxEventSize = DefaultMaxEventSize
+	}
+	return &SSEDecoder{reader: bufio.NewReader(r), maxEventSize: maxEventSize}
+}
+
+// LastEventID returns the id of the last event dispatched, to send in the Last-Event-ID header when reconnecting
+func (d *SSEDecoder) LastEventID() string {
+	return d.lastEventID
+}
+
+// Done reports whether the stream was ended by SSEDoneSentinel
+func (d *SSEDecoder) Done() bool {
+	return d.done
+}
+
+// Decode returns the next event. It returns io.EOF once the stream ended, either with SSEDoneSentinel
+// or at the end of the input, in which case an incomplete event is discarded. Comments and keepalives are skipped.
+// An event whose data or any field value is larger than the maximum size is skipped and reported
+// with ErrEventTooLarge, decoding may continue after it.
+func (d *SSEDecoder) Decode() (Event, error) {
+	if d.done {
+		return Event{}, io.EOF
+	}
+	var (
+		data      strings.Builder
+		hasData   bool
+		eventType string
+		retry     time.Duration
+		tooLarge  bool
+	)
+	for {
+		// leave room for the field name of a data line carrying the largest value
+		line, truncated, err := d.readLine(d.maxEventSize + maxSSEFieldSize)
+		if err != nil {
+			return Event{}, err
+		}
+		if truncated && !strings.HasPrefix(line, ":") {
+			// long comments are dropped anyway, only fields make an event too large
+			tooLarge = true
+		}
+		if len(line) == 0 && !truncated {
+			// a blank line dispatches the event
+			d.lastEventID = d.idBuffer
+			if tooLarge {
+				return Event{}, ErrEventTooLarge
+			}
+			if !hasData {
+				data.Reset()
+				eventType, retry = "", 0
+				continue
+			}
+			event := Event{ID: d.lastEventID, Type: eventType, Data: strings.TrimSuffix(data.String(), "\n"), Retry: retry}
+			if event.Type == "" {
+				event.Type = "message"
+			}
+			if event.Data == SSEDoneSentinel {
+				d.done = true
+				return Event{}, io.EOF
+			}
+			return event, nil
+		}
+		if tooLarge {
+			continue
+		}
+
+		field, value, found := strings.Cut(line, ":")
+		if found {
+			value = strings.TrimPrefix(value, " ")
+		}
+		if field != "" && len(value) > d.maxEventSize {
+			tooLarge = true
+			continue
+		}
+		switch field {
+		case "":
+			// a comment, servers send them as keepalives
+		case "event":
+			eventType = value
+		case "data":
+			// the line feed ending the data buffer is not part of the event
+			if data.Len()+len(value) > d.maxEventSize {
+				tooLarge = true
+				continue
+			}
+			data.WriteString(value)
+			data.WriteByte('\n')
+			hasData = true
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				d.idBuffer = value
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(value, 10, 32); err == nil {
+				retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
+
+// readLine reads a line ended by CRLF, LF or CR. A line longer than limit is read to its end but
+// only reported as truncated. io.EOF is returned at the end of the input, even after a partial line.
+func (d *SSEDecoder) readLine(limit int) (string, bool, error) {
+	var line strings.Builder
+	truncated := false
+	for {
+		b, err := d.reader.ReadByte()
+		if err != nil {
+			return "", false, err
+		}
+		if !d.started {
+			d.started = true
+			// skip the byte order mark of the stream, 0xEF 0xBB 0xBF
+			if b == 0xEF {
+				if bom, err := d.reader.Peek(2); err == nil && bom[0] == 0xBB && bom[1] == 0xBF {
+					_, _ = d.reader.Discard(2)
+					continue
+				}
+			}
+		}
+		switch b {
+		case '\n':
+			return line.String(), truncated, nil
+		case '\r':
+			if next, err := d.reader.Peek(1); err == nil && next[0] == '\n' {
+				_, _ = d.reader.Discard(1)
+			}
+			return line.String(), truncated, nil
+		}
+		if line.Len() >= limit {
+			truncated = true
+			continue
+		}
+		line.WriteByte(b)
+	}
+}
+
+// SSEConfig configures an SSE stream
+type SSEConfig struct {
+	// MaxEventSize is the largest event data accepted, DefaultMaxEventSize by default
+	MaxEventSize int
+	// MaxReconnects is the number of times the stream reconnects after the connection ends without
+	// SSEDoneSentinel, sending the last event id received. Streams do not reconnect by default, as
+	// reconnecting a request that is not resumable, such as a completion, starts it over.
+	MaxReconnects int
+	// ReconnectDelay is the delay before reconnecting when the server did not set one, DefaultSSEReconnectDelay by default
+	ReconnectDelay time.Duration
+}
+
+// SSEStream reads the server-sent events of a streaming request
+type SSEStream struct {
+	ctx        context.Context
+	client     IClient
+	req        *Request
+	config     SSEConfig
+	body       io.ReadCloser
+	decoder    *SSEDecoder
+	reconnects int
+	delay      time.Duration
+}
+
+// NewSSEStream sends req with DoStream and returns the stream of its events.
+// An unsuccessful response is returned as *HTTPError. The caller must close the stream.
+func NewSSEStream(ctx context.Context, c IClient, req *Request, config SSEConfig) (*SSEStream, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if config.ReconnectDelay <= 0 {
+		config.ReconnectDelay = DefaultSSEReconnectDelay
+	}
+	req.Header.Set("Accept", contentTypeEventStream)
+	req.Header.Set("Cache-Control", "no-cache")
+	s := &SSEStream{ctx: ctx, client: c, req: req, config: config, delay: config.ReconnectDelay}
+	if err := s.connect(""); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Recv returns the next event, or io.EOF once the stream ended
+func (s *SSEStream) Recv() (Event, error) {
+	for {
+		event, err := s.decoder.Decode()
+		if err == nil {
+			if event.Retry > 0 {
+				s.delay = event.Retry
+			}
+			return event, nil
+		}
+		if errors.Is(err, ErrEventTooLarge) {
+			return Event{}, err
+		}
+		if s.decoder.Done() || s.ctx.Err() != nil || s.reconnects >= s.config.MaxReconnects {
+			return Event{}, err
+		}
+		if err := s.reconnect(); err != nil {
+			return Event{}, err
+		}
+	}
+}
+
+// Close closes the connection of the stream
+func (s *SSEStream) Close() error {
+	return s.body.Close()
+}
+
+func (s *SSEStream) reconnect() error {
+	s.reconnects++
+	_ = s.body.Close()
+	timer := time.NewTimer(s.delay)
+	defer timer.Stop()
+	select {
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	case <-timer.C:
+	}
+	return s.connect(s.decoder.LastEventID())
+}
+
+// connect sends the request, with the Last-Event-ID header when an event id was received
+func (s *SSEStream) connect(lastEventID string) error {
+	if lastEventID != "" {
+		s.req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	httpReq, err := s.req.build(s.ctx)
+	if err != nil {
+		return err
+	}
+	resp, body, err := s.client.DoStream(s.ctx, httpReq)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer body.Close() //nolint:errcheck
+		errBody, _ := io.ReadAll(io.LimitReader(body, MaxHTTPErrorBodySize))
+		return newHTTPError(s.req, &Result{Response: resp, Body: errBody, Attempts: s.reconnects + 1}, nil)
+	}
+	if resp.StatusCode == http.StatusNoContent {
+		// the server asks the client to stop reconnecting
+		s.config.MaxReconnects = s.reconnects
+	}
+	decoder := NewSSEDecoder(body, s.config.MaxEventSize)
+	if s.decoder != nil {
+		decoder.idBuffer, decoder.lastEventID = s.decoder.lastEventID, s.decoder.lastEventID
+	}
+	s.body, s.decoder = body, decoder
+	return nil
+}
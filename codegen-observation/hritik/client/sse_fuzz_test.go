@@ -0,0 +1,98 @@
+package client_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/nutanix-core/nai-api/iep/internal/client"
+)
+
+// FuzzSSEDecoder checks that arbitrary input never breaks the decoder invariants
+func FuzzSSEDecoder(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"data: hello\n\n",
+		"id: 1\nevent: token\ndata: a\ndata: b\n\n",
+		": keepalive\n\n",
+		"data: [DONE]\n\n",
+		"\xEF\xBB\xBFdata: bom\r\n\r\n",
+		"data\rdata:\r\n\n",
+		"retry: 100\nid: \x00\ndata: x\n\n",
+		"data: " + strings.Repeat("x", 100) + "\n\n",
+	} {
+		f.Add(seed, 16)
+	}
+	f.Fuzz(func(t *testing.T, input string, maxEventSize int) {
+		if maxEventSize <= 0 || maxEventSize > 1024 {
+			maxEventSize = 16
+		}
+		decoder := client.NewSSEDecoder(strings.NewReader(input), maxEventSize)
+		// every event consumes at least its blank line
+		for i := 0; i <= len(input); i++ {
+			event, err := decoder.Decode()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if errors.Is(err, client.ErrEventTooLarge) {
+				continue
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if len(event.Data) > maxEventSize {
+				t.Fatalf("event data of %d bytes exceeds the maximum size %d", len(event.Data), maxEventSize)
+			}
+			if event.Data == client.SSEDoneSentinel {
+				t.Fatalf("done sentinel returned as an event")
+			}
+			if strings.ContainsAny(event.ID+event.Type, "\r\n") || strings.ContainsRune(event.ID, 0) {
+				t.Fatalf("invalid id %q or type %q", event.ID, event.Type)
+			}
+			if event.Type == "" {
+				t.Fatalf("event without type")
+			}
+		}
+		t.Fatalf("decoder did not reach the end of the input")
+	})
+}
+
+// FuzzSSERoundTrip checks that encoded events are decoded back whatever the line endings
+func FuzzSSERoundTrip(f *testing.F) {
+	f.Add("1", "token", "hello\nworld", uint8(0))
+	f.Add("", "", "", uint8(1))
+	f.Add("abc", "message", " leading space", uint8(2))
+	f.Fuzz(func(t *testing.T, id string, eventType string, data string, ending uint8) {
+		if strings.ContainsAny(id+eventType, "\r\n\x00") || strings.ContainsRune(data, '\r') ||
+			strings.HasPrefix(eventType, " ") || strings.HasPrefix(id, " ") || data == client.SSEDoneSentinel {
+			t.Skip()
+		}
+		newline := []string{"\n", "\r\n", "\r"}[ending%3]
+		var stream strings.Builder
+		if id != "" {
+			stream.WriteString("id: " + id + newline)
+		}
+		if eventType != "" {
+			stream.WriteString("event: " + eventType + newline)
+		}
+		stream.WriteString(": comment" + newline)
+		for _, line := range strings.Split(data, "\n") {
+			stream.WriteString("data: " + line + newline)
+		}
+		stream.WriteString(newline)
+
+		maxEventSize := max(len(id), len(eventType), len(data), 1)
+		event, err := client.NewSSEDecoder(strings.NewReader(stream.String()), maxEventSize).Decode()
+		if err != nil {
+			t.Fatalf("failed to decode %q: %v", stream.String(), err)
+		}
+		expectedType := eventType
+		if expectedType == "" {
+			expectedType = "message"
+		}
+		if event.ID != id || event.Type != expectedType || event.Data != data {
+			t.Fatalf("decoded %+v from %q", event, stream.String())
+		}
+	})
+}
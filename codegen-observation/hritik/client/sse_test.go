@@ -0,0 +1,163 @@
+package client_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nutanix-core/nai-api/iep/internal/client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Test server-sent events", func() {
+
+	decodeAll := func(input string, maxEventSize int) ([]client.Event, error) {
+		decoder := client.NewSSEDecoder(strings.NewReader(input), maxEventSize)
+		var events []client.Event
+		for {
+			event, err := decoder.Decode()
+			if err != nil {
+				return events, err
+			}
+			events = append(events, event)
+		}
+	}
+
+	Context("Test SSE decoder", func() {
+		It("should decode events with their fields", func() {
+			events, err := decodeAll("id: 1\nevent: token\ndata: first\ndata:second\n\ndata: third\n\n", 0)
+			Expect(err).To(MatchError(io.EOF))
+			Expect(events).To(Equal([]client.Event{
+				{ID: "1", Type: "token", Data: "first\nsecond"},
+				{ID: "1", Type: "message", Data: "third"},
+			}))
+		})
+
+		It("should skip comments, keepalives and events without data", func() {
+			events, err := decodeAll(": keepalive\n\n:\n\nevent: ping\n\nid: 7\n\ndata: value\n\n", 0)
+			Expect(err).To(MatchError(io.EOF))
+			Expect(events).To(Equal([]client.Event{{ID: "7", Type: "message", Data: "value"}}))
+		})
+
+		It("should accept every line ending and a byte order mark", func() {
+			events, err := decodeAll("\xEF\xBB\xBFdata: a\r\ndata: b\rdata: c\n\r\n", 0)
+			Expect(err).To(MatchError(io.EOF))
+			Expect(events).To(Equal([]client.Event{{Type: "message", Data: "a\nb\nc"}}))
+		})
+
+		It("should stop at the done sentinel", func() {
+			decoder := client.NewSSEDecoder(strings.NewReader("data: {}\n\ndata: [DONE]\n\ndata: ignored\n\n"), 0)
+			_, err := decoder.Decode()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = decoder.Decode()
+			Expect(err).To(MatchError(io.EOF))
+			Expect(decoder.Done()).To(BeTrue())
+			_, err = decoder.Decode()
+			Expect(err).To(MatchError(io.EOF))
+		})
+
+		It("should discard an incomplete event at the end of the stream", func() {
+			events, err := decodeAll("data: complete\n\ndata: incomplete", 0)
+			Expect(err).To(MatchError(io.EOF))
+			Expect(events).To(HaveLen(1))
+		})
+
+		It("should parse the retry field", func() {
+			events, _ := decodeAll("retry: 2500\ndata: a\n\nretry: soon\ndata: b\n\n", 0)
+			Expect(events[0].Retry).To(Equal(2500 * time.Millisecond))
+			Expect(events[1].Retry).To(BeZero())
+		})
+
+		It("should skip events larger than the maximum size", func() {
+			decoder := client.NewSSEDecoder(strings.NewReader("data: 12345\ndata: 6\n\ndata: "+strings.Repeat("x", 100)+"\n\ndata: 12345678\n\n"), 8)
+			event, err := decoder.Decode()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(event.Data).To(Equal("12345\n6"))
+			_, err = decoder.Decode()
+			Expect(err).To(MatchError(client.ErrEventTooLarge))
+			event, err = decoder.Decode()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(event.Data).To(Equal("12345678"))
+		})
+	})
+
+	Context("Test SSE stream", func() {
+		var (
+			testServer  *httptest.Server
+			connections atomic.Int32
+			lastEventID atomic.Value
+			ctx         = context.Background()
+		)
+
+		BeforeEach(func() {
+			connections.Store(0)
+			lastEventID.Store("")
+			testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Accept") != "text/event-stream" {
+					w.WriteHeader(http.StatusNotAcceptable)
+					return
+				}
+				if r.URL.Query().Get("status") == "error" {
+					w.WriteHeader(http.StatusBadRequest)
+					_, _ = w.Write([]byte(`{"error":{"message":"invalid model"}}`))
+					return
+				}
+				lastEventID.Store(r.Header.Get("Last-Event-ID"))
+				w.Header().Set("Content-Type", "text/event-stream")
+				if connections.Add(1) == 1 {
+					// the connection drops in the middle of the stream
+					_, _ = w.Write([]byte("retry: 10\nid: 1\ndata: first\n\nid: 2\ndata: sec"))
+					return
+				}
+				_, _ = w.Write([]byte("id: 2\ndata: second\n\ndata: [DONE]\n\n"))
+			}))
+		})
+
+		AfterEach(func() {
+			testServer.Close()
+		})
+
+		It("should reconnect with the last event id", func() {
+			stream, err := client.NewSSEStream(ctx, client.NewClient(), client.NewRequest(http.MethodGet, testServer.URL, nil), client.SSEConfig{MaxReconnects: 1})
+			Expect(err).ToNot(HaveOccurred())
+			defer stream.Close() //nolint:errcheck
+
+			var data []string
+			for {
+				event, err := stream.Recv()
+				if err != nil {
+					Expect(err).To(MatchError(io.EOF))
+					break
+				}
+				data = append(data, event.Data)
+			}
+			Expect(data).To(Equal([]string{"first", "second"}))
+			Expect(connections.Load()).To(Equal(int32(2)))
+			Expect(lastEventID.Load()).To(Equal("1"))
+		})
+
+		It("should not reconnect by default", func() {
+			stream, err := client.NewSSEStream(ctx, client.NewClient(), client.NewRequest(http.MethodGet, testServer.URL, nil), client.SSEConfig{})
+			Expect(err).ToNot(HaveOccurred())
+			defer stream.Close() //nolint:errcheck
+			_, err = stream.Recv()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = stream.Recv()
+			Expect(err).To(MatchError(io.EOF))
+			Expect(connections.Load()).To(Equal(int32(1)))
+		})
+
+		It("should return unsuccessful responses as HTTP errors", func() {
+			_, err := client.NewSSEStream(ctx, client.NewClient(), client.NewRequest(http.MethodPost, testServer.URL+"?status=error", []byte(`{}`)), client.SSEConfig{})
+			var httpErr *client.HTTPError
+			Expect(err).To(BeAssignableToTypeOf(httpErr))
+			Expect(client.IsHTTPStatus(err, http.StatusBadRequest)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring("invalid model")))
+		})
+	})
+})
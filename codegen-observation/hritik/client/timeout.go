@@ -0,0 +1,25 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// errResponseHeaderTimeout is the cause of streams cancelled by the default timeout before receiving the response headers
+var errResponseHeaderTimeout = fmt.Errorf("timed out waiting for the response headers: %w", context.DeadlineExceeded)
+
+type requestTimeoutKey struct{}
+
+// WithRequestTimeout returns a copy of ctx setting the timeout of the requests made with it,
+// instead of the default timeout of the client. A timeout of zero or less disables the timeout.
+// A deadline of ctx itself still applies.
+func WithRequestTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, requestTimeoutKey{}, timeout)
+}
+
+// requestTimeout returns the timeout set on ctx with WithRequestTimeout, if any
+func requestTimeout(ctx context.Context) (time.Duration, bool) {
+	timeout, ok := ctx.Value(requestTimeoutKey{}).(time.Duration)
+	return timeout, ok
+}
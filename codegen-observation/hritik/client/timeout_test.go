@@ -0,0 +1,131 @@
+package client_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/nutanix-core/nai-api/iep/constants/enum"
+	"github.com/nutanix-core/nai-api/iep/internal/client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Test request timeouts", func() {
+
+	var (
+		testServer *httptest.Server
+		ctx        = context.Background()
+	)
+
+	BeforeEach(func() {
+		testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			delay, _ := time.ParseDuration(r.URL.Query().Get("delay"))
+			if r.URL.Query().Get("stream") == "" {
+				time.Sleep(delay)
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			// send the headers right away and the body over delay
+			w.WriteHeader(http.StatusOK)
+			for i := 0; i < 4; i++ {
+				_, _ = w.Write([]byte("data\n"))
+				w.(http.Flusher).Flush()
+				time.Sleep(delay / 4)
+			}
+		}))
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	do := func(c client.IClient, ctx context.Context, query string) error {
+		req, err := http.NewRequest(http.MethodGet, testServer.URL+"?"+query, nil)
+		Expect(err).ToNot(HaveOccurred())
+		_, _, err = c.Do(ctx, req)
+		return err
+	}
+
+	stream := func(c client.IClient, ctx context.Context, query string) error {
+		req, err := http.NewRequest(http.MethodGet, testServer.URL+"?"+query, nil)
+		Expect(err).ToNot(HaveOccurred())
+		_, body, err := c.DoStream(ctx, req)
+		if err != nil {
+			return err
+		}
+		defer body.Close() //nolint:errcheck
+		_, err = io.ReadAll(body)
+		return err
+	}
+
+	It("should apply the default timeout to every request", func() {
+		c := client.NewClient(client.WithTimeout(50 * time.Millisecond))
+		Expect(do(c, ctx, "delay=200ms")).To(MatchError(context.DeadlineExceeded))
+		Expect(do(c, ctx, "")).To(Succeed())
+	})
+
+	It("should let the request timeout override the default one", func() {
+		c := client.NewClient(client.WithTimeout(50 * time.Millisecond))
+		Expect(do(c, client.WithRequestTimeout(ctx, time.Second), "delay=200ms")).To(Succeed())
+		Expect(do(c, client.WithRequestTimeout(ctx, 0), "delay=200ms")).To(Succeed())
+
+		c = client.NewClient(client.WithTimeout(time.Second))
+		Expect(do(c, client.WithRequestTimeout(ctx, 50*time.Millisecond), "delay=200ms")).To(MatchError(context.DeadlineExceeded))
+	})
+
+	It("should only bound the wait for the headers of a stream with the default timeout", func() {
+		c := client.NewClient(client.WithTimeout(50 * time.Millisecond))
+		Expect(stream(c, ctx, "stream=true&delay=200ms")).To(Succeed())
+		Expect(stream(c, ctx, "delay=200ms")).To(MatchError(context.DeadlineExceeded))
+	})
+
+	It("should bound the whole stream with the request timeout", func() {
+		c := client.NewClient()
+		Expect(stream(c, client.WithRequestTimeout(ctx, 50*time.Millisecond), "stream=true&delay=200ms")).To(MatchError(context.DeadlineExceeded))
+	})
+
+	It("should not let concurrent health checks change the timeout of other requests", func() {
+		c := client.NewClient(client.WithTimeout(time.Minute))
+		healthClient := client.NewHealthClient(c)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(2)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				Expect(healthClient.CheckHealth(testServer.URL)).To(Equal(enum.HealthyStatusCode))
+			}()
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				Expect(stream(c, ctx, "stream=true&delay=100ms")).To(Succeed())
+			}()
+		}
+		wg.Wait()
+		Expect(c.GetTimeout()).To(Equal(time.Minute))
+	})
+
+	It("should allow changing the default timeout while requests are in flight", func() {
+		c := client.NewClient()
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(2)
+			go func(i int) {
+				defer wg.Done()
+				c.SetTimeout(time.Duration(i+1) * time.Second)
+			}(i)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				Expect(do(c, ctx, "delay=10ms")).To(Succeed())
+			}()
+		}
+		wg.Wait()
+		Expect(c.GetTimeout()).To(BeNumerically(">=", time.Second))
+	})
+})
@@ -0,0 +1,302 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTokenExpiryDelta is how long before its expiry a cached token is refreshed
+const DefaultTokenExpiryDelta = 10 * time.Second
+
+// Token is an access token sent in the Authorization header
+type Token struct {
+	AccessToken string
+	// Type is the authorization scheme, Bearer when empty
+	Type string
+	// Expiry is the time the token expires at, a zero expiry never expires
+	Expiry time.Time
+}
+
+// valid reports whether the token can still be used delta before its expiry
+func (t *Token) valid(now time.Time, delta time.Duration) bool {
+	return t != nil && t.AccessToken != "" && (t.Expiry.IsZero() || now.Add(delta).Before(t.Expiry))
+}
+
+// header returns the Authorization header value of the token
+func (t *Token) header() string {
+	if t.Type == "" || strings.EqualFold(t.Type, "bearer") {
+		// token endpoints commonly answer with a lower case "bearer"
+		return "Bearer " + t.AccessToken
+	}
+	return t.Type + " " + t.AccessToken
+}
+
+// TokenSource returns the token requests are authenticated with
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+// invalidator is implemented by token sources that can drop a token rejected by a server
+type invalidator interface {
+	invalidate(token *Token)
+}
+
+// TokenSourceInterceptor sets the Authorization header of requests that do not carry one with a token from source.
+// The token of a request answered with 401 is invalidated, and the request is sent once more when source
+// returns a different token, unless its body cannot be replayed.
+func TokenSourceInterceptor(source TokenSource) Interceptor {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get("Authorization") != "" {
+				return next.RoundTrip(req)
+			}
+			token, err := source.Token(req.Context())
+			if err != nil {
+				return nil, fmt.Errorf("failed to get token for %s: %w", req.URL.Host, err)
+			}
+			resp, err := next.RoundTrip(authorize(req, token))
+			if err != nil || resp.StatusCode != http.StatusUnauthorized {
+				return resp, err
+			}
+			// the next requests get another token even when this one cannot be replayed
+			if source, ok := source.(invalidator); ok {
+				source.invalidate(token)
+			}
+			if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+				return resp, nil
+			}
+			refreshed, err := source.Token(req.Context())
+			if err != nil || refreshed.AccessToken == token.AccessToken {
+				// nothing better to send, the caller gets the 401
+				return resp, nil
+			}
+			retry := authorize(req, refreshed)
+			if req.Body != nil && req.Body != http.NoBody {
+				if retry.Body, err = req.GetBody(); err != nil {
+					return resp, nil
+				}
+			}
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, MaxHTTPErrorBodySize))
+			_ = resp.Body.Close()
+			return next.RoundTrip(retry)
+		})
+	}
+}
+
+// WithTokenSource authenticates every request that does not carry an Authorization header with a token from source
+func WithTokenSource(source TokenSource) Option {
+	return WithInterceptors(TokenSourceInterceptor(source))
+}
+
+// authorize returns a copy of req carrying token, round trippers must not modify the request they are given
+func authorize(req *http.Request, token *Token) *http.Request {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", token.header())
+	return req
+}
+
+type staticTokenSource struct {
+	token *Token
+}
+
+// StaticTokenSource returns a source always returning the same bearer token
+func StaticTokenSource(token string) TokenSource {
+	return &staticTokenSource{token: &Token{AccessToken: token}}
+}
+
+func (s *staticTokenSource) Token(context.Context) (*Token, error) {
+	return s.token, nil
+}
+
+// fileTokenSource reads its token from a file that is rotated on disk, such as a projected service account token
+type fileTokenSource struct {
+	path string
+
+	mu    sync.Mutex
+	stamp fileStamp
+	token *Token
+	// stale is set when a server rejected the token, the file is read again even when it looks unchanged
+	stale bool
+}
+
+// NewFileTokenSource returns a source reading a bearer token from path. The file is read again whenever it changes
+// or a server rejects the token, so that a token rotated on disk is picked up by the next request or by the retry
+// of the rejected one.
+func NewFileTokenSource(path string) TokenSource {
+	return &fileTokenSource{path: path}
+}
+
+func (s *fileTokenSource) Token(context.Context) (*Token, error) {
+	info, err := os.Stat(s.path)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token file %s: %w", s.path, err)
+	}
+	stamp := fileStamp{modTime: info.ModTime(), size: info.Size()}
+	if s.token != nil && stamp == s.stamp && !s.stale {
+		return s.token, nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	token := strings.TrimSpace(string(data))
+	if err == nil && token == "" {
+		err = errors.New("file is empty")
+	}
+	if err != nil {
+		if s.token != nil {
+			// the file may be half written, keep the last token until the next change
+			s.stamp = stamp
+			return s.token, nil
+		}
+		return nil, fmt.Errorf("failed to read token file %s: %w", s.path, err)
+	}
+	if s.token == nil || s.token.AccessToken != token {
+		s.token = &Token{AccessToken: token}
+	}
+	s.stamp, s.stale = stamp, false
+	return s.token, nil
+}
+
+func (s *fileTokenSource) invalidate(token *Token) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// the file may be rotated without its modification time or size changing
+	if s.token != nil && s.token.AccessToken == token.AccessToken {
+		s.stale = true
+	}
+}
+
+// tokenFunc adapts a function returning bearer tokens to the TokenSource interface
+type tokenFunc func(ctx context.Context) (string, error)
+
+func (f tokenFunc) Token(ctx context.Context) (*Token, error) {
+	value, err := f(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Token{AccessToken: value}, nil
+}
+
+// cachingTokenSource returns the token of its source until it expires or is rejected by a server
+type cachingTokenSource struct {
+	source      TokenSource
+	expiryDelta time.Duration
+
+	// mu is held while fetching, concurrent callers wait for a single fetch
+	mu    sync.Mutex
+	token *Token
+}
+
+// NewCachingTokenSource returns a source caching the tokens of source until expiryDelta before they expire,
+// an expiryDelta of zero or less uses DefaultTokenExpiryDelta
+func NewCachingTokenSource(source TokenSource, expiryDelta time.Duration) TokenSource {
+	if expiryDelta <= 0 {
+		expiryDelta = DefaultTokenExpiryDelta
+	}
+	return &cachingTokenSource{source: source, expiryDelta: expiryDelta}
+}
+
+func (s *cachingTokenSource) Token(ctx context.Context) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token.valid(time.Now(), s.expiryDelta) {
+		return s.token, nil
+	}
+	token, err := s.source.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.token = token
+	return token, nil
+}
+
+func (s *cachingTokenSource) invalidate(token *Token) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// another request may already have replaced the rejected token
+	if s.token != nil && s.token.AccessToken == token.AccessToken {
+		s.token = nil
+	}
+}
+
+// ClientCredentialsConfig configures the OAuth2 client credentials flow
+type ClientCredentialsConfig struct {
+	// TokenURL is the token endpoint of the authorization server
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// EndpointParams are additional form parameters sent to the token endpoint, such as an audience
+	EndpointParams url.Values
+	// ExpiryDelta is how long before their expiry tokens are refreshed, DefaultTokenExpiryDelta by default
+	ExpiryDelta time.Duration
+}
+
+type clientCredentialsTokenSource struct {
+	client IClient
+	config ClientCredentialsConfig
+}
+
+// NewClientCredentialsTokenSource returns a caching source fetching tokens from the token endpoint with the
+// OAuth2 client credentials grant. c sends the token requests and must not itself use the returned source.
+func NewClientCredentialsTokenSource(c IClient, config ClientCredentialsConfig) TokenSource {
+	return NewCachingTokenSource(&clientCredentialsTokenSource{client: c, config: config}, config.ExpiryDelta)
+}
+
+// tokenResponse is the successful response of a token endpoint, see RFC 6749 section 5.1
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (s *clientCredentialsTokenSource) Token(ctx context.Context) (*Token, error) {
+	form := url.Values{}
+	for key, values := range s.config.EndpointParams {
+		form[key] = append([]string(nil), values...)
+	}
+	form.Set("grant_type", "client_credentials")
+	if len(s.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.config.Scopes, " "))
+	}
+	req := NewRequest(http.MethodPost, s.config.TokenURL, []byte(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", contentTypeJSON)
+	// credentials are form encoded before being used as basic auth, see RFC 6749 section 2.3.1
+	basic := &http.Request{Header: http.Header{}}
+	basic.SetBasicAuth(url.QueryEscape(s.config.ClientID), url.QueryEscape(s.config.ClientSecret))
+	req.Header.Set("Authorization", basic.Header.Get("Authorization"))
+
+	start := time.Now()
+	result, err := s.client.DoWithRetry(ctx, req, RetryPolicy{MaxAttempts: 1})
+	if result != nil && (result.Response.StatusCode < 200 || result.Response.StatusCode > 299) {
+		return nil, newHTTPError(req, result, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var resp tokenResponse
+	if err := json.Unmarshal(result.Body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode token response from %s: %w", s.config.TokenURL, err)
+	}
+	if resp.AccessToken == "" {
+		return nil, fmt.Errorf("token response from %s has no access token", s.config.TokenURL)
+	}
+	token := &Token{AccessToken: resp.AccessToken, Type: resp.TokenType}
+	if resp.ExpiresIn > 0 {
+		// the lifetime counts from when the server answered, start errs on the safe side
+		token.Expiry = start.Add(time.Duration(resp.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
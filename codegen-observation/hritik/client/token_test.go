@@ -0,0 +1,241 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/nutanix-core/nai-api/iep/internal/client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Test token sources", func() {
+
+	var (
+		apiServer *httptest.Server
+		accepted  string
+		apiCalls  atomic.Int32
+		ctx       = context.Background()
+	)
+
+	BeforeEach(func() {
+		accepted = ""
+		apiCalls.Store(0)
+		apiServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			apiCalls.Add(1)
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("X-Authorization", r.Header.Get("Authorization"))
+			w.Header().Set("X-Body", string(body))
+			if accepted != "" && r.Header.Get("Authorization") != "Bearer "+accepted {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+	})
+
+	AfterEach(func() {
+		apiServer.Close()
+	})
+
+	send := func(c client.IClient, method string, body string) *http.Response {
+		req, err := http.NewRequest(method, apiServer.URL, strings.NewReader(body))
+		Expect(err).ToNot(HaveOccurred())
+		resp, _, err := c.Do(ctx, req)
+		Expect(err).ToNot(HaveOccurred())
+		return resp
+	}
+
+	// tokenServer issues the given tokens in turn with the given lifetime and counts the requests it receives
+	tokenServer := func(expiresIn int, tokens ...string) (*httptest.Server, *atomic.Int32) {
+		var fetches atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := int(fetches.Add(1))
+			// credentials are form encoded before being used as basic auth
+			id, secret, ok := r.BasicAuth()
+			secret, _ = url.QueryUnescape(secret)
+			if !ok || id != "nai" || secret != "s3cr%t" {
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(`{"error": "invalid_client"}`))
+				return
+			}
+			Expect(r.ParseForm()).To(Succeed())
+			Expect(r.PostForm.Get("grant_type")).To(Equal("client_credentials"))
+			Expect(r.PostForm.Get("scope")).To(Equal("registry:read registry:write"))
+			Expect(r.PostForm.Get("audience")).To(Equal("registry"))
+			token := tokens[min(n, len(tokens))-1]
+			_ = json.NewEncoder(w).Encode(map[string]any{"access_token": token, "token_type": "bearer", "expires_in": expiresIn})
+		}))
+		return server, &fetches
+	}
+
+	clientCredentials := func(tokenURL string, secret string) client.TokenSource {
+		return client.NewClientCredentialsTokenSource(client.NewClient(), client.ClientCredentialsConfig{
+			TokenURL:       tokenURL,
+			ClientID:       "nai",
+			ClientSecret:   secret,
+			Scopes:         []string{"registry:read", "registry:write"},
+			EndpointParams: map[string][]string{"audience": {"registry"}},
+		})
+	}
+
+	Context("Test static tokens", func() {
+		It("should authenticate requests without an Authorization header", func() {
+			c := client.NewClient(client.WithTokenSource(client.StaticTokenSource("mock_token")))
+			resp := send(c, http.MethodGet, "")
+			Expect(resp.Header.Get("X-Authorization")).To(Equal("Bearer mock_token"))
+
+			Expect(c.MakeRequestWithRetry(ctx, apiServer.URL, http.MethodGet, nil, map[string]string{"Authorization": "Basic abc"}, 1, 0)).To(Succeed())
+			req, err := http.NewRequest(http.MethodGet, apiServer.URL, nil)
+			Expect(err).ToNot(HaveOccurred())
+			req.Header.Set("Authorization", "Basic abc")
+			resp, _, err = c.Do(ctx, req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.Header.Get("X-Authorization")).To(Equal("Basic abc"))
+		})
+
+		It("should not retry a rejected token that cannot change", func() {
+			accepted = "other"
+			c := client.NewClient(client.WithTokenSource(client.StaticTokenSource("mock_token")))
+			resp := send(c, http.MethodGet, "")
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(apiCalls.Load()).To(BeEquivalentTo(1))
+		})
+	})
+
+	Context("Test file tokens", func() {
+		It("should pick up a token rotated on disk", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "token")
+			c := client.NewClient(client.WithTokenSource(client.NewFileTokenSource(path)))
+			req, err := http.NewRequest(http.MethodGet, apiServer.URL, nil)
+			Expect(err).ToNot(HaveOccurred())
+			_, _, err = c.Do(ctx, req)
+			Expect(err).To(MatchError(os.ErrNotExist))
+
+			Expect(os.WriteFile(path, []byte("first\n"), 0o600)).To(Succeed())
+			Expect(send(c, http.MethodGet, "").Header.Get("X-Authorization")).To(Equal("Bearer first"))
+
+			Expect(os.WriteFile(path, []byte("rotated\n"), 0o600)).To(Succeed())
+			Expect(send(c, http.MethodGet, "").Header.Get("X-Authorization")).To(Equal("Bearer rotated"))
+
+			// a half written file keeps the last token
+			Expect(os.WriteFile(path, nil, 0o600)).To(Succeed())
+			Expect(send(c, http.MethodGet, "").Header.Get("X-Authorization")).To(Equal("Bearer rotated"))
+		})
+
+		It("should read the file again and retry when the token is rejected", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "token")
+			Expect(os.WriteFile(path, []byte("first"), 0o600)).To(Succeed())
+			info, err := os.Stat(path)
+			Expect(err).ToNot(HaveOccurred())
+			c := client.NewClient(client.WithTokenSource(client.NewFileTokenSource(path)))
+			Expect(send(c, http.MethodGet, "").Header.Get("X-Authorization")).To(Equal("Bearer first"))
+
+			// a rotation keeping the size and modification time of the file goes unnoticed until a server rejects the token
+			Expect(os.WriteFile(path, []byte("secnd"), 0o600)).To(Succeed())
+			Expect(os.Chtimes(path, info.ModTime(), info.ModTime())).To(Succeed())
+			accepted = "secnd"
+			resp := send(c, http.MethodPost, `{"model": "llama"}`)
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header.Get("X-Authorization")).To(Equal("Bearer secnd"))
+			Expect(resp.Header.Get("X-Body")).To(Equal(`{"model": "llama"}`))
+			Expect(apiCalls.Load()).To(BeEquivalentTo(3))
+		})
+	})
+
+	Context("Test client credentials", func() {
+		It("should cache tokens until they expire", func() {
+			server, fetches := tokenServer(3600, "t1", "t2")
+			defer server.Close()
+			c := client.NewClient(client.WithTokenSource(clientCredentials(server.URL, "s3cr%t")))
+
+			for i := 0; i < 3; i++ {
+				Expect(send(c, http.MethodGet, "").Header.Get("X-Authorization")).To(Equal("Bearer t1"))
+			}
+			Expect(fetches.Load()).To(BeEquivalentTo(1))
+		})
+
+		It("should refresh tokens about to expire", func() {
+			server, fetches := tokenServer(1, "t1", "t2")
+			defer server.Close()
+			c := client.NewClient(client.WithTokenSource(clientCredentials(server.URL, "s3cr%t")))
+
+			Expect(send(c, http.MethodGet, "").Header.Get("X-Authorization")).To(Equal("Bearer t1"))
+			Expect(send(c, http.MethodGet, "").Header.Get("X-Authorization")).To(Equal("Bearer t2"))
+			Expect(fetches.Load()).To(BeEquivalentTo(2))
+		})
+
+		It("should refresh the token and replay the request once on 401", func() {
+			accepted = "t2"
+			server, fetches := tokenServer(3600, "t1", "t2")
+			defer server.Close()
+			c := client.NewClient(client.WithTokenSource(clientCredentials(server.URL, "s3cr%t")))
+
+			resp := send(c, http.MethodPost, `{"model": "llama"}`)
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header.Get("X-Body")).To(Equal(`{"model": "llama"}`))
+			Expect(apiCalls.Load()).To(BeEquivalentTo(2))
+			Expect(fetches.Load()).To(BeEquivalentTo(2))
+
+			Expect(send(c, http.MethodGet, "").StatusCode).To(Equal(http.StatusOK))
+			Expect(fetches.Load()).To(BeEquivalentTo(2))
+		})
+
+		It("should invalidate the rejected token of a request that cannot be replayed", func() {
+			accepted = "t2"
+			server, fetches := tokenServer(3600, "t1", "t2")
+			defer server.Close()
+			c := client.NewClient(client.WithTokenSource(clientCredentials(server.URL, "s3cr%t")))
+
+			// the body of the request has no GetBody
+			req, err := http.NewRequest(http.MethodPost, apiServer.URL, io.MultiReader(strings.NewReader(`{"model": "llama"}`)))
+			Expect(err).ToNot(HaveOccurred())
+			resp, _, err := c.Do(ctx, req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(apiCalls.Load()).To(BeEquivalentTo(1))
+
+			Expect(send(c, http.MethodGet, "").Header.Get("X-Authorization")).To(Equal("Bearer t2"))
+			Expect(fetches.Load()).To(BeEquivalentTo(2))
+		})
+
+		It("should return the 401 when the refreshed token is rejected too", func() {
+			accepted = "other"
+			server, fetches := tokenServer(3600, "t1", "t2", "t3")
+			defer server.Close()
+			c := client.NewClient(client.WithTokenSource(clientCredentials(server.URL, "s3cr%t")))
+
+			Expect(send(c, http.MethodGet, "").StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(apiCalls.Load()).To(BeEquivalentTo(2))
+			Expect(fetches.Load()).To(BeEquivalentTo(2))
+		})
+
+		It("should fail requests when no token can be fetched", func() {
+			server, _ := tokenServer(3600, "t1")
+			defer server.Close()
+			source := clientCredentials(server.URL, "wrong")
+
+			_, err := source.Token(ctx)
+			var httpErr *client.HTTPError
+			Expect(errors.As(err, &httpErr)).To(BeTrue())
+			Expect(httpErr.StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(httpErr.Message).To(Equal("invalid_client"))
+
+			c := client.NewClient(client.WithTokenSource(source))
+			req, err := http.NewRequest(http.MethodGet, apiServer.URL, nil)
+			Expect(err).ToNot(HaveOccurred())
+			_, _, err = c.Do(ctx, req)
+			Expect(client.IsHTTPStatus(err, http.StatusUnauthorized)).To(BeTrue())
+			Expect(apiCalls.Load()).To(BeEquivalentTo(0))
+		})
+	})
+})
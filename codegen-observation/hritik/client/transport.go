@@ -0,0 +1,345 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTLSReloadInterval is how often the CA bundle and client certificate files are checked for changes
+// unless configured otherwise with WithTLSReloadInterval
+const DefaultTLSReloadInterval = 10 * time.Second
+
+// ErrInvalidOptions is matched by the errors NewClientWithOptions returns when options cannot be combined
+var ErrInvalidOptions = errors.New("invalid client options")
+
+type tlsOptions struct {
+	caFile     string
+	certFile   string
+	keyFile    string
+	minVersion uint16
+}
+
+// WithCABundle trusts the PEM encoded certificates of the file at path in addition to the system roots.
+// The file is read again when it changes on disk, see WithTLSReloadInterval.
+func WithCABundle(path string) Option {
+	return func(o *clientOptions) {
+		o.tls.caFile = path
+	}
+}
+
+// WithClientCertificate presents the PEM encoded certificate and key to servers requiring mutual TLS.
+// The files are read again when they change on disk, see WithTLSReloadInterval.
+func WithClientCertificate(certFile, keyFile string) Option {
+	return func(o *clientOptions) {
+		o.tls.certFile = certFile
+		o.tls.keyFile = keyFile
+	}
+}
+
+// WithTLSReloadInterval sets how often the files of WithCABundle and WithClientCertificate are checked for
+// changes, a change is picked up by the first request after the interval. Zero checks them on every request.
+func WithTLSReloadInterval(interval time.Duration) Option {
+	return func(o *clientOptions) {
+		o.tlsReloadInterval = interval
+	}
+}
+
+// WithMinTLSVersion sets the minimum TLS version accepted, such as tls.VersionTLS12
+func WithMinTLSVersion(version uint16) Option {
+	return func(o *clientOptions) {
+		o.tls.minVersion = version
+	}
+}
+
+// WithProxy sends requests through proxyURL, except requests to the hosts matched by noProxy.
+// noProxy is a comma separated list in the NO_PROXY format: host names matching the host and its
+// subdomains, domains with a leading dot matching subdomains only, IP addresses, CIDR ranges,
+// each optionally followed by a port, or * to match every host. As with http.ProxyFromEnvironment,
+// requests to localhost and loopback addresses are never proxied.
+// Without this option the proxy is read from the environment. It cannot be combined with WithUnixSocket.
+func WithProxy(proxyURL *url.URL, noProxy string) Option {
+	return func(o *clientOptions) {
+		o.proxyURL = proxyURL
+		o.noProxy = noProxy
+	}
+}
+
+// WithUnixSocket dials every connection to the unix socket at path, such as the one of a local sidecar.
+// The host of the request url is still sent in the Host header. It cannot be combined with WithProxy.
+func WithUnixSocket(path string) Option {
+	return func(o *clientOptions) {
+		o.unixSocket = path
+	}
+}
+
+// newTransport returns the transport configured by options, or the one given with WithTransport when
+// options do not customize it. It fails when the options cannot be applied: dropping them would leave
+// callers believing their TLS or routing requirements are enforced.
+func newTransport(options clientOptions) (http.RoundTripper, error) {
+	if options.tls == (tlsOptions{}) && options.proxyURL == nil && options.unixSocket == "" {
+		return options.transport, nil
+	}
+	if options.proxyURL != nil && options.unixSocket != "" {
+		return nil, fmt.Errorf("%w: WithProxy cannot be combined with WithUnixSocket, proxied requests would be sent to the socket", ErrInvalidOptions)
+	}
+	base, ok := options.transport.(*http.Transport)
+	if options.transport == nil {
+		base, ok = http.DefaultTransport.(*http.Transport)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: TLS, proxy and unix socket options require WithTransport to be an *http.Transport, got %T", ErrInvalidOptions, options.transport)
+	}
+	base = base.Clone()
+
+	if options.proxyURL != nil {
+		base.Proxy = proxyFunc(options.proxyURL, options.noProxy)
+	}
+	if options.unixSocket != "" {
+		// a proxy from the environment is meant for remote hosts, not for the socket
+		base.Proxy = nil
+		var dialer net.Dialer
+		base.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", options.unixSocket)
+		}
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if base.TLSClientConfig != nil {
+		tlsConfig = base.TLSClientConfig.Clone()
+	}
+	if options.tls.minVersion != 0 {
+		tlsConfig.MinVersion = options.tls.minVersion
+	}
+	base.TLSClientConfig = tlsConfig
+	if options.tls.caFile == "" && options.tls.certFile == "" {
+		return base, nil
+	}
+	return &reloadingTransport{base: base, options: options.tls, interval: options.tlsReloadInterval}, nil
+}
+
+// reloadingTransport rebuilds its transport whenever the CA bundle or client certificate files change on disk.
+// Files are loaded on the first request, so that a missing or invalid file fails requests rather than the client creation.
+// Once loaded, the files are checked for changes at most once per interval.
+type reloadingTransport struct {
+	base     *http.Transport
+	options  tlsOptions
+	interval time.Duration
+
+	mu      sync.Mutex
+	stamps  []fileStamp
+	checked time.Time
+	current *http.Transport
+}
+
+// fileStamp tells whether a file changed since it was last read
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func (t *reloadingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport, err := t.transport()
+	if err != nil {
+		return nil, err
+	}
+	return transport.RoundTrip(req)
+}
+
+// CloseIdleConnections closes the idle connections of the current transport
+func (t *reloadingTransport) CloseIdleConnections() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.current != nil {
+		t.current.CloseIdleConnections()
+	}
+}
+
+// transport returns the transport built from the content of the files when they were last checked
+func (t *reloadingTransport) transport() (*http.Transport, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.current != nil && time.Since(t.checked) < t.interval {
+		return t.current, nil
+	}
+	stamps := t.stat()
+	t.checked = time.Now()
+	if t.current != nil && equalStamps(stamps, t.stamps) {
+		return t.current, nil
+	}
+
+	tlsConfig, err := t.load()
+	if err != nil {
+		if t.current != nil {
+			// a file may be half written, keep the last valid files until the next change
+			t.stamps = stamps
+			return t.current, nil
+		}
+		return nil, err
+	}
+	next := t.base.Clone()
+	next.TLSClientConfig = tlsConfig
+	if t.current != nil {
+		// requests in flight complete on their connections, new requests use the new files
+		t.current.CloseIdleConnections()
+	}
+	t.current, t.stamps = next, stamps
+	return next, nil
+}
+
+func (t *reloadingTransport) stat() []fileStamp {
+	paths := []string{t.options.caFile, t.options.certFile, t.options.keyFile}
+	stamps := make([]fileStamp, len(paths))
+	for i, path := range paths {
+		if path == "" {
+			continue
+		}
+		// a file that cannot be stated gets a zero stamp and fails loading
+		if info, err := os.Stat(path); err == nil {
+			stamps[i] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		}
+	}
+	return stamps
+}
+
+// load reads the files into a copy of the base TLS configuration
+func (t *reloadingTransport) load() (*tls.Config, error) {
+	tlsConfig := t.base.TLSClientConfig.Clone()
+	if t.options.caFile != "" {
+		bundle, err := os.ReadFile(t.options.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle %s: %w", t.options.caFile, err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificate found in CA bundle %s", t.options.caFile)
+		}
+		tlsConfig.RootCAs = roots
+	}
+	if t.options.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.options.certFile, t.options.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate %s: %w", t.options.certFile, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func equalStamps(a, b []fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].modTime.Equal(b[i].modTime) || a[i].size != b[i].size {
+			return false
+		}
+	}
+	return true
+}
+
+// proxyFunc returns a proxy function sending requests through proxyURL unless noProxy matches their host
+func proxyFunc(proxyURL *url.URL, noProxy string) func(*http.Request) (*url.URL, error) {
+	var rules []noProxyRule
+	for _, entry := range strings.Split(noProxy, ",") {
+		if rule, ok := parseNoProxyRule(entry); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		host, port := req.URL.Hostname(), req.URL.Port()
+		if port == "" {
+			port = defaultPort(req.URL.Scheme)
+		}
+		if bypassProxy(strings.ToLower(host), port, rules) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}
+}
+
+// noProxyRule is a single NO_PROXY entry
+type noProxyRule struct {
+	all    bool
+	ip     net.IP
+	ipNet  *net.IPNet
+	domain string
+	// exact is set for domains without a leading dot, which also match the domain itself
+	exact bool
+	port  string
+}
+
+func parseNoProxyRule(entry string) (noProxyRule, bool) {
+	entry = strings.ToLower(strings.TrimSpace(entry))
+	if entry == "" {
+		return noProxyRule{}, false
+	}
+	if entry == "*" {
+		return noProxyRule{all: true}, true
+	}
+	if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+		return noProxyRule{ipNet: ipNet}, true
+	}
+	var rule noProxyRule
+	if host, port, err := net.SplitHostPort(entry); err == nil {
+		entry, rule.port = host, port
+	}
+	if ip := net.ParseIP(strings.Trim(entry, "[]")); ip != nil {
+		rule.ip = ip
+		return rule, true
+	}
+	rule.exact = !strings.HasPrefix(entry, ".") && !strings.HasPrefix(entry, "*.")
+	rule.domain = strings.TrimPrefix(strings.TrimPrefix(entry, "*"), ".")
+	return rule, rule.domain != ""
+}
+
+func bypassProxy(host, port string, rules []noProxyRule) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && ip.IsLoopback() {
+		return true
+	}
+	for _, rule := range rules {
+		if rule.port != "" && rule.port != port {
+			continue
+		}
+		switch {
+		case rule.all:
+			return true
+		case rule.ipNet != nil:
+			if ip != nil && rule.ipNet.Contains(ip) {
+				return true
+			}
+		case rule.ip != nil:
+			if ip != nil && rule.ip.Equal(ip) {
+				return true
+			}
+		case rule.exact && host == rule.domain:
+			return true
+		case strings.HasSuffix(host, "."+rule.domain):
+			return true
+		}
+	}
+	return false
+}
+
+func defaultPort(scheme string) string {
+	if scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
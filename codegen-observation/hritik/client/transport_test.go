@@ -0,0 +1,302 @@
+package client_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nutanix-core/nai-api/iep/internal/client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Test client transport options", func() {
+
+	var (
+		dir string
+		ctx = context.Background()
+	)
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+	})
+
+	get := func(c client.IClient, url string) (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		Expect(err).ToNot(HaveOccurred())
+		resp, _, err := c.Do(ctx, req)
+		return resp, err
+	}
+
+	writeCA := func(path string, server *httptest.Server) {
+		ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+		Expect(os.WriteFile(path, ca, 0o600)).To(Succeed())
+	}
+
+	Context("Test CA bundle", func() {
+		It("should trust servers signed by the bundle", func() {
+			testServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer testServer.Close()
+
+			_, err := get(client.NewClient(), testServer.URL)
+			Expect(err).To(HaveOccurred())
+
+			caFile := filepath.Join(dir, "ca.pem")
+			writeCA(caFile, testServer)
+			resp, err := get(client.NewClient(client.WithCABundle(caFile)), testServer.URL)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+
+		It("should fail requests when the bundle cannot be loaded", func() {
+			c := client.NewClient(client.WithCABundle(filepath.Join(dir, "missing.pem")))
+			_, err := get(c, "https://engine.invalid")
+			Expect(err).To(MatchError(ContainSubstring("failed to read CA bundle")))
+		})
+
+		It("should reload the bundle when it changes", func() {
+			handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			testServer := httptest.NewTLSServer(handler)
+			defer testServer.Close()
+			otherServer := httptest.NewUnstartedServer(handler)
+			otherServer.TLS = &tls.Config{Certificates: []tls.Certificate{selfSignedCertificate("other")}}
+			otherServer.StartTLS()
+			defer otherServer.Close()
+
+			caFile := filepath.Join(dir, "ca.pem")
+			writeCA(caFile, otherServer)
+			c := client.NewClient(client.WithCABundle(caFile), client.WithTLSReloadInterval(0))
+			_, err := get(c, testServer.URL)
+			Expect(err).To(HaveOccurred())
+
+			writeCA(caFile, testServer)
+			Expect(os.Chtimes(caFile, time.Now(), time.Now().Add(time.Second))).To(Succeed())
+			_, err = get(c, testServer.URL)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should not check the bundle more than once per interval", func() {
+			handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			testServer := httptest.NewTLSServer(handler)
+			defer testServer.Close()
+			otherServer := httptest.NewUnstartedServer(handler)
+			otherServer.TLS = &tls.Config{Certificates: []tls.Certificate{selfSignedCertificate("other")}}
+			otherServer.StartTLS()
+			defer otherServer.Close()
+
+			caFile := filepath.Join(dir, "ca.pem")
+			writeCA(caFile, testServer)
+			c := client.NewClient(client.WithCABundle(caFile), client.WithTLSReloadInterval(time.Hour))
+			_, err := get(c, testServer.URL)
+			Expect(err).ToNot(HaveOccurred())
+
+			writeCA(caFile, otherServer)
+			Expect(os.Chtimes(caFile, time.Now(), time.Now().Add(time.Second))).To(Succeed())
+			_, err = get(c, testServer.URL)
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Context("Test client certificate", func() {
+		It("should present the client certificate and reload it", func() {
+			var subjects []string
+			testServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				subjects = append(subjects, r.TLS.PeerCertificates[0].Subject.CommonName)
+				w.WriteHeader(http.StatusOK)
+			}))
+			testServer.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+			testServer.StartTLS()
+			defer testServer.Close()
+
+			caFile := filepath.Join(dir, "ca.pem")
+			certFile := filepath.Join(dir, "client.pem")
+			keyFile := filepath.Join(dir, "client.key")
+			writeCA(caFile, testServer)
+			writeKeyPair(certFile, keyFile, selfSignedCertificate("first"))
+			c := client.NewClient(client.WithCABundle(caFile), client.WithClientCertificate(certFile, keyFile), client.WithTLSReloadInterval(0))
+			_, err := get(c, testServer.URL)
+			Expect(err).ToNot(HaveOccurred())
+
+			writeKeyPair(certFile, keyFile, selfSignedCertificate("second"))
+			Expect(os.Chtimes(certFile, time.Now(), time.Now().Add(time.Second))).To(Succeed())
+			_, err = get(c, testServer.URL)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(subjects).To(Equal([]string{"first", "second"}))
+		})
+	})
+
+	Context("Test minimum TLS version", func() {
+		It("should refuse servers below the minimum version", func() {
+			testServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			testServer.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+			testServer.StartTLS()
+			defer testServer.Close()
+
+			caFile := filepath.Join(dir, "ca.pem")
+			writeCA(caFile, testServer)
+			_, err := get(client.NewClient(client.WithCABundle(caFile)), testServer.URL)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = get(client.NewClient(client.WithCABundle(caFile), client.WithMinTLSVersion(tls.VersionTLS13)), testServer.URL)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Test proxy", func() {
+		var proxy *httptest.Server
+
+		BeforeEach(func() {
+			proxy = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Proxied-Host", r.URL.Host)
+				w.WriteHeader(http.StatusOK)
+			}))
+		})
+
+		AfterEach(func() {
+			proxy.Close()
+		})
+
+		It("should send requests through the proxy", func() {
+			proxyURL, err := url.Parse(proxy.URL)
+			Expect(err).ToNot(HaveOccurred())
+			c := client.NewClient(client.WithProxy(proxyURL, "registry.internal"))
+			resp, err := get(c, "http://engine.internal:8080/health")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.Header.Get("X-Proxied-Host")).To(Equal("engine.internal:8080"))
+		})
+
+		DescribeTable("should bypass the proxy for hosts matched by no proxy",
+			func(noProxy string, target string, bypassed bool) {
+				proxyURL, err := url.Parse(proxy.URL)
+				Expect(err).ToNot(HaveOccurred())
+				c := client.NewClient(client.WithProxy(proxyURL, noProxy), client.WithTimeout(time.Second))
+				resp, err := get(c, target)
+				if bypassed {
+					// the hosts are not reachable, only the proxy can answer
+					Expect(err).To(HaveOccurred())
+					return
+				}
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.Header.Get("X-Proxied-Host")).ToNot(BeEmpty())
+			},
+			Entry("host name", "engine.invalid", "http://engine.invalid", true),
+			Entry("subdomain of host name", "invalid", "http://engine.invalid", true),
+			Entry("leading dot excludes the domain", ".engine.invalid", "http://engine.invalid", false),
+			Entry("leading dot matches subdomains", ".engine.invalid", "http://api.engine.invalid", true),
+			Entry("matching port", "engine.invalid:8080", "http://engine.invalid:8080", true),
+			Entry("other port", "engine.invalid:8080", "http://engine.invalid", false),
+			Entry("CIDR range", "192.0.2.0/24", "http://192.0.2.10:1", true),
+			Entry("wildcard", "*", "http://engine.invalid", true),
+			Entry("unrelated host", "registry.invalid, 192.0.2.1", "http://engine.invalid", false),
+		)
+	})
+
+	Context("Test unix socket", func() {
+		It("should dial the unix socket", func() {
+			socket := filepath.Join(dir, "sidecar.sock")
+			listener, err := net.Listen("unix", socket)
+			Expect(err).ToNot(HaveOccurred())
+			testServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Host", r.Host)
+				w.WriteHeader(http.StatusOK)
+			}))
+			testServer.Listener = listener
+			testServer.Start()
+			defer testServer.Close()
+
+			resp, err := get(client.NewClient(client.WithUnixSocket(socket)), "http://sidecar/health")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.Header.Get("X-Host")).To(Equal("sidecar"))
+		})
+
+		It("should reject a proxy", func() {
+			proxyURL, err := url.Parse("http://proxy.internal:3128")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = client.NewClientWithOptions(client.WithUnixSocket(filepath.Join(dir, "sidecar.sock")), client.WithProxy(proxyURL, ""))
+			Expect(err).To(MatchError(client.ErrInvalidOptions))
+			Expect(err).To(MatchError(ContainSubstring("WithProxy cannot be combined with WithUnixSocket")))
+		})
+	})
+
+	Context("Test custom transport", func() {
+		It("should reject options it cannot apply", func() {
+			transport := client.RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+				return nil, errors.New("not sent")
+			})
+			for _, opt := range []client.Option{
+				client.WithCABundle(filepath.Join(dir, "ca.pem")),
+				client.WithClientCertificate(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")),
+				client.WithMinTLSVersion(tls.VersionTLS13),
+				client.WithUnixSocket(filepath.Join(dir, "sidecar.sock")),
+			} {
+				_, err := client.NewClientWithOptions(client.WithTransport(transport), opt)
+				Expect(err).To(MatchError(client.ErrInvalidOptions))
+				Expect(err).To(MatchError(ContainSubstring("require WithTransport to be an *http.Transport")))
+			}
+		})
+
+		It("should apply the options to an *http.Transport", func() {
+			transport := &http.Transport{}
+			_, err := client.NewClientWithOptions(client.WithTransport(transport), client.WithMinTLSVersion(tls.VersionTLS13))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should panic in NewClient when the options cannot be applied", func() {
+			transport := client.RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+				return nil, errors.New("not sent")
+			})
+			Expect(func() {
+				client.NewClient(client.WithTransport(transport), client.WithMinTLSVersion(tls.VersionTLS13))
+			}).To(PanicWith(MatchError(client.ErrInvalidOptions)))
+		})
+	})
+})
+
+// selfSignedCertificate returns a self signed certificate for localhost with the given common name
+func selfSignedCertificate(commonName string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func writeKeyPair(certFile, keyFile string, cert tls.Certificate) {
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600)).To(Succeed())
+	Expect(os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600)).To(Succeed())
+}
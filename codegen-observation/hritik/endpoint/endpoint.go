@@ -11,6 +11,7 @@ import (
 	"github.com/nutanix-core/nai-api/common/logger"
 	"github.com/nutanix-core/nai-api/common/response"
 	"github.com/nutanix-core/nai-api/iep/constants"
+	"github.com/nutanix-core/nai-api/iep/internal/client"
 	"github.com/nutanix-core/nai-api/iep/internal/dto"
 	auth "github.com/nutanix-core/nai-api/iep/internal/middleware"
 	"github.com/nutanix-core/nai-api/iep/internal/service"
@@ -24,11 +25,12 @@ type EndpointController struct {
 	validator       *validator.Validate
 	endpointService service.IEndpointService
 	authMiddleware  auth.IAuthenticationMiddleware
+	healthHistory   client.IHealthHistory
 }
 
 // NewEndpointController creates and initiates the route
-func NewEndpointController(v1Route *gin.RouterGroup, logger logger.Logger, validator *validator.Validate, endpointService service.IEndpointService, authMiddleware auth.IAuthenticationMiddleware) *EndpointController {
-	controller := &EndpointController{v1Route: v1Route, logger: logger, validator: validator, endpointService: endpointService, authMiddleware: authMiddleware}
+func NewEndpointController(v1Route *gin.RouterGroup, logger logger.Logger, validator *validator.Validate, endpointService service.IEndpointService, authMiddleware auth.IAuthenticationMiddleware, healthHistory client.IHealthHistory) *EndpointController {
+	controller := &EndpointController{v1Route: v1Route, logger: logger, validator: validator, endpointService: endpointService, authMiddleware: authMiddleware, healthHistory: healthHistory}
 	controller.route()
 	return controller
 }
@@ -39,6 +41,7 @@ func (ec *EndpointController) route() {
 	route.POST("", ec.Create)
 	route.GET("", ec.List)
 	route.GET("/:endpoint_id", ec.GetByID)
+	route.GET("/:endpoint_id/health", ec.GetHealth)
 	route.GET("/apikeys/:endpoint_id", ec.ListAPIKeys)
 	route.DELETE("/:endpoint_id", ec.Delete)
 	// route.PATCH("/:endpoint_id", ec.Update) Update Endpoint is currently parked and not supported
@@ -112,6 +115,40 @@ func (ec *EndpointController) GetByID(c *gin.Context) {
 	response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ec.logger, SuccMsg: succMsg, Err: err, Data: view.GetEndpoint(endpoint)})
 }
 
+// GetHealth godoc
+//
+//	@Summary		getHealth
+//	@Description	get the current health of an endpoint with its transition history and uptime
+//	@Tags			endpoints
+//	@Accept			json
+//	@Produce		json
+//	@Param			endpoint_id		path		string																true	"endpoint id"
+//	@Param			Authorization	header		string																true	"access token sent via headers"
+//	@Success		200				{object}	response.HTTPSuccessWithDataResponseModel{data=client.HealthReport}	"success response"
+//	@Failure		401				{object}	response.HTTPFailureResponseModel									"unauthorized response"
+//	@Failure		403				{object}	response.HTTPFailureResponseModel									"forbidden response"
+//	@Failure		404				{object}	response.HTTPFailureResponseModel									"not found response"
+//	@Failure		500				{object}	response.HTTPFailureResponseModel									"internal server error response"
+//	@Router			/v1/endpoints/{endpoint_id}/health [get]
+func (ec *EndpointController) GetHealth(c *gin.Context) {
+	endpointID := c.Param("endpoint_id")
+	succMsg := "Endpoint health fetched successfully"
+	errMsg := "Failed to get endpoint health"
+	userContext := getUserContext(c)
+	// fetching the endpoint checks that it exists and that the user can access it
+	if _, err := ec.endpointService.GetByID(userContext, endpointID, dto.ExpansionItems{}); err != nil {
+		response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ec.logger, Err: err})
+		return
+	}
+	report, reportErr := ec.healthHistory.Report(endpointID)
+	if reportErr != nil {
+		err := &e.Error{Type: e.GenericError, InternalErr: reportErr, Msg: errMsg}
+		response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ec.logger, Err: err})
+		return
+	}
+	response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ec.logger, SuccMsg: succMsg, Data: report})
+}
+
 // Delete godoc
 //
 //	@Summary		delete
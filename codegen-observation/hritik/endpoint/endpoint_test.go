@@ -1,6 +1,8 @@
 package v1_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -14,6 +16,7 @@ import (
 	v1 "github.com/nutanix-core/nai-api/iep/api/v1"
 	"github.com/nutanix-core/nai-api/iep/constants"
 	"github.com/nutanix-core/nai-api/iep/constants/enum"
+	"github.com/nutanix-core/nai-api/iep/internal/client"
 	dto "github.com/nutanix-core/nai-api/iep/internal/dto"
 	auth "github.com/nutanix-core/nai-api/iep/internal/middleware"
 	"github.com/nutanix-core/nai-api/iep/internal/model"
@@ -71,6 +74,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 		mockCtrl            *gomock.Controller
 		mockEndpointService *mock_service.MockIEndpointService
 		mockAuthService     *mock_middleware.MockIAuthenticationMiddleware
+		healthHistory       client.IHealthHistory
 		logger              = logger.NewZAPLogger()
 		endpointValidator   = validator.NewValidator(logger)
 		userIDKey           = "userID"
@@ -121,6 +125,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 		mockCtrl = gomock.NewController(GinkgoT())
 		mockEndpointService = mock_service.NewMockIEndpointService(mockCtrl)
 		mockAuthService = mock_middleware.NewMockIAuthenticationMiddleware(mockCtrl)
+		healthHistory = client.NewHealthHistory(client.NewMemoryHealthHistoryStore(), client.HealthHistoryConfig{Staleness: time.Hour})
 		validateAccessTokenHandler := func(c *gin.Context) {
 			c.Next()
 		}
@@ -134,7 +139,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 			validContext.Set(userIDKey, userID)
 			validContext.Set(roleKey, role)
 			mockEndpointService.EXPECT().Create(userContext, getCreateEndpointRequest()).Return("123", nil).Times(1)
-			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 			testEndpointController.Create(validContext)
 			Expect(validContext.IsAborted()).Should(BeFalse())
 			Expect(validContext.Writer.Status()).Should(Equal(http.StatusOK))
@@ -145,7 +150,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 			validContext.Set(userIDKey, userID)
 			validContext.Set(roleKey, role)
 			mockEndpointService.EXPECT().Create(userContext, getCreateEndpointRequestForCPU()).Return("123", nil).Times(1)
-			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 			testEndpointController.Create(validContext)
 			Expect(validContext.IsAborted()).Should(BeFalse())
 			Expect(validContext.Writer.Status()).Should(Equal(http.StatusOK))
@@ -165,7 +170,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 			}`
 
 			validContext, router := getContext("v1/endpoints", createNimCPURequest, "POST")
-			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 			testEndpointController.Create(validContext)
 			Expect(validContext.IsAborted()).Should(BeTrue())
 			Expect(validContext.Writer.Status()).Should(Equal(http.StatusBadRequest))
@@ -186,7 +191,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 			}`
 
 			validContext, router := getContext("v1/endpoints", createNimCPURequest, "POST")
-			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 			testEndpointController.Create(validContext)
 			Expect(validContext.IsAborted()).Should(BeTrue())
 			Expect(validContext.Writer.Status()).Should(Equal(http.StatusBadRequest))
@@ -197,7 +202,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 			validContext.Set(userIDKey, userID)
 			validContext.Set(roleKey, role)
 			mockEndpointService.EXPECT().Create(userContext, getCreateEndpointRequest()).Return("", &e.Error{Type: e.GenericError, Msg: "failed to create endpoint"}).Times(1)
-			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 			testEndpointController.Create(validContext)
 			Expect(validContext.IsAborted()).Should(BeTrue())
 			Expect(validContext.Writer.Status()).Should(Equal(http.StatusInternalServerError))
@@ -207,7 +212,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 			validContext, router := getContext("v1/endpoints", wrongEndpointRequest, "POST")
 			validContext.Set(userIDKey, userID)
 			validContext.Set(roleKey, role)
-			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 			testEndpointController.Create(validContext)
 			Expect(validContext.Writer.Status()).Should(Equal(http.StatusBadRequest))
 		})
@@ -216,7 +221,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 			validContext, router := getContext("v1/endpoints", "{}", "POST")
 			validContext.Set(userIDKey, userID)
 			validContext.Set(roleKey, role)
-			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 			testEndpointController.Create(validContext)
 			Expect(validContext.Writer.Status()).Should(Equal(http.StatusBadRequest))
 		})
@@ -230,7 +235,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 			validContext.Set(roleKey, role)
 			validContext.Params = append(validContext.Params, gin.Param{Key: "endpoint_id", Value: endpointID})
 			mockEndpointService.EXPECT().GetByID(userContext, endpointID, dto.ExpansionItems{constants.ActualInstances: true}).Return(dto.GetEndpointResponse{ID: endpointID}, nil).Times(1)
-			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 			testEndpointController.GetByID(validContext)
 			Expect(validContext.IsAborted()).Should(BeFalse())
 			Expect(validContext.Writer.Status()).Should(Equal(http.StatusOK))
@@ -242,7 +247,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 			validContext.Set(roleKey, role)
 			validContext.Params = append(validContext.Params, gin.Param{Key: "endpoint_id", Value: endpointID})
 			mockEndpointService.EXPECT().GetByID(userContext, endpointID, dto.ExpansionItems{constants.Status: true, constants.ActualInstances: true}).Return(dto.GetEndpointResponse{ID: endpointID}, nil).Times(1)
-			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 			testEndpointController.GetByID(validContext)
 			Expect(validContext.IsAborted()).Should(BeFalse())
 			Expect(validContext.Writer.Status()).Should(Equal(http.StatusOK))
@@ -253,7 +258,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 			validContext.Set(userIDKey, userID)
 			validContext.Set(roleKey, role)
 			validContext.Params = append(validContext.Params, gin.Param{Key: "endpoint_id", Value: endpointID})
-			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 			testEndpointController.GetByID(validContext)
 			Expect(validContext.IsAborted()).Should(BeTrue())
 			Expect(validContext.Writer.Status()).Should(Equal(http.StatusBadRequest))
@@ -265,13 +270,84 @@ var _ = Describe("Test Endpoint Controller", func() {
 			validContext.Set(roleKey, role)
 			validContext.Params = append(validContext.Params, gin.Param{Key: "endpoint_id", Value: endpointID})
 			mockEndpointService.EXPECT().GetByID(userContext, endpointID, dto.ExpansionItems{constants.ActualInstances: true}).Return(dto.GetEndpointResponse{}, &e.Error{Type: e.DBError, Msg: "failed to get endpoint"}).Times(1)
-			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 			testEndpointController.GetByID(validContext)
 			Expect(validContext.IsAborted()).Should(BeTrue())
 			Expect(validContext.Writer.Status()).Should(Equal(http.StatusInternalServerError))
 		})
 	})
 
+	Context("Test Get Endpoint Health Request", func() {
+		endpointID := uuid.NewString()
+		It("Get Endpoint Health Successful", func() {
+			validContext, router := getContext("v1/endpoints", "", "GET")
+			validContext.Set(userIDKey, userID)
+			validContext.Set(roleKey, role)
+			validContext.Params = append(validContext.Params, gin.Param{Key: "endpoint_id", Value: endpointID})
+			Expect(healthHistory.Record(endpointID, client.HealthObservation{Status: enum.CriticalStatusCode, Timestamp: time.Now().Add(-time.Hour)})).To(Succeed())
+			Expect(healthHistory.Record(endpointID, client.HealthObservation{Status: enum.HealthyStatusCode, Timestamp: time.Now().Add(-time.Minute)})).To(Succeed())
+			mockEndpointService.EXPECT().GetByID(userContext, endpointID, dto.ExpansionItems{}).Return(dto.GetEndpointResponse{ID: endpointID}, nil).Times(1)
+			writer := &healthResponseWriter{ResponseWriter: validContext.Writer}
+			validContext.Writer = writer
+			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
+			testEndpointController.GetHealth(validContext)
+			Expect(validContext.IsAborted()).Should(BeFalse())
+			Expect(validContext.Writer.Status()).Should(Equal(http.StatusOK))
+			report := writer.healthReport()
+			Expect(report.EndpointID).To(Equal(endpointID))
+			Expect(report.Status).To(Equal(enum.HealthyStatusCode))
+			Expect(report.LastChecked).ToNot(BeNil())
+			Expect(report.Transitions).To(HaveLen(1))
+			Expect(report.Transitions[0].From).To(Equal(enum.CriticalStatusCode))
+			Expect(report.Transitions[0].To).To(Equal(enum.HealthyStatusCode))
+			Expect(report.Uptime).To(HaveLen(3))
+			for i, window := range []string{"1h", "24h", "7d"} {
+				// critical for the first 59 minutes of the last hour, healthy since
+				Expect(report.Uptime[i].Window).To(Equal(window))
+				Expect(report.Uptime[i].ObservedSeconds).To(BeNumerically("~", 3600, 1))
+				Expect(report.Uptime[i].Percent).ToNot(BeNil())
+				Expect(*report.Uptime[i].Percent).To(BeNumerically("~", 100.0/60, 0.1))
+				Expect(report.Uptime[i].MeetsSLO).To(HaveValue(BeFalse()))
+			}
+		})
+
+		It("Get Endpoint Health Successful for an endpoint never checked", func() {
+			validContext, router := getContext("v1/endpoints", "", "GET")
+			validContext.Set(userIDKey, userID)
+			validContext.Set(roleKey, role)
+			validContext.Params = append(validContext.Params, gin.Param{Key: "endpoint_id", Value: endpointID})
+			mockEndpointService.EXPECT().GetByID(userContext, endpointID, dto.ExpansionItems{}).Return(dto.GetEndpointResponse{ID: endpointID}, nil).Times(1)
+			writer := &healthResponseWriter{ResponseWriter: validContext.Writer}
+			validContext.Writer = writer
+			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
+			testEndpointController.GetHealth(validContext)
+			Expect(validContext.IsAborted()).Should(BeFalse())
+			Expect(validContext.Writer.Status()).Should(Equal(http.StatusOK))
+			report := writer.healthReport()
+			Expect(report.Status).To(Equal(enum.UnknownStatusCode))
+			Expect(report.LastChecked).To(BeNil())
+			Expect(report.Transitions).To(BeEmpty())
+			Expect(report.Uptime).To(HaveLen(3))
+			for _, uptime := range report.Uptime {
+				Expect(uptime.ObservedSeconds).To(BeZero())
+				Expect(uptime.Percent).To(BeNil())
+				Expect(uptime.MeetsSLO).To(BeNil())
+			}
+		})
+
+		It("Get Endpoint Health unsuccessful: Get Service gives error", func() {
+			validContext, router := getContext("v1/endpoints", "", "GET")
+			validContext.Set(userIDKey, userID)
+			validContext.Set(roleKey, role)
+			validContext.Params = append(validContext.Params, gin.Param{Key: "endpoint_id", Value: endpointID})
+			mockEndpointService.EXPECT().GetByID(userContext, endpointID, dto.ExpansionItems{}).Return(dto.GetEndpointResponse{}, &e.Error{Type: e.DBError, Msg: "failed to get endpoint"}).Times(1)
+			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
+			testEndpointController.GetHealth(validContext)
+			Expect(validContext.IsAborted()).Should(BeTrue())
+			Expect(validContext.Writer.Status()).Should(Equal(http.StatusInternalServerError))
+		})
+	})
+
 	Context("Test Delete Endpoint Request", func() {
 		endpointID := uuid.NewString()
 		It("Delete Endpoint Successful", func() {
@@ -280,7 +356,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 			validContext.Set(userIDKey, userID)
 			validContext.Set(roleKey, role)
 			mockEndpointService.EXPECT().Delete(userContext, endpointID, true).Return(nil).Times(1)
-			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 			testEndpointController.Delete(validContext)
 			Expect(validContext.IsAborted()).Should(BeFalse())
 			Expect(validContext.Writer.Status()).Should(Equal(http.StatusOK))
@@ -292,7 +368,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 			validContext.Set(userIDKey, userID)
 			validContext.Set(roleKey, role)
 			mockEndpointService.EXPECT().Delete(userContext, endpointID, false).Return(&e.Error{Type: e.DBError, Msg: "failed to delete endpoint"}).Times(1)
-			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 			testEndpointController.Delete(validContext)
 			Expect(validContext.IsAborted()).Should(BeTrue())
 			Expect(validContext.Writer.Status()).Should(Equal(http.StatusInternalServerError))
@@ -301,7 +377,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 		It("Delete Endpoint unsuccessful: force delete parsing error", func() {
 			validContext, router := getContext("v1/endpoints?force=random", "", "DELETE")
 			validContext.Params = append(validContext.Params, gin.Param{Key: "endpoint_id", Value: endpointID})
-			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 			testEndpointController.Delete(validContext)
 			Expect(validContext.IsAborted()).Should(BeTrue())
 			Expect(validContext.Writer.Status()).Should(Equal(http.StatusBadRequest))
@@ -330,7 +406,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 			validContext.Set(userIDKey, userID)
 			validContext.Set(roleKey, role)
 			mockEndpointService.EXPECT().List(userContext, dto.ExpansionItems{}, gomock.Cond(listOptionsComparator)).Return(expectedResult, int64(2), nil).Times(1)
-			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 			testEndpointController.List(validContext)
 			Expect(validContext.IsAborted()).Should(BeFalse())
 			Expect(validContext.Writer.Status()).Should(Equal(http.StatusOK))
@@ -338,7 +414,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 
 		It("List Endpoint unsuccessful: error parsing limit", func() {
 			validContext, router := getContext("v1/endpoints?limit=a", "", "GET")
-			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 			testEndpointController.List(validContext)
 			Expect(validContext.IsAborted()).Should(BeTrue())
 			Expect(validContext.Writer.Status()).Should(Equal(http.StatusBadRequest))
@@ -346,7 +422,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 
 		It("List Endpoint unsuccessful: unsupported query param", func() {
 			validContext, router := getContext("v1/endpoints?name=a", "", "GET")
-			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 			testEndpointController.List(validContext)
 			Expect(validContext.IsAborted()).Should(BeTrue())
 			Expect(validContext.Writer.Status()).Should(Equal(http.StatusBadRequest))
@@ -357,7 +433,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 			validContext.Set(userIDKey, userID)
 			validContext.Set(roleKey, role)
 			mockEndpointService.EXPECT().List(userContext, dto.ExpansionItems{}, gomock.Cond(listOptionsComparator)).Return([]dto.GetEndpointResponse{}, int64(0), &e.Error{Type: e.DBError, Msg: "failed to list endpoints"}).Times(1)
-			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 			testEndpointController.List(validContext)
 			Expect(validContext.IsAborted()).Should(BeTrue())
 			Expect(validContext.Writer.Status()).Should(Equal(http.StatusInternalServerError))
@@ -367,7 +443,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 			validContext, router := getContext("v1/endpoints?owner_id=invalid_owner", "", "GET")
 			validContext.Set(userIDKey, userID)
 			validContext.Set(roleKey, role)
-			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 			testEndpointController.List(validContext)
 			Expect(validContext.IsAborted()).Should(BeTrue())
 			Expect(validContext.Writer.Status()).Should(Equal(http.StatusForbidden))
@@ -394,7 +470,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 			validContext.Set(userIDKey, userID)
 			validContext.Set(roleKey, role)
 			mockEndpointService.EXPECT().ListAPIKeysByEndpoint(userContext, endpointID).Return([]model.APIKey{validAPIKey}, nil).Times(1)
-			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 			testEndpointController.ListAPIKeys(validContext)
 			Expect(validContext.IsAborted()).Should(BeFalse())
 			Expect(validContext.Writer.Status()).Should(Equal(http.StatusOK))
@@ -406,7 +482,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 			validContext.Set(userIDKey, userID)
 			validContext.Set(roleKey, role)
 			mockEndpointService.EXPECT().ListAPIKeysByEndpoint(userContext, endpointID).Return([]model.APIKey{}, &e.Error{Type: e.DBError, Msg: "failed to list api keys"}).Times(1)
-			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 			testEndpointController.ListAPIKeys(validContext)
 			Expect(validContext.IsAborted()).Should(BeTrue())
 			Expect(validContext.Writer.Status()).Should(Equal(http.StatusInternalServerError))
@@ -428,7 +504,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 	// 		validContext.Set(roleKey, role)
 	// 		validContext.Params = append(validContext.Params, gin.Param{Key: "endpoint_id", Value: endpointID})
 	// 		mockEndpointService.EXPECT().Update(userContext, endpointID, getUpdateEndpoint()).Return(nil).Times(1)
-	// 		testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+	// 		testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 	// 		testEndpointController.Update(validContext)
 	// 		Expect(validContext.IsAborted()).Should(BeFalse())
 	// 		Expect(validContext.Writer.Status()).Should(Equal(http.StatusOK))
@@ -440,7 +516,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 	// 		validContext.Set(roleKey, role)
 	// 		validContext.Params = append(validContext.Params, gin.Param{Key: "endpoint_id", Value: endpointID})
 	// 		mockEndpointService.EXPECT().Update(userContext, endpointID, getUpdateEndpoint()).Return(&e.Error{Type: e.DBError, Msg: "failed to update Endpoint"}).Times(1)
-	// 		testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+	// 		testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 	// 		testEndpointController.Update(validContext)
 	// 		Expect(validContext.IsAborted()).Should(BeTrue())
 	// 		Expect(validContext.Writer.Status()).Should(Equal(http.StatusInternalServerError))
@@ -451,7 +527,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 	// 		validContext.Set(userIDKey, userID)
 	// 		validContext.Set(roleKey, role)
 	// 		validContext.Params = append(validContext.Params, gin.Param{Key: "endpoint_id", Value: endpointID})
-	// 		testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+	// 		testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 	// 		testEndpointController.Update(validContext)
 	// 		Expect(validContext.IsAborted()).Should(BeTrue())
 	// 		Expect(validContext.Writer.Status()).Should(Equal(http.StatusBadRequest))
@@ -467,7 +543,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 	// 		validContext.Set(userIDKey, userID)
 	// 		validContext.Set(roleKey, role)
 	// 		validContext.Params = append(validContext.Params, gin.Param{Key: "endpoint_id", Value: endpointID})
-	// 		testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+	// 		testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 	// 		testEndpointController.Update(validContext)
 	// 		Expect(validContext.IsAborted()).Should(BeTrue())
 	// 		Expect(validContext.Writer.Status()).Should(Equal(http.StatusBadRequest))
@@ -483,7 +559,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 			u.Add("endpoint_name", validEndpointName)
 			validContext.Request.URL.RawQuery = u.Encode()
 			mockEndpointService.EXPECT().ValidateEndpointName(validEndpointName).Return(nil).Times(1)
-			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 			testEndpointController.ValidateEndpoint(validContext)
 			Expect(validContext.IsAborted()).Should(BeFalse())
 			Expect(validContext.Writer.Status()).Should(Equal(http.StatusOK))
@@ -496,7 +572,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 			u := url.Values{}
 			u.Add("endpoint_name", invalidEndpointName)
 			validContext.Request.URL.RawQuery = u.Encode()
-			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 			testEndpointController.ValidateEndpoint(validContext)
 			Expect(validContext.IsAborted()).Should(BeTrue())
 			Expect(validContext.Writer.Status()).Should(Equal(http.StatusBadRequest))
@@ -510,7 +586,7 @@ var _ = Describe("Test Endpoint Controller", func() {
 			u.Add("endpoint_name", invalidEndpointName)
 			validContext.Request.URL.RawQuery = u.Encode()
 			mockEndpointService.EXPECT().ValidateEndpointName(invalidEndpointName).Return(&e.Error{Type: e.ValidationError, Msg: fmt.Sprintf("invalid endpoint name: wrong format of string for name %s", invalidEndpointName)}).Times(1)
-			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService)
+			testEndpointController := v1.NewEndpointController(router.Group("/v1"), logger, endpointValidator, mockEndpointService, mockAuthService, healthHistory)
 			testEndpointController.ValidateEndpoint(validContext)
 			Expect(validContext.IsAborted()).Should(BeTrue())
 			Expect(validContext.Writer.Status()).Should(Equal(http.StatusBadRequest))
@@ -527,3 +603,27 @@ var _ = Describe("Test Endpoint Controller", func() {
 // 		MaxInstances: &instances,
 // 	}
 // }
+
+// healthResponseWriter keeps a copy of the response body to decode the health report from
+type healthResponseWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *healthResponseWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *healthResponseWriter) WriteString(data string) (int, error) {
+	w.body.WriteString(data)
+	return w.ResponseWriter.WriteString(data)
+}
+
+func (w *healthResponseWriter) healthReport() client.HealthReport {
+	var body struct {
+		Data client.HealthReport `json:"data"`
+	}
+	Expect(json.Unmarshal(w.body.Bytes(), &body)).To(Succeed())
+	return body.Data
+}
@@ -0,0 +1,11 @@
+package enum
+
+const (
+	// KServeV2Engine serves models with the KServe v2 inference protocol, such as Triton
+	KServeV2Engine Engine = "kserve-v2"
+)
+
+const (
+	// DegradedStatusCode is the status of endpoints that serve requests but answer their health checks too slowly
+	DegradedStatusCode ServiceHealthStatusCode = "Degraded"
+)
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nutanix-core/nai-api/iep/constants/enum"
+)
+
+// healthFlight is a health check in flight, shared by the concurrent callers checking the same url
+type healthFlight struct {
+	done   chan struct{}
+	result HealthCheckResult
+	// interrupted is set when the context of the caller making the check was done before the check completed
+	interrupted bool
+}
+
+// cachedHealth is a recent health check result
+type cachedHealth struct {
+	result    HealthCheckResult
+	checkedAt time.Time
+}
+
+// WithCacheTTL keeps health check results for ttl, concurrent checks of a url are shared whether or not
+// results are cached. Results are not cached by default.
+func WithCacheTTL(ttl time.Duration) HealthClientOption {
+	return func(ic *healthClient) {
+		ic.cacheTTL = ttl
+	}
+}
+
+// WithHealthClock sets the clock the cache ttl is measured with, the real clock by default
+func WithHealthClock(clock Clock) HealthClientOption {
+	return func(ic *healthClient) {
+		ic.clock = clock
+	}
+}
+
+// WithForceRefresh checks every url of the batch again even when a result is cached.
+// Urls already being checked by another caller are not checked twice, their check is recent enough.
+func WithForceRefresh() BatchOption {
+	return func(o *batchOptions) {
+		o.forceRefresh = true
+	}
+}
+
+// sharedCheck returns the cached result of a url, joins the check of the url in flight or checks it.
+// Callers joining a check give up when their ctx is done, and check again when the caller they joined gave up.
+func (ic *healthClient) sharedCheck(ctx context.Context, healthCheckURL string, forceRefresh bool) HealthCheckResult {
+	for {
+		ic.mu.Lock()
+		if cached, ok := ic.cache[healthCheckURL]; ok && !forceRefresh && ic.clock.Now().Sub(cached.checkedAt) < ic.cacheTTL {
+			ic.mu.Unlock()
+			return cached.result
+		}
+		if flight, ok := ic.flights[healthCheckURL]; ok {
+			ic.mu.Unlock()
+			select {
+			case <-flight.done:
+			case <-ctx.Done():
+				return HealthCheckResult{Status: enum.UnknownStatusCode, Detail: fmt.Sprintf("check interrupted: %v", ctx.Err())}
+			}
+			if flight.interrupted && ctx.Err() == nil {
+				continue
+			}
+			return flight.result
+		}
+		flight := &healthFlight{done: make(chan struct{})}
+		ic.flights[healthCheckURL] = flight
+		ic.mu.Unlock()
+
+		flight.result = ic.checkHealth(ctx, healthCheckURL, HealthCheckOptions{}.withDefaults(), true)
+		flight.interrupted = ctx.Err() != nil && !isAvailable(flight.result.Status)
+		ic.mu.Lock()
+		delete(ic.flights, healthCheckURL)
+		if ic.cacheTTL > 0 && !flight.interrupted {
+			ic.storeResult(healthCheckURL, flight.result)
+		}
+		ic.mu.Unlock()
+		close(flight.done)
+		return flight.result
+	}
+}
+
+// storeResult caches the result of a url and evicts the expired results, mu must be held
+func (ic *healthClient) storeResult(healthCheckURL string, result HealthCheckResult) {
+	now := ic.clock.Now()
+	for cachedURL, cached := range ic.cache {
+		if now.Sub(cached.checkedAt) >= ic.cacheTTL {
+			delete(ic.cache, cachedURL)
+		}
+	}
+	ic.cache[healthCheckURL] = cachedHealth{result: result, checkedAt: now}
+}
@@ -0,0 +1,135 @@
+package client_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/nutanix-core/nai-api/iep/constants/enum"
+	"github.com/nutanix-core/nai-api/iep/internal/client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Test coalesced health checks", func() {
+
+	var (
+		testServer *httptest.Server
+		mu         sync.Mutex
+		hits       int
+		blocking   bool
+		release    chan struct{}
+		clock      *fakeClock
+		ctx        = context.Background()
+	)
+
+	requests := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return hits
+	}
+
+	BeforeEach(func() {
+		hits, blocking = 0, false
+		release = make(chan struct{})
+		clock = newFakeClock()
+		testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			hits++
+			block := blocking
+			mu.Unlock()
+			if block {
+				select {
+				case <-release:
+				case <-r.Context().Done():
+					return
+				}
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+	})
+
+	AfterEach(func() {
+		close(release)
+		testServer.Close()
+	})
+
+	It("should share a single check between concurrent callers", func() {
+		blocking = true
+		healthClient := client.NewHealthClient(client.NewClient())
+		statuses := make(chan enum.ServiceHealthStatusCode, 5)
+		for i := 0; i < 5; i++ {
+			go func() {
+				statuses <- healthClient.CheckHealth(testServer.URL)
+			}()
+		}
+		Eventually(requests).Should(Equal(1))
+		Consistently(requests, 100*time.Millisecond).Should(Equal(1))
+		release <- struct{}{}
+		for i := 0; i < 5; i++ {
+			Eventually(statuses).Should(Receive(Equal(enum.HealthyStatusCode)))
+		}
+		Expect(requests()).To(Equal(1))
+
+		// without cache the next call checks again
+		mu.Lock()
+		blocking = false
+		mu.Unlock()
+		healthClient.CheckHealth(testServer.URL)
+		Expect(requests()).To(Equal(2))
+	})
+
+	It("should return cached results until the ttl elapses", func() {
+		healthClient := client.NewHealthClient(client.NewClient(), client.WithCacheTTL(10*time.Second), client.WithHealthClock(clock))
+		Expect(healthClient.CheckHealth(testServer.URL)).To(Equal(enum.HealthyStatusCode))
+		clock.Advance(9 * time.Second)
+		results := healthClient.CheckHealthBatch(ctx, []string{testServer.URL})
+		Expect(results[testServer.URL].Status).To(Equal(enum.HealthyStatusCode))
+		Expect(requests()).To(Equal(1))
+
+		clock.Advance(time.Second)
+		healthClient.CheckHealth(testServer.URL)
+		Expect(requests()).To(Equal(2))
+	})
+
+	It("should bypass the cache on force refresh", func() {
+		healthClient := client.NewHealthClient(client.NewClient(), client.WithCacheTTL(time.Minute), client.WithHealthClock(clock))
+		healthClient.CheckHealth(testServer.URL)
+		healthClient.CheckHealthBatch(ctx, []string{testServer.URL}, client.WithForceRefresh())
+		Expect(requests()).To(Equal(2))
+
+		// the refreshed result is cached again
+		clock.Advance(30 * time.Second)
+		healthClient.CheckHealth(testServer.URL)
+		Expect(requests()).To(Equal(2))
+	})
+
+	It("should check again for callers whose check was interrupted by another caller", func() {
+		blocking = true
+		healthClient := client.NewHealthClient(client.NewClient(), client.WithCacheTTL(time.Minute), client.WithHealthClock(clock))
+		batchCtx, cancel := context.WithCancel(ctx)
+		results := make(chan map[string]client.HealthCheckResult, 1)
+		go func() {
+			results <- healthClient.CheckHealthBatch(batchCtx, []string{testServer.URL})
+		}()
+		Eventually(requests).Should(Equal(1))
+
+		statuses := make(chan enum.ServiceHealthStatusCode, 1)
+		go func() {
+			statuses <- healthClient.CheckHealth(testServer.URL)
+		}()
+		Consistently(statuses, 100*time.Millisecond).ShouldNot(Receive())
+		mu.Lock()
+		blocking = false
+		mu.Unlock()
+		cancel()
+
+		var batch map[string]client.HealthCheckResult
+		Eventually(results).Should(Receive(&batch))
+		Expect(batch[testServer.URL].Status).To(Equal(enum.UnknownStatusCode))
+		Eventually(statuses).Should(Receive(Equal(enum.HealthyStatusCode)))
+		Expect(requests()).To(Equal(2))
+	})
+})
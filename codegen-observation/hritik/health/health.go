@@ -2,73 +2,458 @@ package client
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"slices"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/nutanix-core/nai-api/iep/constants"
 	"github.com/nutanix-core/nai-api/iep/constants/enum"
 )
 
+const (
+	// DefaultHealthCheckWorkers is the number of urls checked concurrently by CheckHealthBatch
+	DefaultHealthCheckWorkers = 10
+	// DefaultHealthCheckURLTimeout bounds the attempts made for a single url by CheckHealthBatch
+	DefaultHealthCheckURLTimeout = time.Minute
+	// DefaultLatencyWindow is the number of latest successful checks of a url the p95 latency is computed over
+	DefaultLatencyWindow = 20
+	// DefaultLatencyMinSamples is the number of successful checks of a url needed before it can be degraded
+	DefaultLatencyMinSamples = 5
+	// DefaultLatencyThreshold is the p95 latency above which endpoints of engines without threshold are degraded
+	DefaultLatencyThreshold = 2 * time.Second
+)
+
+// DefaultLatencyThresholds returns the p95 latencies above which the endpoints of an engine are degraded,
+// the health routes of every known engine answer without touching the model and should be fast
+func DefaultLatencyThresholds() map[enum.Engine]time.Duration {
+	return map[enum.Engine]time.Duration{
+		enum.TGIEngine:      time.Second,
+		enum.VLLMEngine:     time.Second,
+		enum.NIMEngine:      time.Second,
+		enum.KServeV2Engine: time.Second,
+	}
+}
+
+// isAvailable tells whether an endpoint with the given status serves requests, degraded endpoints are slow but serve
+func isAvailable(status enum.ServiceHealthStatusCode) bool {
+	return status == enum.HealthyStatusCode || status == enum.DegradedStatusCode
+}
+
 // IHealthClient interface contains methods to fetch inference endpoint health
 type IHealthClient interface {
 	CheckHealth(healthCheckURL string) enum.ServiceHealthStatusCode
+	// CheckHealthWithOptions checks a single url until ctx is done, see HealthCheckOptions
+	CheckHealthWithOptions(ctx context.Context, healthCheckURL string, options HealthCheckOptions) HealthCheckResult
+	// CheckHealthBatch checks every url concurrently until ctx is done, see BatchOption
+	CheckHealthBatch(ctx context.Context, healthCheckURLs []string, opts ...BatchOption) map[string]HealthCheckResult
+	// Probe probes the readiness of the engine serving at baseURL, see ProbeProfileFor
+	Probe(ctx context.Context, baseURL string, profile ProbeProfile) ProbeResult
+}
+
+// HealthCheckResult is the outcome of the health check of a url
+type HealthCheckResult struct {
+	Status enum.ServiceHealthStatusCode
+	// Detail explains a status other than healthy, such as the error of the last attempt
+	Detail string
+	// Attempts is the number of attempts made, zero when the url was not checked
+	Attempts int
+	// Duration is the time spent checking the url
+	Duration time.Duration
+	// Latency is the response time of the last attempt, zero when no response was received
+	Latency time.Duration
+	// LatencyP95 is the p95 response time of the latest successful checks of the url made with the default options,
+	// zero before the first one
+	LatencyP95 time.Duration
+	// LatencySamples is the number of successful checks LatencyP95 is computed over
+	LatencySamples int
+}
+
+// HealthCheckOptions configures a single CheckHealthWithOptions call, the zero value checks like CheckHealth.
+// Checks with the default options of every field but ForceRefresh are shared with the concurrent callers
+// and cached, the others are made for the caller alone. Only the latency of the shared checks is recorded
+// for the p95 latency of the url, a check with other options may measure something else entirely.
+type HealthCheckOptions struct {
+	// Attempts is the maximum number of attempts, MaxServiceHealthAttempts by default
+	Attempts int
+	// Backoff is the delay before the second attempt, ServiceHealthCheckRetryDelay seconds by default
+	Backoff time.Duration
+	// BackoffMultiplier multiplies the delay after every attempt, the delay is constant below 1
+	BackoffMultiplier float64
+	// Timeout bounds every attempt, ClientTimeout seconds by default
+	Timeout time.Duration
+	// ExpectedStatusCodes are the status codes of a healthy endpoint, 200 only by default
+	ExpectedStatusCodes []int
+	// Headers are set on every request
+	Headers map[string]string
+	// BodyMatcher returns an error when the body of a response with an expected status code is not healthy
+	BodyMatcher func(body []byte) error
+	// ForceRefresh checks the url again even when a result is cached
+	ForceRefresh bool
+}
+
+// isDefault tells whether the options check like CheckHealth
+func (o HealthCheckOptions) isDefault() bool {
+	return o.Attempts <= 0 && o.Backoff <= 0 && o.BackoffMultiplier == 0 && o.Timeout <= 0 &&
+		len(o.ExpectedStatusCodes) == 0 && len(o.Headers) == 0 && o.BodyMatcher == nil
+}
+
+// withDefaults fills in the options left unset
+func (o HealthCheckOptions) withDefaults() HealthCheckOptions {
+	if o.Attempts <= 0 {
+		o.Attempts = constants.MaxServiceHealthAttempts
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = constants.ServiceHealthCheckRetryDelay * time.Second
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = constants.ClientTimeout * time.Second
+	}
+	if len(o.ExpectedStatusCodes) == 0 {
+		o.ExpectedStatusCodes = []int{http.StatusOK}
+	}
+	return o
+}
+
+// HealthCheckProgress is called by CheckHealthBatch as soon as a url is checked, done counts the
+// urls checked so far out of total. Calls are serialized.
+type HealthCheckProgress func(healthCheckURL string, result HealthCheckResult, done int, total int)
+
+// BatchOption configures a single CheckHealthBatch call
+type BatchOption func(*batchOptions)
+
+type batchOptions struct {
+	workers      int
+	urlTimeout   time.Duration
+	progress     HealthCheckProgress
+	forceRefresh bool
+}
+
+// WithBatchWorkers sets the number of urls checked concurrently, DefaultHealthCheckWorkers by default
+func WithBatchWorkers(workers int) BatchOption {
+	return func(o *batchOptions) {
+		o.workers = workers
+	}
+}
+
+// WithURLTimeout sets the deadline of the attempts made for a single url, DefaultHealthCheckURLTimeout by default
+func WithURLTimeout(timeout time.Duration) BatchOption {
+	return func(o *batchOptions) {
+		o.urlTimeout = timeout
+	}
+}
+
+// WithProgress sets a callback receiving the result of every url as soon as it is known
+func WithProgress(progress HealthCheckProgress) BatchOption {
+	return func(o *batchOptions) {
+		o.progress = progress
+	}
+}
+
+// LatencyConfig configures how the health client tells slow endpoints from healthy ones.
+// An endpoint answering its health checks is degraded while the p95 latency of its latest successful checks
+// exceeds the threshold of its engine.
+type LatencyConfig struct {
+	// Window is the number of latest successful checks of a url kept, DefaultLatencyWindow by default
+	Window int
+	// MinSamples is the number of successful checks needed to degrade a url, DefaultLatencyMinSamples by default
+	MinSamples int
+	// Thresholds are the p95 latencies of every engine, DefaultLatencyThresholds by default
+	Thresholds map[enum.Engine]time.Duration
+	// DefaultThreshold applies to the engines without threshold, DefaultLatencyThreshold by default
+	DefaultThreshold time.Duration
+	// Engine returns the engine serving a health check url, every url gets DefaultThreshold when nil
+	Engine func(healthCheckURL string) enum.Engine
+}
+
+// HealthClientOption configures a health client
+type HealthClientOption func(*healthClient)
+
+// WithLatencyConfig configures the latency classification of a health client
+func WithLatencyConfig(config LatencyConfig) HealthClientOption {
+	return func(ic *healthClient) {
+		ic.latency = config
+	}
 }
 
 // healthClient struct client executes health api calls on inference endpoints
 type healthClient struct {
-	client IClient
+	client       IClient
+	latency      LatencyConfig
+	cacheTTL     time.Duration
+	clock        Clock
+	probeProfile func(healthCheckURL string) (ProbeProfile, bool)
+
+	mu sync.Mutex
+	// latencies holds the latency of the latest successful checks of every url
+	latencies map[string]*latencyWindow
+	flights   map[string]*healthFlight
+	cache     map[string]cachedHealth
+	// loadingSince holds the time every loading probe url was first seen loading
+	loadingSince map[string]time.Time
+}
+
+// latencyWindow is a ring of the latest latencies of a url
+type latencyWindow struct {
+	samples []time.Duration
+	next    int
+}
+
+func (w *latencyWindow) add(latency time.Duration, size int) {
+	if len(w.samples) < size {
+		w.samples = append(w.samples, latency)
+		return
+	}
+	w.samples[w.next] = latency
+	w.next = (w.next + 1) % size
 }
 
 // NewHealthClient instantiates Inference client
-func NewHealthClient(client IClient) IHealthClient {
-	return &healthClient{
-		client: client,
+func NewHealthClient(client IClient, opts ...HealthClientOption) IHealthClient {
+	ic := &healthClient{
+		client:       client,
+		clock:        realClock{},
+		latencies:    map[string]*latencyWindow{},
+		flights:      map[string]*healthFlight{},
+		cache:        map[string]cachedHealth{},
+		loadingSince: map[string]time.Time{},
 	}
+	for _, opt := range opts {
+		opt(ic)
+	}
+	if ic.clock == nil {
+		ic.clock = realClock{}
+	}
+	if ic.latency.Window <= 0 {
+		ic.latency.Window = DefaultLatencyWindow
+	}
+	if ic.latency.MinSamples <= 0 {
+		ic.latency.MinSamples = DefaultLatencyMinSamples
+	}
+	if ic.latency.Thresholds == nil {
+		ic.latency.Thresholds = DefaultLatencyThresholds()
+	}
+	if ic.latency.DefaultThreshold <= 0 {
+		ic.latency.DefaultThreshold = DefaultLatencyThreshold
+	}
+	return ic
 }
 
-// CheckHealth executes health api call for a given inference endpoint
+// CheckHealth executes health api call for a given inference endpoint, concurrent calls for a url share a single check
 func (ic *healthClient) CheckHealth(healthCheckURL string) enum.ServiceHealthStatusCode {
-	ic.client.SetTimeout(constants.ClientTimeout * time.Second)
+	return ic.CheckHealthWithOptions(context.Background(), healthCheckURL, HealthCheckOptions{}).Status
+}
+
+// CheckHealthWithOptions checks the health of a url with the given options. The attempts and the sleeps between them
+// stop as soon as ctx is done, the result then holds the last attempt made.
+func (ic *healthClient) CheckHealthWithOptions(ctx context.Context, healthCheckURL string, options HealthCheckOptions) HealthCheckResult {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if options.isDefault() {
+		return ic.sharedCheck(ctx, healthCheckURL, options.ForceRefresh)
+	}
+	return ic.checkHealth(ctx, healthCheckURL, options.withDefaults(), false)
+}
+
+// CheckHealthBatch checks the health of every url with a bounded pool of workers and returns the result of every url.
+// Each url is checked like CheckHealth within its own deadline. Urls that could not be checked before ctx is done
+// are reported with the unknown status.
+func (ic *healthClient) CheckHealthBatch(ctx context.Context, healthCheckURLs []string, opts ...BatchOption) map[string]HealthCheckResult {
+	options := batchOptions{workers: DefaultHealthCheckWorkers, urlTimeout: DefaultHealthCheckURLTimeout}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	results := make(map[string]HealthCheckResult, len(healthCheckURLs))
+	jobs := make(chan string, len(healthCheckURLs))
+	for _, healthCheckURL := range healthCheckURLs {
+		if _, ok := results[healthCheckURL]; ok {
+			continue
+		}
+		results[healthCheckURL] = HealthCheckResult{}
+		jobs <- healthCheckURL
+	}
+	close(jobs)
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	total := len(results)
+	done := 0
+	for i := 0; i < min(max(options.workers, 1), total); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for healthCheckURL := range jobs {
+				result := ic.checkBatchURL(ctx, healthCheckURL, options.urlTimeout, options.forceRefresh)
+				mu.Lock()
+				results[healthCheckURL] = result
+				done++
+				if options.progress != nil {
+					options.progress(healthCheckURL, result, done, total)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// checkBatchURL checks a single url of a batch within its own deadline
+func (ic *healthClient) checkBatchURL(ctx context.Context, healthCheckURL string, timeout time.Duration, forceRefresh bool) HealthCheckResult {
+	if err := ctx.Err(); err != nil {
+		return HealthCheckResult{Status: enum.UnknownStatusCode, Detail: fmt.Sprintf("not checked: %v", err)}
+	}
+	urlCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		urlCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	result := ic.sharedCheck(urlCtx, healthCheckURL, forceRefresh)
+	if err := ctx.Err(); err != nil && !isAvailable(result.Status) {
+		// the batch ran out of time, unlike the url deadline this says nothing about the endpoint
+		result.Status = enum.UnknownStatusCode
+		result.Detail = fmt.Sprintf("check interrupted: %v", err)
+	}
+	return result
+}
 
-	var status enum.ServiceHealthStatusCode
+// checkHealth makes up to options.Attempts attempts until the endpoint is healthy or ctx is done.
+// The latency of a healthy endpoint is recorded for its p95 latency when record is set.
+func (ic *healthClient) checkHealth(ctx context.Context, healthCheckURL string, options HealthCheckOptions, record bool) (result HealthCheckResult) {
+	start := time.Now()
+	defer func() {
+		result.Duration = time.Since(start)
+	}()
 
-	// if service is unreachable/unhealthy, retry for MaxServiceHealthRetries times
-	for retry := 1; retry <= constants.MaxServiceHealthAttempts; retry++ {
-		status = ic.checkHealthInternal(healthCheckURL)
+	// if service is unreachable/unhealthy, retry for options.Attempts times
+	delay := options.Backoff
+	for retry := 1; retry <= options.Attempts; retry++ {
+		status, latency, err := ic.checkHealthInternal(ctx, healthCheckURL, options)
+		result.Status, result.Attempts, result.Detail, result.Latency = status, retry, "", latency
+		if err != nil {
+			result.Detail = err.Error()
+		}
 
 		if status == enum.HealthyStatusCode {
-			return status
+			ic.classifyLatency(healthCheckURL, &result, record)
+			return result
+		}
+		// an open circuit rejects every attempt until it times out, there is no point in waiting for it
+		if errors.Is(err, ErrCircuitOpen) {
+			return result
 		}
 		// retry if error occurred while checking health or service is unhealthy
 		// sleep before retrying, do not sleep after last retry
-		if retry < constants.MaxServiceHealthAttempts {
-			time.Sleep(constants.ServiceHealthCheckRetryDelay * time.Second)
+		if retry < options.Attempts {
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return result
+			case <-timer.C:
+			}
+			if options.BackoffMultiplier > 1 {
+				delay = time.Duration(float64(delay) * options.BackoffMultiplier)
+			}
 		}
 	}
-	return status
+	return result
 }
 
-func (ic *healthClient) checkHealthInternal(healthCheckURL string) enum.ServiceHealthStatusCode {
+// checkHealthInternal makes a single attempt, the latency is zero when no response was received
+func (ic *healthClient) checkHealthInternal(ctx context.Context, healthCheckURL string, options HealthCheckOptions) (enum.ServiceHealthStatusCode, time.Duration, error) {
+	if ic.probeProfile != nil {
+		if profile, ok := ic.probeProfile(healthCheckURL); ok {
+			return ic.probeHealth(ctx, healthCheckURL, profile, options)
+		}
+	}
 	req, err := http.NewRequest(http.MethodGet, healthCheckURL, nil)
 	if err != nil {
 		// returning status as unknown as http request creation failed, hence the status is unknown
-		return enum.UnknownStatusCode
+		return enum.UnknownStatusCode, 0, err
+	}
+	for key, value := range options.Headers {
+		req.Header.Set(key, value)
 	}
 
-	resp, _, err := ic.client.Do(context.Background(), req)
+	// the timeout is set per request, the client may be shared with requests needing another one
+	ctx = WithRequestTimeout(ctx, options.Timeout)
+	start := time.Now()
+	resp, body, err := ic.client.Do(ctx, req)
 
 	// endpoint health is critical as health api failed
 	if err != nil {
-		return enum.CriticalStatusCode
+		return enum.CriticalStatusCode, 0, err
 	}
+	latency := time.Since(start)
 
 	// no error while executing request
 	defer resp.Body.Close() //nolint:errcheck
 
 	// check response status code
-	if resp.StatusCode != http.StatusOK {
-		return enum.CriticalStatusCode
+	if !slices.Contains(options.ExpectedStatusCodes, resp.StatusCode) {
+		return enum.CriticalStatusCode, latency, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	if options.BodyMatcher != nil {
+		if err := options.BodyMatcher(body); err != nil {
+			return enum.CriticalStatusCode, latency, fmt.Errorf("unexpected response body: %w", err)
+		}
+	}
+
+	return enum.HealthyStatusCode, latency, nil
+}
+
+// classifyLatency records the latency of a successful check when record is set and degrades the result when
+// the p95 latency of the url exceeds the threshold of its engine
+func (ic *healthClient) classifyLatency(healthCheckURL string, result *HealthCheckResult, record bool) {
+	ic.mu.Lock()
+	window, ok := ic.latencies[healthCheckURL]
+	if !ok {
+		window = &latencyWindow{}
 	}
+	if record {
+		window.add(result.Latency, ic.latency.Window)
+		ic.latencies[healthCheckURL] = window
+	}
+	result.LatencyP95, result.LatencySamples = percentile95(window.samples), len(window.samples)
+	ic.mu.Unlock()
 
-	return enum.HealthyStatusCode
+	if result.LatencySamples < ic.latency.MinSamples {
+		return
+	}
+	engine, threshold := enum.Engine(""), ic.latency.DefaultThreshold
+	if ic.latency.Engine != nil {
+		engine = ic.latency.Engine(healthCheckURL)
+	}
+	if engineThreshold, ok := ic.latency.Thresholds[engine]; ok {
+		threshold = engineThreshold
+	}
+	if result.LatencyP95 > threshold {
+		result.Status = enum.DegradedStatusCode
+		result.Detail = fmt.Sprintf("p95 latency %s of the last %d checks exceeds %s", result.LatencyP95, result.LatencySamples, threshold)
+	}
+}
+
+// percentile95 returns the nearest rank p95 of samples, zero when there are none
+func percentile95(samples []time.Duration) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	// nearest rank: the smallest sample greater than or equal to 95 percent of the samples
+	return sorted[(len(sorted)*95+99)/100-1]
 }
@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/nutanix-core/nai-api/iep/constants"
@@ -35,17 +38,15 @@ var _ = Describe("Test Inference client methods", func() {
 
 		It("HTTP request creation failed", func() {
 			inferenceClient := client.NewHealthClient(mockClient)
-			mockClient.EXPECT().SetTimeout(5 * time.Second).Return().Times(1)
 			healthStatus := inferenceClient.CheckHealth(":www.abv")
 			Expect(healthStatus).To(Equal(enum.UnknownStatusCode))
 		})
 
 		It("Endpoint status healthy", func() {
 			inferenceClient := client.NewHealthClient(mockClient)
-			mockClient.EXPECT().SetTimeout(5 * time.Second).Return().Times(1)
 			req, err := http.NewRequest(http.MethodGet, endpointURL, nil)
 			Expect(err).ToNot(HaveOccurred())
-			mockClient.EXPECT().Do(context.Background(), req).Return(&http.Response{
+			mockClient.EXPECT().Do(gomock.Any(), req).Return(&http.Response{
 				StatusCode: 200,
 				Body:       io.NopCloser(bytes.NewBufferString(`{"live":true}`)),
 			}, []byte(`{"live":true}`), nil).Times(1)
@@ -55,10 +56,9 @@ var _ = Describe("Test Inference client methods", func() {
 
 		It("Endpoint status critical, after retry", func() {
 			inferenceClient := client.NewHealthClient(mockClient)
-			mockClient.EXPECT().SetTimeout(5 * time.Second).Return().Times(1)
 			req, err := http.NewRequest(http.MethodGet, endpointURL, nil)
 			Expect(err).ToNot(HaveOccurred())
-			mockClient.EXPECT().Do(context.Background(), req).Return(&http.Response{
+			mockClient.EXPECT().Do(gomock.Any(), req).Return(&http.Response{
 				StatusCode: 500,
 				Body:       io.NopCloser(bytes.NewBufferString(`{"no healthy upstream"}`)),
 			}, []byte(`{"no healthy upstream"}`), nil).Times(constants.MaxServiceHealthAttempts)
@@ -68,16 +68,15 @@ var _ = Describe("Test Inference client methods", func() {
 
 		It("Endpoint status critical at first, succeeded on retry", func() {
 			inferenceClient := client.NewHealthClient(mockClient)
-			mockClient.EXPECT().SetTimeout(5 * time.Second).Return().Times(1)
 			req, err := http.NewRequest(http.MethodGet, endpointURL, nil)
 			Expect(err).ToNot(HaveOccurred())
 
 			gomock.InOrder(
-				mockClient.EXPECT().Do(context.Background(), req).Return(&http.Response{
+				mockClient.EXPECT().Do(gomock.Any(), req).Return(&http.Response{
 					StatusCode: 500,
 					Body:       io.NopCloser(bytes.NewBufferString(`{"no healthy upstream"}`)),
 				}, []byte(`{"no healthy upstream"}`), nil).Times(1),
-				mockClient.EXPECT().Do(context.Background(), req).Return(&http.Response{
+				mockClient.EXPECT().Do(gomock.Any(), req).Return(&http.Response{
 					StatusCode: 200,
 					Body:       io.NopCloser(bytes.NewBufferString(`{"live":true}`)),
 				}, []byte(`{"live":true}`), nil).Times(1),
@@ -89,7 +88,6 @@ var _ = Describe("Test Inference client methods", func() {
 
 		It("Endpoint status request times out", func() {
 			inferenceClient := client.NewHealthClient(mockClient)
-			mockClient.EXPECT().SetTimeout(5 * time.Second).Return().Times(1)
 			req, err := http.NewRequest(http.MethodGet, endpointURL, nil)
 			Expect(err).ToNot(HaveOccurred())
 			requestError := &url.Error{
@@ -98,14 +96,13 @@ var _ = Describe("Test Inference client methods", func() {
 				Err: &mockTimeoutError{},
 			}
 
-			mockClient.EXPECT().Do(context.Background(), req).Return(&http.Response{}, nil, requestError).Times(constants.MaxServiceHealthAttempts)
+			mockClient.EXPECT().Do(gomock.Any(), req).Return(&http.Response{}, nil, requestError).Times(constants.MaxServiceHealthAttempts)
 			healthStatus := inferenceClient.CheckHealth(endpointURL)
 			Expect(healthStatus).To(Equal(enum.CriticalStatusCode))
 		})
 
 		It("Error while checking endpoint status", func() {
 			inferenceClient := client.NewHealthClient(mockClient)
-			mockClient.EXPECT().SetTimeout(5 * time.Second).Return().Times(1)
 			req, err := http.NewRequest(http.MethodGet, endpointURL, nil)
 			Expect(err).ToNot(HaveOccurred())
 			requestError := &url.Error{
@@ -113,10 +110,408 @@ var _ = Describe("Test Inference client methods", func() {
 				URL: endpointURL,
 				Err: errors.New("error while reaching the endpoint"),
 			}
-			mockClient.EXPECT().Do(context.Background(), req).Return(&http.Response{}, nil, requestError).Times(constants.MaxServiceHealthAttempts)
+			mockClient.EXPECT().Do(gomock.Any(), req).Return(&http.Response{}, nil, requestError).Times(constants.MaxServiceHealthAttempts)
 			healthStatus := inferenceClient.CheckHealth(endpointURL)
 			Expect(healthStatus).To(Equal(enum.CriticalStatusCode))
 		})
+
+		It("Endpoint status critical, circuit open", func() {
+			inferenceClient := client.NewHealthClient(mockClient)
+			req, err := http.NewRequest(http.MethodGet, endpointURL, nil)
+			Expect(err).ToNot(HaveOccurred())
+			circuitErr := &client.CircuitOpenError{Host: req.URL.Host, RetryAt: time.Now().Add(time.Minute)}
+			mockClient.EXPECT().Do(gomock.Any(), req).Return(nil, nil, circuitErr).Times(1)
+			healthStatus := inferenceClient.CheckHealth(endpointURL)
+			Expect(healthStatus).To(Equal(enum.CriticalStatusCode))
+		})
+	})
+})
+
+var _ = Describe("Test batch health checks", func() {
+
+	var (
+		testServer *httptest.Server
+		release    chan struct{}
+		mu         sync.Mutex
+		inFlight   int
+		maxSeen    int
+		ctx        = context.Background()
+	)
+
+	BeforeEach(func() {
+		release = make(chan struct{})
+		inFlight, maxSeen = 0, 0
+		testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			inFlight++
+			maxSeen = max(maxSeen, inFlight)
+			mu.Unlock()
+			defer func() {
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+			}()
+			switch r.URL.Path {
+			case "/unhealthy":
+				w.WriteHeader(http.StatusServiceUnavailable)
+			case "/blocked":
+				select {
+				case <-release:
+				case <-r.Context().Done():
+				}
+				w.WriteHeader(http.StatusOK)
+			default:
+				time.Sleep(20 * time.Millisecond)
+				w.WriteHeader(http.StatusOK)
+			}
+		}))
+	})
+
+	AfterEach(func() {
+		close(release)
+		testServer.Close()
+	})
+
+	It("should return the status and detail of every url", func() {
+		var (
+			progressMu sync.Mutex
+			reported   []string
+			dones      []int
+		)
+		healthClient := client.NewHealthClient(client.NewClient())
+		urls := []string{testServer.URL + "/healthy", testServer.URL + "/unhealthy", ":www.abv", testServer.URL + "/healthy"}
+		results := healthClient.CheckHealthBatch(ctx, urls,
+			client.WithURLTimeout(500*time.Millisecond),
+			client.WithProgress(func(healthCheckURL string, _ client.HealthCheckResult, done int, total int) {
+				progressMu.Lock()
+				defer progressMu.Unlock()
+				Expect(total).To(Equal(3))
+				reported = append(reported, healthCheckURL)
+				dones = append(dones, done)
+			}))
+
+		Expect(results).To(HaveLen(3))
+		Expect(results[urls[0]].Status).To(Equal(enum.HealthyStatusCode))
+		Expect(results[urls[0]].Attempts).To(Equal(1))
+		Expect(results[urls[0]].Detail).To(BeEmpty())
+		// the healthy endpoint answers after 20ms
+		Expect(results[urls[0]].Duration).To(BeNumerically(">=", 20*time.Millisecond))
+		// the url deadline cuts the retries of an unhealthy endpoint
+		Expect(results[urls[1]].Status).To(Equal(enum.CriticalStatusCode))
+		Expect(results[urls[1]].Attempts).To(Equal(1))
+		Expect(results[urls[1]].Detail).To(Equal("unexpected status code 503"))
+		Expect(results[urls[1]].Duration).To(And(BeNumerically(">", 0), BeNumerically("<", time.Second)))
+		Expect(results[urls[2]].Status).To(Equal(enum.UnknownStatusCode))
+		Expect(results[urls[2]].Detail).ToNot(BeEmpty())
+
+		Expect(reported).To(ConsistOf(urls[0], urls[1], urls[2]))
+		Expect(dones).To(Equal([]int{1, 2, 3}))
+	})
+
+	It("should bound the number of concurrent checks", func() {
+		urls := make([]string, 10)
+		for i := range urls {
+			urls[i] = fmt.Sprintf("%s/healthy?endpoint=%d", testServer.URL, i)
+		}
+		results := client.NewHealthClient(client.NewClient()).CheckHealthBatch(ctx, urls, client.WithBatchWorkers(3))
+		Expect(results).To(HaveLen(10))
+		for _, result := range results {
+			Expect(result.Status).To(Equal(enum.HealthyStatusCode))
+		}
+		Expect(maxSeen).To(BeNumerically("<=", 3))
+	})
+
+	It("should report urls not checked before the overall deadline as unknown", func() {
+		urls := []string{testServer.URL + "/blocked", testServer.URL + "/healthy"}
+		batchCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		results := client.NewHealthClient(client.NewClient()).CheckHealthBatch(batchCtx, urls, client.WithBatchWorkers(1))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+
+		Expect(results[urls[0]].Status).To(Equal(enum.UnknownStatusCode))
+		Expect(results[urls[0]].Detail).To(ContainSubstring("check interrupted"))
+		Expect(results[urls[0]].Attempts).To(Equal(1))
+		Expect(results[urls[1]].Status).To(Equal(enum.UnknownStatusCode))
+		Expect(results[urls[1]].Detail).To(ContainSubstring("not checked"))
+		Expect(results[urls[1]].Attempts).To(BeZero())
+	})
+})
+
+var _ = Describe("Test latency classification", func() {
+
+	var (
+		testServer *httptest.Server
+		mu         sync.Mutex
+		delay      time.Duration
+		engines    = map[string]enum.Engine{}
+		ctx        = context.Background()
+	)
+
+	setDelay := func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		delay = d
+	}
+
+	BeforeEach(func() {
+		setDelay(0)
+		testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			d := delay
+			mu.Unlock()
+			time.Sleep(d)
+			w.WriteHeader(http.StatusOK)
+		}))
+		engines[testServer.URL+"/tgi"] = enum.TGIEngine
+		engines[testServer.URL+"/vllm"] = enum.VLLMEngine
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	newHealthClient := func() client.IHealthClient {
+		return client.NewHealthClient(client.NewClient(), client.WithLatencyConfig(client.LatencyConfig{
+			Window:     5,
+			MinSamples: 3,
+			Thresholds: map[enum.Engine]time.Duration{enum.TGIEngine: 10 * time.Millisecond, enum.VLLMEngine: time.Minute},
+			Engine: func(healthCheckURL string) enum.Engine {
+				return engines[healthCheckURL]
+			},
+		}))
+	}
+
+	It("should degrade endpoints whose p95 latency exceeds the threshold of their engine", func() {
+		healthClient := newHealthClient()
+		setDelay(30 * time.Millisecond)
+		urls := []string{testServer.URL + "/tgi", testServer.URL + "/vllm"}
+
+		results := healthClient.CheckHealthBatch(ctx, urls)
+		Expect(results[urls[0]].Status).To(Equal(enum.HealthyStatusCode))
+		Expect(results[urls[0]].Latency).To(BeNumerically(">=", 30*time.Millisecond))
+		Expect(results[urls[0]].LatencyP95).To(Equal(results[urls[0]].Latency))
+		Expect(results[urls[0]].LatencySamples).To(Equal(1))
+
+		healthClient.CheckHealthBatch(ctx, urls)
+		results = healthClient.CheckHealthBatch(ctx, urls)
+		Expect(results[urls[0]].Status).To(Equal(enum.DegradedStatusCode))
+		Expect(results[urls[0]].Detail).To(MatchRegexp(`^p95 latency .* of the last 3 checks exceeds 10ms$`))
+		Expect(results[urls[0]].LatencySamples).To(Equal(3))
+		Expect(results[urls[1]].Status).To(Equal(enum.HealthyStatusCode))
+		Expect(healthClient.CheckHealth(urls[0])).To(Equal(enum.DegradedStatusCode))
+	})
+
+	It("should recover once the slow checks leave the window", func() {
+		healthClient := newHealthClient()
+		tgiURL := testServer.URL + "/tgi"
+		setDelay(30 * time.Millisecond)
+		for i := 0; i < 3; i++ {
+			healthClient.CheckHealth(tgiURL)
+		}
+		Expect(healthClient.CheckHealth(tgiURL)).To(Equal(enum.DegradedStatusCode))
+
+		setDelay(0)
+		statuses := make([]enum.ServiceHealthStatusCode, 5)
+		for i := range statuses {
+			statuses[i] = healthClient.CheckHealth(tgiURL)
+		}
+		// the p95 of 5 checks is the slowest one
+		Expect(statuses[3]).To(Equal(enum.DegradedStatusCode))
+		Expect(statuses[4]).To(Equal(enum.HealthyStatusCode))
+	})
+
+	It("should not record the latency of checks with other options", func() {
+		healthClient := newHealthClient()
+		tgiURL := testServer.URL + "/tgi"
+		options := client.HealthCheckOptions{Headers: map[string]string{"X-Check": "deep"}}
+		setDelay(30 * time.Millisecond)
+		for i := 0; i < 3; i++ {
+			result := healthClient.CheckHealthWithOptions(ctx, tgiURL, options)
+			Expect(result.Status).To(Equal(enum.HealthyStatusCode))
+			Expect(result.LatencySamples).To(BeZero())
+		}
+
+		setDelay(0)
+		result := healthClient.CheckHealthWithOptions(ctx, tgiURL, client.HealthCheckOptions{})
+		Expect(result.Status).To(Equal(enum.HealthyStatusCode))
+		Expect(result.LatencySamples).To(Equal(1))
+		// checks with other options are still classified with the latency of the default checks
+		result = healthClient.CheckHealthWithOptions(ctx, tgiURL, options)
+		Expect(result.LatencyP95).To(BeNumerically("<", 10*time.Millisecond))
+		Expect(result.LatencySamples).To(Equal(1))
+	})
+
+	It("should use the default threshold for engines without one", func() {
+		healthClient := client.NewHealthClient(client.NewClient(), client.WithLatencyConfig(client.LatencyConfig{
+			MinSamples:       1,
+			DefaultThreshold: 10 * time.Millisecond,
+		}))
+		setDelay(30 * time.Millisecond)
+		Expect(healthClient.CheckHealth(testServer.URL + "/custom")).To(Equal(enum.DegradedStatusCode))
+	})
+
+	It("should have a default threshold for every engine with a probe profile", func() {
+		thresholds := client.DefaultLatencyThresholds()
+		for _, engine := range []enum.Engine{enum.TGIEngine, enum.VLLMEngine, enum.NIMEngine, enum.KServeV2Engine} {
+			Expect(thresholds).To(HaveKeyWithValue(engine, time.Second))
+		}
+	})
+})
+
+var _ = Describe("Test health checks with options", func() {
+
+	var (
+		testServer *httptest.Server
+		mu         sync.Mutex
+		hits       int
+		status     int
+		body       string
+		headers    http.Header
+		ctx        = context.Background()
+	)
+
+	requests := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return hits
+	}
+
+	BeforeEach(func() {
+		hits, status, body, headers = 0, http.StatusOK, `{"live":true}`, nil
+		testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			hits++
+			headers = r.Header.Clone()
+			code, payload := status, body
+			mu.Unlock()
+			if r.URL.Path == "/slow" {
+				select {
+				case <-time.After(time.Second):
+				case <-r.Context().Done():
+					return
+				}
+			}
+			w.WriteHeader(code)
+			_, _ = w.Write([]byte(payload))
+		}))
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	It("should accept the expected status codes", func() {
+		status = http.StatusNoContent
+		healthClient := client.NewHealthClient(client.NewClient())
+		result := healthClient.CheckHealthWithOptions(ctx, testServer.URL, client.HealthCheckOptions{
+			ExpectedStatusCodes: []int{http.StatusOK, http.StatusNoContent},
+		})
+		Expect(result.Status).To(Equal(enum.HealthyStatusCode))
+		Expect(result.Attempts).To(Equal(1))
+
+		result = healthClient.CheckHealthWithOptions(ctx, testServer.URL, client.HealthCheckOptions{Attempts: 1})
+		Expect(result.Status).To(Equal(enum.CriticalStatusCode))
+		Expect(result.Detail).To(Equal("unexpected status code 204"))
+	})
+
+	It("should send the custom headers", func() {
+		healthClient := client.NewHealthClient(client.NewClient())
+		result := healthClient.CheckHealthWithOptions(ctx, testServer.URL, client.HealthCheckOptions{
+			Headers: map[string]string{"Authorization": "Bearer token"},
+		})
+		Expect(result.Status).To(Equal(enum.HealthyStatusCode))
+		mu.Lock()
+		defer mu.Unlock()
+		Expect(headers.Get("Authorization")).To(Equal("Bearer token"))
+	})
+
+	It("should match the response body", func() {
+		body = `{"live":false}`
+		healthClient := client.NewHealthClient(client.NewClient())
+		result := healthClient.CheckHealthWithOptions(ctx, testServer.URL, client.HealthCheckOptions{
+			Attempts: 2,
+			Backoff:  time.Millisecond,
+			BodyMatcher: func(body []byte) error {
+				if !bytes.Contains(body, []byte(`"live":true`)) {
+					return fmt.Errorf("endpoint not live: %s", body)
+				}
+				return nil
+			},
+		})
+		Expect(result.Status).To(Equal(enum.CriticalStatusCode))
+		Expect(result.Attempts).To(Equal(2))
+		Expect(result.Detail).To(Equal(`unexpected response body: endpoint not live: {"live":false}`))
+	})
+
+	It("should make the given attempts with a growing backoff", func() {
+		status = http.StatusServiceUnavailable
+		healthClient := client.NewHealthClient(client.NewClient())
+		start := time.Now()
+		result := healthClient.CheckHealthWithOptions(ctx, testServer.URL, client.HealthCheckOptions{
+			Attempts:          3,
+			Backoff:           20 * time.Millisecond,
+			BackoffMultiplier: 2,
+		})
+		Expect(result.Status).To(Equal(enum.CriticalStatusCode))
+		Expect(result.Attempts).To(Equal(3))
+		Expect(requests()).To(Equal(3))
+		// 20ms then 40ms between the attempts
+		Expect(time.Since(start)).To(BeNumerically(">=", 60*time.Millisecond))
+	})
+
+	It("should stop sleeping between attempts when ctx is cancelled", func() {
+		status = http.StatusServiceUnavailable
+		healthClient := client.NewHealthClient(client.NewClient())
+		checkCtx, cancel := context.WithCancel(ctx)
+		results := make(chan client.HealthCheckResult, 1)
+		go func() {
+			results <- healthClient.CheckHealthWithOptions(checkCtx, testServer.URL, client.HealthCheckOptions{
+				Attempts: 5,
+				Backoff:  time.Minute,
+			})
+		}()
+		Eventually(requests).Should(Equal(1))
+		cancel()
+
+		var result client.HealthCheckResult
+		Eventually(results).Should(Receive(&result))
+		Expect(result.Status).To(Equal(enum.CriticalStatusCode))
+		Expect(result.Attempts).To(Equal(1))
+		Expect(requests()).To(Equal(1))
+	})
+
+	It("should bound every attempt with the timeout", func() {
+		healthClient := client.NewHealthClient(client.NewClient())
+		start := time.Now()
+		result := healthClient.CheckHealthWithOptions(ctx, testServer.URL+"/slow", client.HealthCheckOptions{
+			Attempts: 2,
+			Backoff:  time.Millisecond,
+			Timeout:  20 * time.Millisecond,
+		})
+		Expect(result.Status).To(Equal(enum.CriticalStatusCode))
+		Expect(result.Attempts).To(Equal(2))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	})
+
+	It("should replace the default timeout of the client with the timeout", func() {
+		healthClient := client.NewHealthClient(client.NewClient(client.WithTimeout(20 * time.Millisecond)))
+		result := healthClient.CheckHealthWithOptions(ctx, testServer.URL+"/slow", client.HealthCheckOptions{
+			Attempts: 1,
+			Timeout:  5 * time.Second,
+		})
+		Expect(result.Status).To(Equal(enum.HealthyStatusCode))
+	})
+
+	It("should check like CheckHealth with the default options", func() {
+		healthClient := client.NewHealthClient(client.NewClient(), client.WithCacheTTL(time.Minute))
+		Expect(healthClient.CheckHealth(testServer.URL)).To(Equal(enum.HealthyStatusCode))
+		result := healthClient.CheckHealthWithOptions(ctx, testServer.URL, client.HealthCheckOptions{})
+		Expect(result.Status).To(Equal(enum.HealthyStatusCode))
+		Expect(requests()).To(Equal(1))
+
+		healthClient.CheckHealthWithOptions(ctx, testServer.URL, client.HealthCheckOptions{ForceRefresh: true})
+		Expect(requests()).To(Equal(2))
 	})
 })
 
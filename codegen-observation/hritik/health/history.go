@@ -0,0 +1,338 @@
+package client
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nutanix-core/nai-api/iep/constants/enum"
+	"github.com/nutanix-core/nai-api/iep/internal/model"
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultHealthRetention is how long health observations are kept, long enough for the 7 day uptime
+	DefaultHealthRetention = 8 * 24 * time.Hour
+	// DefaultSLOTarget is the uptime percentage endpoints are expected to meet
+	DefaultSLOTarget = 99.0
+	// DefaultMaxTransitions is the number of most recent status transitions reported
+	DefaultMaxTransitions = 100
+	// DefaultHealthStaleness is how long an observation holds without a following one, ten monitor intervals
+	DefaultHealthStaleness = 10 * DefaultMonitorInterval
+)
+
+// uptimeWindows are the rolling windows uptime is reported over, shortest first
+var uptimeWindows = []struct {
+	name     string
+	duration time.Duration
+}{
+	{name: "1h", duration: time.Hour},
+	{name: "24h", duration: 24 * time.Hour},
+	{name: "7d", duration: 7 * 24 * time.Hour},
+}
+
+// HealthObservation is the health of an endpoint observed at a point in time
+type HealthObservation struct {
+	Status    enum.ServiceHealthStatusCode
+	Timestamp time.Time
+	Latency   time.Duration
+	Detail    string
+}
+
+// IHealthHistoryStore persists the health observations of endpoints
+type IHealthHistoryStore interface {
+	Append(endpointID string, observation HealthObservation) error
+	// List returns the observations of an endpoint made at or after since, oldest first
+	List(endpointID string, since time.Time) ([]HealthObservation, error)
+	// DeleteBefore deletes the observations of every endpoint made before the given time
+	DeleteBefore(before time.Time) error
+}
+
+type memoryHealthHistoryStore struct {
+	mu           sync.RWMutex
+	observations map[string][]HealthObservation
+}
+
+// NewMemoryHealthHistoryStore returns a store keeping observations in memory, they are lost on restart
+func NewMemoryHealthHistoryStore() IHealthHistoryStore {
+	return &memoryHealthHistoryStore{observations: map[string][]HealthObservation{}}
+}
+
+func (s *memoryHealthHistoryStore) Append(endpointID string, observation HealthObservation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	observations := s.observations[endpointID]
+	// observations of concurrent probes may arrive out of order
+	i := sort.Search(len(observations), func(i int) bool {
+		return observations[i].Timestamp.After(observation.Timestamp)
+	})
+	observations = append(observations, HealthObservation{})
+	copy(observations[i+1:], observations[i:])
+	observations[i] = observation
+	s.observations[endpointID] = observations
+	return nil
+}
+
+func (s *memoryHealthHistoryStore) List(endpointID string, since time.Time) ([]HealthObservation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	observations := s.observations[endpointID]
+	i := sort.Search(len(observations), func(i int) bool {
+		return !observations[i].Timestamp.Before(since)
+	})
+	return append([]HealthObservation(nil), observations[i:]...), nil
+}
+
+func (s *memoryHealthHistoryStore) DeleteBefore(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for endpointID, observations := range s.observations {
+		i := sort.Search(len(observations), func(i int) bool {
+			return !observations[i].Timestamp.Before(before)
+		})
+		if i == len(observations) {
+			delete(s.observations, endpointID)
+			continue
+		}
+		s.observations[endpointID] = append([]HealthObservation(nil), observations[i:]...)
+	}
+	return nil
+}
+
+type dbHealthHistoryStore struct {
+	db *gorm.DB
+}
+
+// NewDBHealthHistoryStore returns a store keeping observations in the database, they survive restarts and are shared by replicas
+func NewDBHealthHistoryStore(db *gorm.DB) IHealthHistoryStore {
+	return &dbHealthHistoryStore{db: db}
+}
+
+func (s *dbHealthHistoryStore) Append(endpointID string, observation HealthObservation) error {
+	return s.db.Create(&model.EndpointHealthObservation{
+		BaseModel:  model.BaseModel{ID: uuid.NewString()},
+		EndpointID: endpointID,
+		ObservedAt: observation.Timestamp,
+		Status:     observation.Status,
+		LatencyMs:  observation.Latency.Milliseconds(),
+		Detail:     observation.Detail,
+	}).Error
+}
+
+func (s *dbHealthHistoryStore) List(endpointID string, since time.Time) ([]HealthObservation, error) {
+	var rows []model.EndpointHealthObservation
+	err := s.db.Where("endpoint_id = ? AND observed_at >= ?", endpointID, since).Order("observed_at, created_at").Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	observations := make([]HealthObservation, 0, len(rows))
+	for _, row := range rows {
+		observations = append(observations, HealthObservation{
+			Status:    row.Status,
+			Timestamp: row.ObservedAt,
+			Latency:   time.Duration(row.LatencyMs) * time.Millisecond,
+			Detail:    row.Detail,
+		})
+	}
+	return observations, nil
+}
+
+// DeleteBefore deletes the observations for good, expired observations are not kept soft deleted
+func (s *dbHealthHistoryStore) DeleteBefore(before time.Time) error {
+	return s.db.Unscoped().Where("observed_at < ?", before).Delete(&model.EndpointHealthObservation{}).Error
+}
+
+// HealthStatusChange is a change of the observed status of an endpoint
+type HealthStatusChange struct {
+	From   enum.ServiceHealthStatusCode `json:"from"`
+	To     enum.ServiceHealthStatusCode `json:"to"`
+	At     time.Time                    `json:"at"`
+	Detail string                       `json:"detail,omitempty"`
+}
+
+// Uptime is the share of a rolling window an endpoint was observed healthy or degraded in
+type Uptime struct {
+	Window string `json:"window"`
+	// Percent is nil when the endpoint was not observed during the window
+	Percent *float64 `json:"percent"`
+	// ObservedSeconds is the part of the window the status of the endpoint was known for
+	ObservedSeconds int64 `json:"observedSeconds"`
+	// MeetsSLO is nil when the endpoint was not observed during the window
+	MeetsSLO *bool `json:"meetsSlo"`
+}
+
+// HealthReport is the current health of an endpoint with its history
+type HealthReport struct {
+	EndpointID string                       `json:"endpointId"`
+	Status     enum.ServiceHealthStatusCode `json:"status"`
+	Detail     string                       `json:"detail,omitempty"`
+	// LastChecked is nil when the endpoint was not observed during the longest uptime window
+	LastChecked *time.Time `json:"lastChecked"`
+	// LatencyMs is the health check latency of the last observation
+	LatencyMs int64 `json:"latencyMs"`
+	// LatencyP95Ms is the p95 health check latency of the observations of the last hour with a latency
+	LatencyP95Ms int64 `json:"latencyP95Ms"`
+	// Transitions are the most recent status changes of the longest uptime window, oldest first
+	Transitions []HealthStatusChange `json:"transitions"`
+	Uptime      []Uptime             `json:"uptime"`
+	SLOTarget   float64              `json:"sloTarget"`
+}
+
+// HealthHistoryConfig configures a health history
+type HealthHistoryConfig struct {
+	// Retention is how long observations are kept, DefaultHealthRetention by default
+	Retention time.Duration
+	// SLOTarget is the uptime percentage endpoints are expected to meet, DefaultSLOTarget by default
+	SLOTarget float64
+	// MaxTransitions is the number of transitions reported, DefaultMaxTransitions by default
+	MaxTransitions int
+	// Staleness is how long an observation holds when no other follows it, DefaultHealthStaleness by default.
+	// The time past it counts as unobserved, such as the downtime of the monitor.
+	Staleness time.Duration
+	// Clock is the real clock by default
+	Clock Clock
+}
+
+// IHealthHistory records the health observations of endpoints and reports on them
+type IHealthHistory interface {
+	Record(endpointID string, observation HealthObservation) error
+	Report(endpointID string) (HealthReport, error)
+}
+
+type healthHistory struct {
+	store  IHealthHistoryStore
+	config HealthHistoryConfig
+
+	mu         sync.Mutex
+	lastPruned time.Time
+}
+
+// NewHealthHistory returns a health history keeping its observations in store
+func NewHealthHistory(store IHealthHistoryStore, config HealthHistoryConfig) IHealthHistory {
+	if config.Retention <= 0 {
+		config.Retention = DefaultHealthRetention
+	}
+	if config.SLOTarget <= 0 {
+		config.SLOTarget = DefaultSLOTarget
+	}
+	if config.MaxTransitions <= 0 {
+		config.MaxTransitions = DefaultMaxTransitions
+	}
+	if config.Staleness <= 0 {
+		config.Staleness = DefaultHealthStaleness
+	}
+	if config.Clock == nil {
+		config.Clock = realClock{}
+	}
+	return &healthHistory{store: store, config: config}
+}
+
+// Record stores an observation and deletes the observations older than the retention, at most once a minute
+func (h *healthHistory) Record(endpointID string, observation HealthObservation) error {
+	if observation.Timestamp.IsZero() {
+		observation.Timestamp = h.config.Clock.Now()
+	}
+	if err := h.store.Append(endpointID, observation); err != nil {
+		return err
+	}
+
+	now := h.config.Clock.Now()
+	h.mu.Lock()
+	prune := now.Sub(h.lastPruned) >= time.Minute
+	if prune {
+		h.lastPruned = now
+	}
+	h.mu.Unlock()
+	if prune {
+		return h.store.DeleteBefore(now.Add(-h.config.Retention))
+	}
+	return nil
+}
+
+// Report returns the current status of an endpoint, its recent transitions and its uptime.
+// An observed status holds until the next observation, or until it is stale. Unknown and unobserved periods
+// count neither for nor against uptime. Every uptime window is read from the store on its own.
+func (h *healthHistory) Report(endpointID string) (HealthReport, error) {
+	now := h.config.Clock.Now()
+	report := HealthReport{
+		EndpointID:  endpointID,
+		Status:      enum.UnknownStatusCode,
+		Transitions: []HealthStatusChange{},
+		SLOTarget:   h.config.SLOTarget,
+	}
+	var observations []HealthObservation
+	for i, window := range uptimeWindows {
+		start := now.Add(-window.duration)
+		// the last observation before the window holds at its start until it is stale
+		var err error
+		observations, err = h.store.List(endpointID, start.Add(-h.config.Staleness))
+		if err != nil {
+			return report, err
+		}
+		if i == 0 {
+			var latencies []time.Duration
+			for _, observation := range observations {
+				if observation.Latency > 0 && !observation.Timestamp.Before(start) {
+					latencies = append(latencies, observation.Latency)
+				}
+			}
+			report.LatencyP95Ms = percentile95(latencies).Milliseconds()
+		}
+		if report.LastChecked == nil && len(observations) > 0 {
+			last := observations[len(observations)-1]
+			report.Status, report.Detail, report.LatencyMs = last.Status, last.Detail, last.Latency.Milliseconds()
+			report.LastChecked = &last.Timestamp
+		}
+		report.Uptime = append(report.Uptime, h.uptime(window.name, observations, start, now))
+	}
+
+	// observations holds the longest window
+	for i := 1; i < len(observations); i++ {
+		if observations[i].Status != observations[i-1].Status {
+			report.Transitions = append(report.Transitions, HealthStatusChange{
+				From:   observations[i-1].Status,
+				To:     observations[i].Status,
+				At:     observations[i].Timestamp,
+				Detail: observations[i].Detail,
+			})
+		}
+	}
+	if len(report.Transitions) > h.config.MaxTransitions {
+		report.Transitions = report.Transitions[len(report.Transitions)-h.config.MaxTransitions:]
+	}
+	return report, nil
+}
+
+// uptime returns the share of [start, end) the endpoint was observed serving in, slow but serving counts as up
+func (h *healthHistory) uptime(name string, observations []HealthObservation, start time.Time, end time.Time) Uptime {
+	var healthy, observed time.Duration
+	for i, observation := range observations {
+		from := observation.Timestamp
+		to := end
+		if i+1 < len(observations) {
+			to = observations[i+1].Timestamp
+		}
+		if stale := from.Add(h.config.Staleness); to.After(stale) {
+			to = stale
+		}
+		if from.Before(start) {
+			from = start
+		}
+		if !to.After(from) || observation.Status == enum.UnknownStatusCode {
+			continue
+		}
+		observed += to.Sub(from)
+		if isAvailable(observation.Status) {
+			healthy += to.Sub(from)
+		}
+	}
+
+	uptime := Uptime{Window: name, ObservedSeconds: int64(observed.Seconds())}
+	if observed > 0 {
+		percent := 100 * float64(healthy) / float64(observed)
+		meetsSLO := percent >= h.config.SLOTarget
+		uptime.Percent, uptime.MeetsSLO = &percent, &meetsSLO
+	}
+	return uptime
+}
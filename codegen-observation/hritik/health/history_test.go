@@ -0,0 +1,233 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nutanix-core/nai-api/iep/constants/enum"
+	"github.com/nutanix-core/nai-api/iep/internal/client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// failingStore is a history store whose reads fail
+type failingStore struct {
+	client.IHealthHistoryStore
+	err error
+}
+
+func (s failingStore) List(string, time.Time) ([]client.HealthObservation, error) {
+	return nil, s.err
+}
+
+// listingStore records the start of the observations listed from a history store
+type listingStore struct {
+	client.IHealthHistoryStore
+	since []time.Time
+}
+
+func (s *listingStore) List(endpointID string, since time.Time) ([]client.HealthObservation, error) {
+	s.since = append(s.since, since)
+	return s.IHealthHistoryStore.List(endpointID, since)
+}
+
+var _ = Describe("Test health history", func() {
+
+	var (
+		clock   *fakeClock
+		store   client.IHealthHistoryStore
+		history client.IHealthHistory
+	)
+
+	// observe records a status observed the given time ago
+	observe := func(ago time.Duration, status enum.ServiceHealthStatusCode) {
+		Expect(history.Record("endpoint-1", client.HealthObservation{
+			Status:    status,
+			Timestamp: clock.Now().Add(-ago),
+			Latency:   25 * time.Millisecond,
+			Detail:    string(status),
+		})).To(Succeed())
+	}
+
+	uptime := func(report client.HealthReport, window string) client.Uptime {
+		for _, uptime := range report.Uptime {
+			if uptime.Window == window {
+				return uptime
+			}
+		}
+		Fail("no uptime for window " + window)
+		return client.Uptime{}
+	}
+
+	BeforeEach(func() {
+		clock = newFakeClock()
+		store = client.NewMemoryHealthHistoryStore()
+		// the observations of most tests are hours apart
+		history = client.NewHealthHistory(store, client.HealthHistoryConfig{SLOTarget: 99, Staleness: 7 * 24 * time.Hour, Clock: clock})
+	})
+
+	It("should report an endpoint never observed as unknown", func() {
+		report, err := history.Report("endpoint-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.Status).To(Equal(enum.UnknownStatusCode))
+		Expect(report.LastChecked).To(BeNil())
+		Expect(report.Transitions).To(BeEmpty())
+		Expect(report.Uptime).To(HaveLen(3))
+		for _, uptime := range report.Uptime {
+			Expect(uptime.Percent).To(BeNil())
+			Expect(uptime.MeetsSLO).To(BeNil())
+		}
+	})
+
+	It("should report the current status and the transitions", func() {
+		observe(50*time.Minute, enum.HealthyStatusCode)
+		observe(40*time.Minute, enum.HealthyStatusCode)
+		observe(30*time.Minute, enum.CriticalStatusCode)
+		observe(20*time.Minute, enum.HealthyStatusCode)
+
+		report, err := history.Report("endpoint-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.EndpointID).To(Equal("endpoint-1"))
+		Expect(report.Status).To(Equal(enum.HealthyStatusCode))
+		Expect(*report.LastChecked).To(Equal(clock.Now().Add(-20 * time.Minute)))
+		Expect(report.LatencyMs).To(BeEquivalentTo(25))
+		Expect(report.Transitions).To(Equal([]client.HealthStatusChange{
+			{From: enum.HealthyStatusCode, To: enum.CriticalStatusCode, At: clock.Now().Add(-30 * time.Minute), Detail: string(enum.CriticalStatusCode)},
+			{From: enum.CriticalStatusCode, To: enum.HealthyStatusCode, At: clock.Now().Add(-20 * time.Minute), Detail: string(enum.HealthyStatusCode)},
+		}))
+	})
+
+	It("should compute rolling uptime against the SLO", func() {
+		// critical for 6 of the last 24 hours, healthy since
+		observe(3*24*time.Hour, enum.HealthyStatusCode)
+		observe(24*time.Hour, enum.CriticalStatusCode)
+		observe(18*time.Hour, enum.HealthyStatusCode)
+
+		report, err := history.Report("endpoint-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.SLOTarget).To(Equal(99.0))
+
+		hour := uptime(report, "1h")
+		Expect(*hour.Percent).To(Equal(100.0))
+		Expect(*hour.MeetsSLO).To(BeTrue())
+		Expect(hour.ObservedSeconds).To(BeEquivalentTo(3600))
+
+		day := uptime(report, "24h")
+		Expect(*day.Percent).To(Equal(75.0))
+		Expect(*day.MeetsSLO).To(BeFalse())
+
+		// the endpoint was only observed for the last 3 days of the week
+		week := uptime(report, "7d")
+		Expect(*week.Percent).To(BeNumerically("~", 100*66.0/72.0, 0.001))
+		Expect(week.ObservedSeconds).To(BeEquivalentTo(3 * 24 * 3600))
+	})
+
+	It("should count slow endpoints as up and report the p95 latency", func() {
+		observe(time.Hour, enum.HealthyStatusCode)
+		observe(30*time.Minute, enum.DegradedStatusCode)
+		Expect(history.Record("endpoint-1", client.HealthObservation{Status: enum.DegradedStatusCode, Latency: 3 * time.Second})).To(Succeed())
+
+		report, err := history.Report("endpoint-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.Status).To(Equal(enum.DegradedStatusCode))
+		Expect(*uptime(report, "1h").Percent).To(Equal(100.0))
+		Expect(report.LatencyMs).To(BeEquivalentTo(3000))
+		Expect(report.LatencyP95Ms).To(BeEquivalentTo(3000))
+	})
+
+	It("should leave unknown periods out of the uptime", func() {
+		observe(time.Hour, enum.HealthyStatusCode)
+		observe(30*time.Minute, enum.UnknownStatusCode)
+
+		report, err := history.Report("endpoint-1")
+		Expect(err).ToNot(HaveOccurred())
+		hour := uptime(report, "1h")
+		Expect(*hour.Percent).To(Equal(100.0))
+		Expect(hour.ObservedSeconds).To(BeEquivalentTo(1800))
+	})
+
+	It("should carry the status observed before a window into it", func() {
+		observe(2*time.Hour, enum.CriticalStatusCode)
+		report, err := history.Report("endpoint-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*uptime(report, "1h").Percent).To(BeZero())
+	})
+
+	It("should count the time past the staleness of an observation as unobserved", func() {
+		history = client.NewHealthHistory(store, client.HealthHistoryConfig{Clock: clock})
+		observe(50*time.Minute, enum.HealthyStatusCode)
+		observe(40*time.Minute, enum.CriticalStatusCode)
+
+		report, err := history.Report("endpoint-1")
+		Expect(err).ToNot(HaveOccurred())
+		hour := uptime(report, "1h")
+		Expect(hour.ObservedSeconds).To(BeEquivalentTo(2 * client.DefaultHealthStaleness / time.Second))
+		Expect(*hour.Percent).To(Equal(50.0))
+	})
+
+	It("should read every window from the store on its own", func() {
+		listing := &listingStore{IHealthHistoryStore: store}
+		history = client.NewHealthHistory(listing, client.HealthHistoryConfig{Staleness: time.Minute, Clock: clock})
+		observe(time.Hour, enum.HealthyStatusCode)
+		_, err := history.Report("endpoint-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(listing.since).To(Equal([]time.Time{
+			clock.Now().Add(-time.Hour - time.Minute),
+			clock.Now().Add(-24*time.Hour - time.Minute),
+			clock.Now().Add(-7*24*time.Hour - time.Minute),
+		}))
+	})
+
+	It("should delete observations older than the retention", func() {
+		history = client.NewHealthHistory(store, client.HealthHistoryConfig{Retention: 24 * time.Hour, Clock: clock})
+		observe(48*time.Hour, enum.CriticalStatusCode)
+		observe(time.Hour, enum.HealthyStatusCode)
+
+		observations, err := store.List("endpoint-1", time.Time{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(observations).To(HaveLen(1))
+		Expect(observations[0].Status).To(Equal(enum.HealthyStatusCode))
+	})
+
+	It("should keep observations in time order", func() {
+		observe(time.Minute, enum.HealthyStatusCode)
+		observe(2*time.Minute, enum.CriticalStatusCode)
+		Expect(history.Record("endpoint-1", client.HealthObservation{Status: enum.HealthyStatusCode})).To(Succeed())
+
+		observations, err := store.List("endpoint-1", clock.Now().Add(-90*time.Second))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(observations).To(HaveLen(2))
+		Expect(observations[1].Timestamp).To(Equal(clock.Now()))
+	})
+
+	It("should fail reports when the store cannot be read", func() {
+		storeErr := errors.New("database unavailable")
+		history = client.NewHealthHistory(failingStore{IHealthHistoryStore: store, err: storeErr}, client.HealthHistoryConfig{Clock: clock})
+		_, err := history.Report("endpoint-1")
+		Expect(err).To(MatchError(storeErr))
+	})
+
+	It("should record the probes of the monitor", func() {
+		monitor := client.NewHealthMonitor(batchHealthFunc(func(_ context.Context, healthCheckURLs []string) map[string]client.HealthCheckResult {
+			return map[string]client.HealthCheckResult{healthCheckURLs[0]: {Status: enum.CriticalStatusCode, Detail: "unexpected status code 503", Duration: 3 * time.Second, Latency: time.Second}}
+		}), client.MonitorConfig{Clock: clock, History: history})
+		monitor.Register("endpoint-1", "http://endpoint-1/health")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			monitor.Run(ctx)
+		}()
+		Eventually(clock.Waiters).Should(Equal(1))
+		cancel()
+		Eventually(done).Should(BeClosed())
+
+		report, err := history.Report("endpoint-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.Status).To(Equal(enum.CriticalStatusCode))
+		Expect(report.Detail).To(Equal("unexpected status code 503"))
+		Expect(report.LatencyMs).To(BeEquivalentTo(1000))
+	})
+})
@@ -0,0 +1,357 @@
+package client
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nutanix-core/nai-api/iep/constants/enum"
+)
+
+const (
+	// DefaultMonitorInterval is the time between two probes of the registered endpoints
+	DefaultMonitorInterval = 30 * time.Second
+	// DefaultFailureThreshold is the number of consecutive failed probes before an endpoint is critical
+	DefaultFailureThreshold = 3
+	// DefaultSuccessThreshold is the number of consecutive successful probes before an endpoint is healthy
+	DefaultSuccessThreshold = 2
+)
+
+// HealthState is the state of an endpoint tracked by the health monitor
+type HealthState string
+
+const (
+	// StateUnknown is the state of endpoints that were not probed enough to tell, or could not be probed
+	StateUnknown HealthState = "Unknown"
+	// StateHealthy is reached after SuccessThreshold consecutive successful probes
+	StateHealthy HealthState = "Healthy"
+	// StateDegraded is the state of endpoints that failed or passed some probes but not enough to change state
+	StateDegraded HealthState = "Degraded"
+	// StateSlow is the state of endpoints answering their probes slower than the latency threshold
+	StateSlow HealthState = "Slow"
+	// StateCritical is reached after FailureThreshold consecutive failed probes
+	StateCritical HealthState = "Critical"
+)
+
+// Clock tells the time and waits for it to pass, it is replaced by a fake clock in tests
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// MonitorConfig configures a health monitor
+type MonitorConfig struct {
+	// Interval is the time between two probes, DefaultMonitorInterval by default
+	Interval time.Duration
+	// Timeout bounds the attempts made to probe a single endpoint, Interval by default
+	Timeout time.Duration
+	// Workers is the number of endpoints probed concurrently, DefaultHealthCheckWorkers by default
+	Workers int
+	// FailureThreshold is the number of consecutive failed probes before an endpoint is critical,
+	// DefaultFailureThreshold by default
+	FailureThreshold int
+	// SuccessThreshold is the number of consecutive successful probes before an endpoint is healthy,
+	// DefaultSuccessThreshold by default
+	SuccessThreshold int
+	// Clock is the real clock by default
+	Clock Clock
+	// History records the result of every probe when set
+	History IHealthHistory
+}
+
+// EndpointHealth is the health of an endpoint tracked by the monitor
+type EndpointHealth struct {
+	EndpointID string
+	URL        string
+	State      HealthState
+	// Since is the time the endpoint entered its state
+	Since time.Time
+	// LastChecked is the time of the last probe, zero before the first one
+	LastChecked time.Time
+	// Detail explains the result of the last probe when it was not healthy
+	Detail               string
+	ConsecutiveFailures  int
+	ConsecutiveSuccesses int
+}
+
+// HealthTransition is published to subscribers whenever an endpoint changes state
+type HealthTransition struct {
+	EndpointID string
+	URL        string
+	From       HealthState
+	To         HealthState
+	At         time.Time
+	// Detail explains the result of the probe causing the transition
+	Detail string
+	// Initial is set on the transition out of the unknown state an endpoint is registered in,
+	// it is the first assessment of the endpoint rather than a change of its health
+	Initial bool
+}
+
+// IHealthMonitor periodically probes registered endpoints and tracks their state
+type IHealthMonitor interface {
+	// Register starts monitoring an endpoint, registering it again with another url resets its state
+	Register(endpointID string, healthCheckURL string)
+	Unregister(endpointID string)
+	// Health returns the health of an endpoint, false when it is not registered
+	Health(endpointID string) (EndpointHealth, bool)
+	// Endpoints returns the health of every registered endpoint sorted by endpoint id
+	Endpoints() []EndpointHealth
+	// Subscribe calls fn with every transition from the monitor goroutine, fn must not block.
+	// The returned function cancels the subscription.
+	Subscribe(fn func(HealthTransition)) func()
+	// Run probes the endpoints every interval until ctx is done, it should be called once
+	Run(ctx context.Context)
+}
+
+type healthMonitor struct {
+	healthClient IHealthClient
+	config       MonitorConfig
+
+	mu          sync.Mutex
+	endpoints   map[string]*endpointState
+	subscribers map[int]func(HealthTransition)
+	nextID      int
+}
+
+// endpointState holds the counters of the state machine of an endpoint
+type endpointState struct {
+	health   EndpointHealth
+	unknowns int
+	// answers counts the consecutive probes answered, healthy or slow
+	answers int
+	// assessed is set once the endpoint left the unknown state it was registered in
+	assessed bool
+}
+
+// NewHealthMonitor returns a monitor probing endpoints with healthClient. Engines are told loading from failing
+// when healthClient probes them with their profile, see WithProbeProfiles.
+func NewHealthMonitor(healthClient IHealthClient, config MonitorConfig) IHealthMonitor {
+	if config.Interval <= 0 {
+		config.Interval = DefaultMonitorInterval
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = config.Interval
+	}
+	if config.Workers <= 0 {
+		config.Workers = DefaultHealthCheckWorkers
+	}
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = DefaultFailureThreshold
+	}
+	if config.SuccessThreshold <= 0 {
+		config.SuccessThreshold = DefaultSuccessThreshold
+	}
+	if config.Clock == nil {
+		config.Clock = realClock{}
+	}
+	return &healthMonitor{
+		healthClient: healthClient,
+		config:       config,
+		endpoints:    map[string]*endpointState{},
+		subscribers:  map[int]func(HealthTransition){},
+	}
+}
+
+func (m *healthMonitor) Register(endpointID string, healthCheckURL string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if endpoint, ok := m.endpoints[endpointID]; ok && endpoint.health.URL == healthCheckURL {
+		return
+	}
+	m.endpoints[endpointID] = &endpointState{health: EndpointHealth{
+		EndpointID: endpointID,
+		URL:        healthCheckURL,
+		State:      StateUnknown,
+		Since:      m.config.Clock.Now(),
+	}}
+}
+
+func (m *healthMonitor) Unregister(endpointID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.endpoints, endpointID)
+}
+
+func (m *healthMonitor) Health(endpointID string) (EndpointHealth, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	endpoint, ok := m.endpoints[endpointID]
+	if !ok {
+		return EndpointHealth{}, false
+	}
+	return endpoint.health, true
+}
+
+func (m *healthMonitor) Endpoints() []EndpointHealth {
+	m.mu.Lock()
+	endpoints := make([]EndpointHealth, 0, len(m.endpoints))
+	for _, endpoint := range m.endpoints {
+		endpoints = append(endpoints, endpoint.health)
+	}
+	m.mu.Unlock()
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].EndpointID < endpoints[j].EndpointID
+	})
+	return endpoints
+}
+
+func (m *healthMonitor) Subscribe(fn func(HealthTransition)) func() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := m.nextID
+	m.nextID++
+	m.subscribers[id] = fn
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.subscribers, id)
+	}
+}
+
+func (m *healthMonitor) Run(ctx context.Context) {
+	for {
+		m.probe(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.config.Clock.After(m.config.Interval):
+		}
+	}
+}
+
+// probe checks every registered endpoint once and publishes the resulting transitions
+func (m *healthMonitor) probe(ctx context.Context) {
+	m.mu.Lock()
+	targets := make(map[string]string, len(m.endpoints))
+	urls := make([]string, 0, len(m.endpoints))
+	for endpointID, endpoint := range m.endpoints {
+		targets[endpointID] = endpoint.health.URL
+		urls = append(urls, endpoint.health.URL)
+	}
+	m.mu.Unlock()
+	if len(urls) == 0 {
+		return
+	}
+
+	results := m.healthClient.CheckHealthBatch(ctx, urls, WithBatchWorkers(m.config.Workers), WithURLTimeout(m.config.Timeout))
+	if ctx.Err() != nil {
+		// interrupted probes say nothing about the endpoints
+		return
+	}
+
+	now := m.config.Clock.Now()
+	var transitions []HealthTransition
+	observations := make(map[string]HealthObservation, len(targets))
+	m.mu.Lock()
+	endpointIDs := make([]string, 0, len(targets))
+	for endpointID := range targets {
+		endpointIDs = append(endpointIDs, endpointID)
+	}
+	sort.Strings(endpointIDs)
+	for _, endpointID := range endpointIDs {
+		endpoint, ok := m.endpoints[endpointID]
+		if !ok || endpoint.health.URL != targets[endpointID] {
+			// unregistered or registered again while being probed
+			continue
+		}
+		result, ok := results[endpoint.health.URL]
+		if !ok {
+			continue
+		}
+		if transition, changed := m.apply(endpoint, result, now); changed {
+			transitions = append(transitions, transition)
+		}
+		observations[endpointID] = HealthObservation{Status: result.Status, Timestamp: now, Latency: result.Latency, Detail: result.Detail}
+	}
+	subscribers := make([]func(HealthTransition), 0, len(m.subscribers))
+	for _, fn := range m.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	m.mu.Unlock()
+
+	if m.config.History != nil {
+		for endpointID, observation := range observations {
+			// a failing store must not stop the monitoring
+			_ = m.config.History.Record(endpointID, observation)
+		}
+	}
+	for _, transition := range transitions {
+		for _, fn := range subscribers {
+			fn(transition)
+		}
+	}
+}
+
+// apply updates the counters of an endpoint with the result of a probe and moves it to its next state.
+// A single result never moves an endpoint between healthy and critical, it goes through degraded first.
+func (m *healthMonitor) apply(endpoint *endpointState, result HealthCheckResult, now time.Time) (HealthTransition, bool) {
+	health := &endpoint.health
+	health.LastChecked = now
+	health.Detail = result.Detail
+	switch result.Status {
+	case enum.HealthyStatusCode:
+		health.ConsecutiveSuccesses++
+		endpoint.answers++
+		health.ConsecutiveFailures, endpoint.unknowns = 0, 0
+	case enum.UnknownStatusCode:
+		endpoint.unknowns++
+		health.ConsecutiveSuccesses, endpoint.answers = 0, 0
+	case enum.DegradedStatusCode:
+		// a slow endpoint still serves requests, it is neither failing nor healthy. Failures between slow
+		// answers keep counting, only SuccessThreshold answers in a row end them.
+		endpoint.answers++
+		health.ConsecutiveSuccesses, endpoint.unknowns = 0, 0
+		if endpoint.answers >= m.config.SuccessThreshold {
+			health.ConsecutiveFailures = 0
+		}
+	default:
+		health.ConsecutiveFailures++
+		health.ConsecutiveSuccesses, endpoint.unknowns, endpoint.answers = 0, 0, 0
+	}
+
+	next := health.State
+	switch {
+	case health.ConsecutiveFailures >= m.config.FailureThreshold:
+		next = StateCritical
+	case result.Status == enum.DegradedStatusCode:
+		// the latency is already measured over a window of checks, it needs no threshold of its own
+		next = StateSlow
+	case health.ConsecutiveSuccesses >= m.config.SuccessThreshold:
+		next = StateHealthy
+	case endpoint.unknowns >= m.config.FailureThreshold:
+		next = StateUnknown
+	case health.State == StateHealthy && health.ConsecutiveFailures > 0,
+		health.State == StateCritical && health.ConsecutiveSuccesses > 0:
+		next = StateDegraded
+	}
+	if next == health.State {
+		return HealthTransition{}, false
+	}
+
+	transition := HealthTransition{
+		EndpointID: health.EndpointID,
+		URL:        health.URL,
+		From:       health.State,
+		To:         next,
+		At:         now,
+		Detail:     result.Detail,
+		Initial:    !endpoint.assessed,
+	}
+	health.State, health.Since, endpoint.assessed = next, now, true
+	return transition, true
+}
@@ -0,0 +1,359 @@
+package client_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nutanix-core/nai-api/iep/constants/enum"
+	"github.com/nutanix-core/nai-api/iep/internal/client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// fakeClock is a clock whose time only moves when advanced
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the time forward and fires the waiters that are due
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.waiters[:0]
+	for _, waiter := range c.waiters {
+		if waiter.at.After(c.now) {
+			pending = append(pending, waiter)
+			continue
+		}
+		waiter.ch <- c.now
+	}
+	c.waiters = pending
+}
+
+// Waiters returns the number of goroutines waiting for the time to pass
+func (c *fakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// batchHealthFunc adapts a batch check function to the client.IHealthClient interface
+type batchHealthFunc func(ctx context.Context, healthCheckURLs []string) map[string]client.HealthCheckResult
+
+func (f batchHealthFunc) CheckHealth(healthCheckURL string) enum.ServiceHealthStatusCode {
+	return f(context.Background(), []string{healthCheckURL})[healthCheckURL].Status
+}
+
+func (f batchHealthFunc) CheckHealthWithOptions(ctx context.Context, healthCheckURL string, _ client.HealthCheckOptions) client.HealthCheckResult {
+	return f(ctx, []string{healthCheckURL})[healthCheckURL]
+}
+
+func (f batchHealthFunc) CheckHealthBatch(ctx context.Context, healthCheckURLs []string, _ ...client.BatchOption) map[string]client.HealthCheckResult {
+	return f(ctx, healthCheckURLs)
+}
+
+func (f batchHealthFunc) Probe(ctx context.Context, baseURL string, _ client.ProbeProfile) client.ProbeResult {
+	if f(ctx, []string{baseURL})[baseURL].Status == enum.HealthyStatusCode {
+		return client.ProbeResult{State: client.ProbeReady, URL: baseURL}
+	}
+	return client.ProbeResult{State: client.ProbeFailed, URL: baseURL}
+}
+
+var _ = Describe("Test health monitor", func() {
+
+	const interval = 10 * time.Second
+
+	var (
+		clock       *fakeClock
+		mu          sync.Mutex
+		statuses    map[string]enum.ServiceHealthStatusCode
+		transitions []client.HealthTransition
+		monitor     client.IHealthMonitor
+		cancel      context.CancelFunc
+		stopped     chan struct{}
+	)
+
+	setStatus := func(healthCheckURL string, status enum.ServiceHealthStatusCode) {
+		mu.Lock()
+		defer mu.Unlock()
+		statuses[healthCheckURL] = status
+	}
+
+	recorded := func() []client.HealthTransition {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]client.HealthTransition(nil), transitions...)
+	}
+
+	states := func() []client.HealthState {
+		var states []client.HealthState
+		for _, transition := range recorded() {
+			states = append(states, transition.To)
+		}
+		return states
+	}
+
+	// tick lets the interval pass and waits for the monitor to be done probing
+	tick := func() {
+		clock.Advance(interval)
+		Eventually(clock.Waiters).Should(Equal(1))
+	}
+
+	start := func() {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		stopped = make(chan struct{})
+		go func() {
+			defer close(stopped)
+			monitor.Run(ctx)
+		}()
+		Eventually(clock.Waiters).Should(Equal(1))
+	}
+
+	BeforeEach(func() {
+		clock = newFakeClock()
+		statuses = map[string]enum.ServiceHealthStatusCode{}
+		transitions = nil
+		monitor = client.NewHealthMonitor(batchHealthFunc(func(_ context.Context, healthCheckURLs []string) map[string]client.HealthCheckResult {
+			mu.Lock()
+			defer mu.Unlock()
+			results := map[string]client.HealthCheckResult{}
+			for _, healthCheckURL := range healthCheckURLs {
+				status, ok := statuses[healthCheckURL]
+				if !ok {
+					status = enum.UnknownStatusCode
+				}
+				results[healthCheckURL] = client.HealthCheckResult{Status: status, Detail: string(status), Attempts: 1}
+			}
+			return results
+		}), client.MonitorConfig{Interval: interval, FailureThreshold: 3, SuccessThreshold: 2, Clock: clock})
+		monitor.Subscribe(func(transition client.HealthTransition) {
+			mu.Lock()
+			defer mu.Unlock()
+			transitions = append(transitions, transition)
+		})
+	})
+
+	AfterEach(func() {
+		if cancel != nil {
+			cancel()
+			Eventually(stopped).Should(BeClosed())
+			cancel = nil
+		}
+	})
+
+	It("should move slow endpoints to slow without failing them", func() {
+		monitor.Register("endpoint-1", "http://endpoint-1/health")
+		setStatus("http://endpoint-1/health", enum.HealthyStatusCode)
+		start()
+		tick()
+		setStatus("http://endpoint-1/health", enum.DegradedStatusCode)
+		tick()
+		Expect(states()).To(Equal([]client.HealthState{client.StateHealthy, client.StateSlow}))
+		health, _ := monitor.Health("endpoint-1")
+		Expect(health.ConsecutiveFailures).To(BeZero())
+		Expect(health.Detail).To(Equal(string(enum.DegradedStatusCode)))
+
+		tick()
+		tick()
+		Expect(states()).To(HaveLen(2))
+
+		setStatus("http://endpoint-1/health", enum.HealthyStatusCode)
+		tick()
+		tick()
+		Expect(states()).To(Equal([]client.HealthState{client.StateHealthy, client.StateSlow, client.StateHealthy}))
+	})
+
+	It("should keep counting failures between slow answers", func() {
+		monitor.Register("endpoint-1", "http://endpoint-1/health")
+		setStatus("http://endpoint-1/health", enum.HealthyStatusCode)
+		start()
+		tick()
+		for i := 0; i < 5; i++ {
+			if i%2 == 0 {
+				setStatus("http://endpoint-1/health", enum.CriticalStatusCode)
+			} else {
+				setStatus("http://endpoint-1/health", enum.DegradedStatusCode)
+			}
+			tick()
+		}
+		Expect(states()).To(Equal([]client.HealthState{client.StateHealthy, client.StateDegraded, client.StateSlow, client.StateCritical}))
+		health, _ := monitor.Health("endpoint-1")
+		Expect(health.ConsecutiveFailures).To(Equal(3))
+
+		// a single slow answer does not end the failures, SuccessThreshold of them do
+		setStatus("http://endpoint-1/health", enum.DegradedStatusCode)
+		tick()
+		Expect(states()).To(HaveLen(4))
+		tick()
+		Expect(states()).To(Equal([]client.HealthState{
+			client.StateHealthy, client.StateDegraded, client.StateSlow, client.StateCritical, client.StateSlow,
+		}))
+		health, _ = monitor.Health("endpoint-1")
+		Expect(health.ConsecutiveFailures).To(BeZero())
+	})
+
+	It("should move endpoints between states after the thresholds only", func() {
+		monitor.Register("endpoint-1", "http://endpoint-1/health")
+		setStatus("http://endpoint-1/health", enum.HealthyStatusCode)
+		start()
+		health, ok := monitor.Health("endpoint-1")
+		Expect(ok).To(BeTrue())
+		Expect(health.State).To(Equal(client.StateUnknown))
+		Expect(health.ConsecutiveSuccesses).To(Equal(1))
+
+		tick()
+		Expect(states()).To(Equal([]client.HealthState{client.StateHealthy}))
+
+		setStatus("http://endpoint-1/health", enum.CriticalStatusCode)
+		tick()
+		tick()
+		Expect(states()).To(Equal([]client.HealthState{client.StateHealthy, client.StateDegraded}))
+		tick()
+		Expect(states()).To(Equal([]client.HealthState{client.StateHealthy, client.StateDegraded, client.StateCritical}))
+
+		setStatus("http://endpoint-1/health", enum.HealthyStatusCode)
+		tick()
+		tick()
+		Expect(states()).To(Equal([]client.HealthState{
+			client.StateHealthy, client.StateDegraded, client.StateCritical, client.StateDegraded, client.StateHealthy,
+		}))
+
+		Expect(recorded()[0].From).To(Equal(client.StateUnknown))
+		Expect(recorded()[0].Initial).To(BeTrue())
+		last := recorded()[4]
+		Expect(last.EndpointID).To(Equal("endpoint-1"))
+		Expect(last.From).To(Equal(client.StateDegraded))
+		Expect(last.Initial).To(BeFalse())
+		Expect(last.At).To(Equal(clock.Now()))
+		health, _ = monitor.Health("endpoint-1")
+		Expect(health.Since).To(Equal(clock.Now()))
+		Expect(health.LastChecked).To(Equal(clock.Now()))
+	})
+
+	It("should not flip a flapping endpoint to critical", func() {
+		monitor.Register("endpoint-1", "http://endpoint-1/health")
+		setStatus("http://endpoint-1/health", enum.HealthyStatusCode)
+		start()
+		tick()
+		for i := 0; i < 6; i++ {
+			if i%2 == 0 {
+				setStatus("http://endpoint-1/health", enum.CriticalStatusCode)
+			} else {
+				setStatus("http://endpoint-1/health", enum.HealthyStatusCode)
+			}
+			tick()
+		}
+		Expect(states()).To(Equal([]client.HealthState{client.StateHealthy, client.StateDegraded}))
+	})
+
+	It("should report endpoints that cannot be probed as unknown", func() {
+		monitor.Register("endpoint-1", "http://endpoint-1/health")
+		setStatus("http://endpoint-1/health", enum.HealthyStatusCode)
+		start()
+		tick()
+
+		setStatus("http://endpoint-1/health", enum.UnknownStatusCode)
+		tick()
+		tick()
+		Expect(states()).To(Equal([]client.HealthState{client.StateHealthy}))
+		tick()
+		Expect(states()).To(Equal([]client.HealthState{client.StateHealthy, client.StateUnknown}))
+		Expect(recorded()[1].Detail).To(Equal(string(enum.UnknownStatusCode)))
+	})
+
+	It("should track registered endpoints only", func() {
+		monitor.Register("endpoint-2", "http://endpoint-2/health")
+		monitor.Register("endpoint-1", "http://endpoint-1/health")
+		setStatus("http://endpoint-1/health", enum.HealthyStatusCode)
+		setStatus("http://endpoint-2/health", enum.HealthyStatusCode)
+		start()
+		tick()
+		endpoints := monitor.Endpoints()
+		Expect(endpoints).To(HaveLen(2))
+		Expect(endpoints[0].EndpointID).To(Equal("endpoint-1"))
+		Expect(endpoints[0].State).To(Equal(client.StateHealthy))
+
+		// registering the same url again keeps the state, another url starts over
+		monitor.Register("endpoint-1", "http://endpoint-1/health")
+		health, _ := monitor.Health("endpoint-1")
+		Expect(health.State).To(Equal(client.StateHealthy))
+		monitor.Register("endpoint-1", "http://endpoint-1/v2/health")
+		health, _ = monitor.Health("endpoint-1")
+		Expect(health.State).To(Equal(client.StateUnknown))
+
+		monitor.Unregister("endpoint-2")
+		_, ok := monitor.Health("endpoint-2")
+		Expect(ok).To(BeFalse())
+		Expect(monitor.Endpoints()).To(HaveLen(1))
+	})
+
+	It("should stop publishing to unsubscribed functions", func() {
+		var count int
+		unsubscribe := monitor.Subscribe(func(client.HealthTransition) {
+			count++
+		})
+		monitor.Register("endpoint-1", "http://endpoint-1/health")
+		setStatus("http://endpoint-1/health", enum.HealthyStatusCode)
+		start()
+		tick()
+		Expect(count).To(Equal(1))
+
+		unsubscribe()
+		setStatus("http://endpoint-1/health", enum.CriticalStatusCode)
+		tick()
+		Expect(count).To(Equal(1))
+		Expect(states()).To(HaveLen(2))
+	})
+
+	It("should stop cleanly when the context is cancelled during a probe", func() {
+		probing := make(chan struct{})
+		monitor = client.NewHealthMonitor(batchHealthFunc(func(ctx context.Context, healthCheckURLs []string) map[string]client.HealthCheckResult {
+			close(probing)
+			<-ctx.Done()
+			return map[string]client.HealthCheckResult{healthCheckURLs[0]: {Status: enum.UnknownStatusCode}}
+		}), client.MonitorConfig{FailureThreshold: 1, Clock: clock})
+		monitor.Register("endpoint-1", "http://endpoint-1/health")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			monitor.Run(ctx)
+		}()
+		Eventually(probing).Should(BeClosed())
+		cancel()
+		Eventually(done).Should(BeClosed())
+
+		health, _ := monitor.Health("endpoint-1")
+		Expect(health.LastChecked).To(BeZero())
+		Expect(clock.Waiters()).To(BeZero())
+	})
+})
@@ -0,0 +1,803 @@
+package client
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nutanix-core/nai-api/iep/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// DefaultAlertWorkers is the number of alerts delivered concurrently
+	DefaultAlertWorkers = 4
+	// DefaultAlertQueueSize is the number of alerts waiting for delivery before new ones are dropped
+	DefaultAlertQueueSize = 256
+	// DefaultMaxAlertDeliveries is the number of deliveries kept in the delivery log
+	DefaultMaxAlertDeliveries = 1000
+	// DefaultAlertDeliveryAttempts is the number of attempts made to deliver an alert to a receiver
+	DefaultAlertDeliveryAttempts = 5
+	// DefaultRepeatCheckInterval is the time between two looks for firing alerts to repeat
+	DefaultRepeatCheckInterval = time.Minute
+
+	// SignatureHeader carries "sha256=" followed by the hex HMAC-SHA256 of the timestamp, a dot and the body
+	SignatureHeader = "X-NAI-Signature"
+	// TimestampHeader carries the unix time a webhook was signed at, receivers should reject stale webhooks
+	TimestampHeader = "X-NAI-Timestamp"
+	// DeliveryHeader carries the delivery id, it is the same for every attempt of a delivery
+	DeliveryHeader = "X-NAI-Delivery"
+)
+
+// ErrInvalidReceiver is wrapped by the errors returned for receivers that cannot be registered
+var ErrInvalidReceiver = errors.New("invalid webhook receiver")
+
+// AlertSeverity is the severity of an alert, derived from the state an endpoint entered
+type AlertSeverity string
+
+const (
+	// SeverityCritical is the severity of endpoints going critical
+	SeverityCritical AlertSeverity = "critical"
+	// SeverityWarning is the severity of endpoints going degraded, slow or unknown
+	SeverityWarning AlertSeverity = "warning"
+	// SeverityResolved is the severity of endpoints going back to healthy
+	SeverityResolved AlertSeverity = "resolved"
+)
+
+func severityOf(state HealthState) AlertSeverity {
+	switch state {
+	case StateCritical:
+		return SeverityCritical
+	case StateHealthy:
+		return SeverityResolved
+	default:
+		return SeverityWarning
+	}
+}
+
+// WebhookReceiver receives the alerts matching its filters, an empty filter matches every alert
+type WebhookReceiver struct {
+	ID   string `json:"id"`
+	Name string `json:"name" validate:"required"`
+	// URL is returned with the values of its query redacted once registered, they often authenticate the webhooks
+	URL string `json:"url" validate:"required,url"`
+	// Secret signs the webhooks when set, it is never returned once registered
+	Secret string `json:"secret,omitempty"`
+	// Signed tells whether the webhooks sent to the receiver are signed
+	Signed      bool            `json:"signed"`
+	EndpointIDs []string        `json:"endpointIds,omitempty"`
+	OwnerIDs    []string        `json:"ownerIds,omitempty"`
+	Severities  []AlertSeverity `json:"severities,omitempty"`
+	// DedupWindowSeconds drops the alerts of an endpoint entering a state it was already alerted for
+	// less than the window ago, zero disables deduplication
+	DedupWindowSeconds int64 `json:"dedupWindowSeconds,omitempty" validate:"gte=0"`
+	// RepeatIntervalSeconds sends the alert of an endpoint again while it stays critical, degraded, slow or unknown,
+	// zero disables repeats
+	RepeatIntervalSeconds int64 `json:"repeatIntervalSeconds,omitempty" validate:"gte=0"`
+}
+
+// matches tells whether an alert passes the filters of the receiver
+func (r WebhookReceiver) matches(event AlertEvent) bool {
+	return (len(r.EndpointIDs) == 0 || slices.Contains(r.EndpointIDs, event.EndpointID)) &&
+		(len(r.OwnerIDs) == 0 || slices.Contains(r.OwnerIDs, event.OwnerID)) &&
+		(len(r.Severities) == 0 || slices.Contains(r.Severities, event.Severity))
+}
+
+// AlertEvent is the JSON body of a webhook
+type AlertEvent struct {
+	EndpointID string        `json:"endpointId"`
+	OwnerID    string        `json:"ownerId,omitempty"`
+	Severity   AlertSeverity `json:"severity"`
+	From       HealthState   `json:"from"`
+	To         HealthState   `json:"to"`
+	// At is the time of the transition
+	At     time.Time `json:"at"`
+	Detail string    `json:"detail,omitempty"`
+	// Repeat is set on the reminders of an alert still firing
+	Repeat bool `json:"repeat"`
+}
+
+// DeliveryState is the state of the delivery of an alert to a receiver
+type DeliveryState string
+
+const (
+	// DeliveryPending is the state of deliveries queued or being attempted
+	DeliveryPending DeliveryState = "pending"
+	// DeliveryDelivered is the state of deliveries acknowledged with a 2xx response
+	DeliveryDelivered DeliveryState = "delivered"
+	// DeliveryFailed is the state of deliveries the retry policy gave up on
+	DeliveryFailed DeliveryState = "failed"
+	// DeliveryDropped is the state of alerts that could not be queued
+	DeliveryDropped DeliveryState = "dropped"
+	// DeliverySuppressed is the state of alerts dropped by the deduplication of the receiver
+	DeliverySuppressed DeliveryState = "suppressed"
+)
+
+// AlertDelivery is an entry of the delivery log
+type AlertDelivery struct {
+	ID         string        `json:"id"`
+	ReceiverID string        `json:"receiverId"`
+	Event      AlertEvent    `json:"event"`
+	State      DeliveryState `json:"state"`
+	Attempts   int           `json:"attempts"`
+	// StatusCode is the status code of the last response, zero when none was received
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	// CompletedAt is nil while the delivery is pending
+	CompletedAt *time.Time `json:"completedAt"`
+}
+
+// DeliveryFilter selects entries of the delivery log, zero fields match every entry
+type DeliveryFilter struct {
+	ReceiverID string
+	EndpointID string
+	State      DeliveryState
+	// Limit is the maximum number of entries returned
+	Limit int
+}
+
+// IAlertStore persists the webhook receivers, with their secrets, and the delivery log
+type IAlertStore interface {
+	SaveReceiver(receiver WebhookReceiver) error
+	// DeleteReceiver returns false when no receiver has the id
+	DeleteReceiver(receiverID string) (bool, error)
+	// ListReceivers returns the receivers with their secrets, sorted by id
+	ListReceivers() ([]WebhookReceiver, error)
+	// SaveDelivery adds a delivery to the delivery log, or replaces the entry with its id
+	SaveDelivery(delivery AlertDelivery) error
+	// ListDeliveries returns the entries of the delivery log matching filter, most recent first
+	ListDeliveries(filter DeliveryFilter) ([]AlertDelivery, error)
+	// TrimDeliveries deletes the entries of the delivery log past the most recent keep ones
+	TrimDeliveries(keep int) error
+}
+
+type memoryAlertStore struct {
+	mu         sync.RWMutex
+	receivers  map[string]WebhookReceiver
+	deliveries []AlertDelivery
+}
+
+// NewMemoryAlertStore returns a store keeping receivers and deliveries in memory, they are lost on restart
+func NewMemoryAlertStore() IAlertStore {
+	return &memoryAlertStore{receivers: map[string]WebhookReceiver{}}
+}
+
+func (s *memoryAlertStore) SaveReceiver(receiver WebhookReceiver) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.receivers[receiver.ID] = cloneReceiver(receiver)
+	return nil
+}
+
+func (s *memoryAlertStore) DeleteReceiver(receiverID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.receivers[receiverID]; !ok {
+		return false, nil
+	}
+	delete(s.receivers, receiverID)
+	return true, nil
+}
+
+func (s *memoryAlertStore) ListReceivers() ([]WebhookReceiver, error) {
+	s.mu.RLock()
+	receivers := make([]WebhookReceiver, 0, len(s.receivers))
+	for _, receiver := range s.receivers {
+		receivers = append(receivers, cloneReceiver(receiver))
+	}
+	s.mu.RUnlock()
+	sort.Slice(receivers, func(i, j int) bool {
+		return receivers[i].ID < receivers[j].ID
+	})
+	return receivers, nil
+}
+
+func (s *memoryAlertStore) SaveDelivery(delivery AlertDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// deliveries are updated shortly after they are logged, look for them from the most recent
+	for i := len(s.deliveries) - 1; i >= 0; i-- {
+		if s.deliveries[i].ID == delivery.ID {
+			s.deliveries[i] = delivery
+			return nil
+		}
+	}
+	s.deliveries = append(s.deliveries, delivery)
+	return nil
+}
+
+func (s *memoryAlertStore) ListDeliveries(filter DeliveryFilter) ([]AlertDelivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	deliveries := []AlertDelivery{}
+	for i := len(s.deliveries) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(deliveries) == filter.Limit {
+			break
+		}
+		delivery := s.deliveries[i]
+		if (filter.ReceiverID != "" && delivery.ReceiverID != filter.ReceiverID) ||
+			(filter.EndpointID != "" && delivery.Event.EndpointID != filter.EndpointID) ||
+			(filter.State != "" && delivery.State != filter.State) {
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+func (s *memoryAlertStore) TrimDeliveries(keep int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.deliveries) > keep {
+		s.deliveries = slices.Delete(s.deliveries, 0, len(s.deliveries)-keep)
+	}
+	return nil
+}
+
+type dbAlertStore struct {
+	db *gorm.DB
+}
+
+// NewDBAlertStore returns a store keeping receivers and deliveries in the database, they survive restarts and are shared by replicas
+func NewDBAlertStore(db *gorm.DB) IAlertStore {
+	return &dbAlertStore{db: db}
+}
+
+func (s *dbAlertStore) SaveReceiver(receiver WebhookReceiver) error {
+	severities := make([]string, 0, len(receiver.Severities))
+	for _, severity := range receiver.Severities {
+		severities = append(severities, string(severity))
+	}
+	return s.db.Create(&model.AlertWebhookReceiver{
+		BaseModel:             model.BaseModel{ID: receiver.ID},
+		Name:                  receiver.Name,
+		URL:                   receiver.URL,
+		Secret:                receiver.Secret,
+		EndpointIDs:           receiver.EndpointIDs,
+		OwnerIDs:              receiver.OwnerIDs,
+		Severities:            severities,
+		DedupWindowSeconds:    receiver.DedupWindowSeconds,
+		RepeatIntervalSeconds: receiver.RepeatIntervalSeconds,
+	}).Error
+}
+
+func (s *dbAlertStore) DeleteReceiver(receiverID string) (bool, error) {
+	result := s.db.Where("id = ?", receiverID).Delete(&model.AlertWebhookReceiver{})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (s *dbAlertStore) ListReceivers() ([]WebhookReceiver, error) {
+	var rows []model.AlertWebhookReceiver
+	if err := s.db.Order("id").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	receivers := make([]WebhookReceiver, 0, len(rows))
+	for _, row := range rows {
+		severities := make([]AlertSeverity, 0, len(row.Severities))
+		for _, severity := range row.Severities {
+			severities = append(severities, AlertSeverity(severity))
+		}
+		receivers = append(receivers, WebhookReceiver{
+			ID:                    row.ID,
+			Name:                  row.Name,
+			URL:                   row.URL,
+			Secret:                row.Secret,
+			Signed:                row.Secret != "",
+			EndpointIDs:           row.EndpointIDs,
+			OwnerIDs:              row.OwnerIDs,
+			Severities:            severities,
+			DedupWindowSeconds:    row.DedupWindowSeconds,
+			RepeatIntervalSeconds: row.RepeatIntervalSeconds,
+		})
+	}
+	return receivers, nil
+}
+
+func (s *dbAlertStore) SaveDelivery(delivery AlertDelivery) error {
+	return s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"state", "attempts", "status_code", "error", "completed_at"}),
+	}).Create(&model.AlertDelivery{
+		BaseModel:   model.BaseModel{ID: delivery.ID},
+		ReceiverID:  delivery.ReceiverID,
+		EndpointID:  delivery.Event.EndpointID,
+		OwnerID:     delivery.Event.OwnerID,
+		Severity:    string(delivery.Event.Severity),
+		FromState:   string(delivery.Event.From),
+		ToState:     string(delivery.Event.To),
+		EventAt:     delivery.Event.At,
+		Detail:      delivery.Event.Detail,
+		Repeat:      delivery.Event.Repeat,
+		State:       string(delivery.State),
+		Attempts:    delivery.Attempts,
+		StatusCode:  delivery.StatusCode,
+		Error:       delivery.Error,
+		LoggedAt:    delivery.CreatedAt,
+		CompletedAt: delivery.CompletedAt,
+	}).Error
+}
+
+func (s *dbAlertStore) ListDeliveries(filter DeliveryFilter) ([]AlertDelivery, error) {
+	query := s.db.Order("logged_at DESC, created_at DESC")
+	if filter.ReceiverID != "" {
+		query = query.Where("receiver_id = ?", filter.ReceiverID)
+	}
+	if filter.EndpointID != "" {
+		query = query.Where("endpoint_id = ?", filter.EndpointID)
+	}
+	if filter.State != "" {
+		query = query.Where("state = ?", string(filter.State))
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	var rows []model.AlertDelivery
+	if err := query.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	deliveries := make([]AlertDelivery, 0, len(rows))
+	for _, row := range rows {
+		deliveries = append(deliveries, AlertDelivery{
+			ID:         row.ID,
+			ReceiverID: row.ReceiverID,
+			Event: AlertEvent{
+				EndpointID: row.EndpointID,
+				OwnerID:    row.OwnerID,
+				Severity:   AlertSeverity(row.Severity),
+				From:       HealthState(row.FromState),
+				To:         HealthState(row.ToState),
+				At:         row.EventAt,
+				Detail:     row.Detail,
+				Repeat:     row.Repeat,
+			},
+			State:       DeliveryState(row.State),
+			Attempts:    row.Attempts,
+			StatusCode:  row.StatusCode,
+			Error:       row.Error,
+			CreatedAt:   row.LoggedAt,
+			CompletedAt: row.CompletedAt,
+		})
+	}
+	return deliveries, nil
+}
+
+// TrimDeliveries deletes the entries for good, trimmed entries are not kept soft deleted
+func (s *dbAlertStore) TrimDeliveries(keep int) error {
+	kept := s.db.Model(&model.AlertDelivery{}).Select("id").Order("logged_at DESC, created_at DESC").Limit(keep)
+	return s.db.Unscoped().Where("id NOT IN (?)", kept).Delete(&model.AlertDelivery{}).Error
+}
+
+// AlertNotifierConfig configures an alert notifier
+type AlertNotifierConfig struct {
+	// Workers is the number of alerts delivered concurrently, DefaultAlertWorkers by default
+	Workers int
+	// QueueSize is the number of alerts waiting for delivery, DefaultAlertQueueSize by default
+	QueueSize int
+	// Store keeps the receivers and the delivery log, NewMemoryAlertStore() by default
+	Store IAlertStore
+	// MaxDeliveries is the number of entries of the delivery log, DefaultMaxAlertDeliveries by default
+	MaxDeliveries int
+	// RetryPolicy is used for every delivery, NewRetryPolicy(DefaultAlertDeliveryAttempts, time.Second) by default
+	RetryPolicy RetryPolicy
+	// RepeatCheckInterval is the time between two looks for alerts to repeat, DefaultRepeatCheckInterval by default
+	RepeatCheckInterval time.Duration
+	// Owner returns the owner of an endpoint for the owner filters, alerts have no owner when nil
+	Owner func(endpointID string) string
+	// Clock is the real clock by default
+	Clock Clock
+}
+
+// IAlertNotifier sends webhooks to the registered receivers when endpoints change state
+type IAlertNotifier interface {
+	// AddReceiver validates and registers a receiver, it returns the receiver with its id, without its secret
+	// and with its url redacted
+	AddReceiver(receiver WebhookReceiver) (WebhookReceiver, error)
+	// RemoveReceiver returns false when no receiver has the id
+	RemoveReceiver(receiverID string) (bool, error)
+	// Receivers returns the registered receivers sorted by name, without their secrets and with their urls redacted
+	Receivers() ([]WebhookReceiver, error)
+	// Notify queues the alerts of a transition, it never blocks and can be subscribed to a health monitor.
+	// Initial transitions and recoveries of endpoints that were not firing send no alert, the initial state
+	// of an endpoint that is not healthy is still reminded of and resolved.
+	Notify(transition HealthTransition)
+	// Deliveries returns the entries of the delivery log matching filter, most recent first
+	Deliveries(filter DeliveryFilter) ([]AlertDelivery, error)
+	// Run delivers the queued alerts and repeats the firing ones until ctx is done, it should be called once.
+	// Alerts still queued when ctx is done stay pending.
+	Run(ctx context.Context)
+}
+
+type alertNotifier struct {
+	client IClient
+	config AlertNotifierConfig
+	queue  chan queuedAlert
+
+	mu sync.Mutex
+	// firing holds the last alert of the endpoints that are not healthy
+	firing map[string]AlertEvent
+	// notified holds the time an alert was last queued for every receiver, endpoint and state
+	notified map[alertKey]time.Time
+}
+
+type alertKey struct {
+	receiverID string
+	endpointID string
+	state      HealthState
+}
+
+type queuedAlert struct {
+	delivery *AlertDelivery
+	receiver WebhookReceiver
+}
+
+// NewAlertNotifier returns a notifier delivering webhooks with c
+func NewAlertNotifier(c IClient, config AlertNotifierConfig) IAlertNotifier {
+	if config.Workers <= 0 {
+		config.Workers = DefaultAlertWorkers
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultAlertQueueSize
+	}
+	if config.Store == nil {
+		config.Store = NewMemoryAlertStore()
+	}
+	if config.MaxDeliveries <= 0 {
+		config.MaxDeliveries = DefaultMaxAlertDeliveries
+	}
+	if config.RetryPolicy.MaxAttempts <= 0 {
+		config.RetryPolicy = NewRetryPolicy(DefaultAlertDeliveryAttempts, time.Second)
+	}
+	if config.RepeatCheckInterval <= 0 {
+		config.RepeatCheckInterval = DefaultRepeatCheckInterval
+	}
+	if config.Owner == nil {
+		config.Owner = func(string) string { return "" }
+	}
+	if config.Clock == nil {
+		config.Clock = realClock{}
+	}
+	return &alertNotifier{
+		client:   c,
+		config:   config,
+		queue:    make(chan queuedAlert, config.QueueSize),
+		firing:   map[string]AlertEvent{},
+		notified: map[alertKey]time.Time{},
+	}
+}
+
+// SignWebhook returns the value of the SignatureHeader of a webhook, receivers compute it again to
+// authenticate the webhooks they receive
+func SignWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (n *alertNotifier) AddReceiver(receiver WebhookReceiver) (WebhookReceiver, error) {
+	if receiver.Name == "" {
+		return WebhookReceiver{}, fmt.Errorf("%w: name is required", ErrInvalidReceiver)
+	}
+	u, err := url.Parse(receiver.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return WebhookReceiver{}, fmt.Errorf("%w: url must be an absolute http or https url", ErrInvalidReceiver)
+	}
+	for _, severity := range receiver.Severities {
+		if severity != SeverityCritical && severity != SeverityWarning && severity != SeverityResolved {
+			return WebhookReceiver{}, fmt.Errorf("%w: unknown severity %q", ErrInvalidReceiver, severity)
+		}
+	}
+	if receiver.DedupWindowSeconds < 0 || receiver.RepeatIntervalSeconds < 0 {
+		return WebhookReceiver{}, fmt.Errorf("%w: dedup window and repeat interval cannot be negative", ErrInvalidReceiver)
+	}
+
+	receiver.ID = uuid.NewString()
+	receiver.Signed = receiver.Secret != ""
+	if err := n.config.Store.SaveReceiver(receiver); err != nil {
+		return WebhookReceiver{}, fmt.Errorf("failed to save webhook receiver: %w", err)
+	}
+	return redactReceiver(receiver), nil
+}
+
+func (n *alertNotifier) RemoveReceiver(receiverID string) (bool, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	removed, err := n.config.Store.DeleteReceiver(receiverID)
+	if err != nil || !removed {
+		return false, err
+	}
+	for key := range n.notified {
+		if key.receiverID == receiverID {
+			delete(n.notified, key)
+		}
+	}
+	return true, nil
+}
+
+func (n *alertNotifier) Receivers() ([]WebhookReceiver, error) {
+	stored, err := n.config.Store.ListReceivers()
+	if err != nil {
+		return nil, err
+	}
+	receivers := make([]WebhookReceiver, 0, len(stored))
+	for _, receiver := range stored {
+		receivers = append(receivers, redactReceiver(receiver))
+	}
+	sort.Slice(receivers, func(i, j int) bool {
+		if receivers[i].Name != receivers[j].Name {
+			return receivers[i].Name < receivers[j].Name
+		}
+		return receivers[i].ID < receivers[j].ID
+	})
+	return receivers, nil
+}
+
+func (n *alertNotifier) Notify(transition HealthTransition) {
+	event := AlertEvent{
+		EndpointID: transition.EndpointID,
+		OwnerID:    n.config.Owner(transition.EndpointID),
+		Severity:   severityOf(transition.To),
+		From:       transition.From,
+		To:         transition.To,
+		At:         transition.At,
+		Detail:     transition.Detail,
+	}
+	now := n.config.Clock.Now()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	_, wasFiring := n.firing[event.EndpointID]
+	if event.Severity == SeverityResolved {
+		delete(n.firing, event.EndpointID)
+	} else {
+		n.firing[event.EndpointID] = event
+	}
+	if transition.Initial {
+		return
+	}
+	if event.Severity == SeverityResolved && !wasFiring && event.From != StateCritical && event.From != StateDegraded && event.From != StateSlow {
+		return
+	}
+	receivers, err := n.config.Store.ListReceivers()
+	if err != nil {
+		return
+	}
+	for _, receiver := range receivers {
+		if !receiver.matches(event) {
+			continue
+		}
+		key := alertKey{receiverID: receiver.ID, endpointID: event.EndpointID, state: event.To}
+		dedupWindow := time.Duration(receiver.DedupWindowSeconds) * time.Second
+		if last, ok := n.notified[key]; ok && now.Sub(last) < dedupWindow {
+			delivery := n.newDelivery(receiver.ID, event, now)
+			delivery.State, delivery.CompletedAt = DeliverySuppressed, &now
+			n.log(delivery)
+			continue
+		}
+		n.notified[key] = now
+		n.enqueue(receiver, event, now)
+	}
+}
+
+// repeat queues the reminders of the firing alerts due for one
+func (n *alertNotifier) repeat() {
+	now := n.config.Clock.Now()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	receivers, err := n.config.Store.ListReceivers()
+	if err != nil {
+		return
+	}
+	n.prune(receivers, now)
+	endpointIDs := make([]string, 0, len(n.firing))
+	for endpointID := range n.firing {
+		endpointIDs = append(endpointIDs, endpointID)
+	}
+	sort.Strings(endpointIDs)
+	for _, receiver := range receivers {
+		if receiver.RepeatIntervalSeconds == 0 {
+			continue
+		}
+		repeatInterval := time.Duration(receiver.RepeatIntervalSeconds) * time.Second
+		for _, endpointID := range endpointIDs {
+			event := n.firing[endpointID]
+			if !receiver.matches(event) {
+				continue
+			}
+			key := alertKey{receiverID: receiver.ID, endpointID: endpointID, state: event.To}
+			// receivers registered after the alert fired get a reminder too
+			if last, ok := n.notified[key]; ok && now.Sub(last) < repeatInterval {
+				continue
+			}
+			n.notified[key] = now
+			event.Repeat = true
+			n.enqueue(receiver, event, now)
+		}
+	}
+}
+
+// prune forgets the alerts queued longer ago than both the dedup window and the repeat interval of their receiver,
+// they no longer suppress nor delay any alert. It must be called with mu held
+func (n *alertNotifier) prune(receivers []WebhookReceiver, now time.Time) {
+	byID := make(map[string]WebhookReceiver, len(receivers))
+	for _, receiver := range receivers {
+		byID[receiver.ID] = receiver
+	}
+	for key, last := range n.notified {
+		receiver := byID[key.receiverID]
+		window := time.Duration(max(receiver.DedupWindowSeconds, receiver.RepeatIntervalSeconds)) * time.Second
+		if now.Sub(last) >= window {
+			delete(n.notified, key)
+		}
+	}
+}
+
+// enqueue logs a delivery and queues it, it must be called with mu held
+func (n *alertNotifier) enqueue(receiver WebhookReceiver, event AlertEvent, now time.Time) {
+	delivery := n.newDelivery(receiver.ID, event, now)
+	// the delivery is logged before it is queued, so that its outcome cannot be saved first
+	n.log(delivery)
+	select {
+	case n.queue <- queuedAlert{delivery: delivery, receiver: receiver}:
+	default:
+		delivery.State, delivery.Error, delivery.CompletedAt = DeliveryDropped, "delivery queue is full", &now
+		_ = n.config.Store.SaveDelivery(*delivery)
+	}
+}
+
+func (n *alertNotifier) newDelivery(receiverID string, event AlertEvent, now time.Time) *AlertDelivery {
+	return &AlertDelivery{
+		ID:         uuid.NewString(),
+		ReceiverID: receiverID,
+		Event:      event,
+		State:      DeliveryPending,
+		CreatedAt:  now,
+	}
+}
+
+// log adds a delivery to the delivery log and deletes the oldest entries past MaxDeliveries.
+// The delivery log is best effort, a delivery that cannot be logged is still attempted.
+func (n *alertNotifier) log(delivery *AlertDelivery) {
+	if err := n.config.Store.SaveDelivery(*delivery); err != nil {
+		return
+	}
+	_ = n.config.Store.TrimDeliveries(n.config.MaxDeliveries)
+}
+
+func (n *alertNotifier) Deliveries(filter DeliveryFilter) ([]AlertDelivery, error) {
+	return n.config.Store.ListDeliveries(filter)
+}
+
+func (n *alertNotifier) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < n.config.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case alert := <-n.queue:
+					n.deliver(ctx, alert)
+				}
+			}
+		}()
+	}
+	defer wg.Wait()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-n.config.Clock.After(n.config.RepeatCheckInterval):
+			n.repeat()
+		}
+	}
+}
+
+// deliver sends a webhook under the retry policy and records the outcome in the delivery log
+func (n *alertNotifier) deliver(ctx context.Context, alert queuedAlert) {
+	// the event of a delivery is never modified once logged
+	body, err := json.Marshal(alert.delivery.Event)
+	if err != nil {
+		n.complete(alert.delivery, 0, 0, err.Error())
+		return
+	}
+
+	req := NewRequest(http.MethodPost, alert.receiver.URL, body).SetHeaders(map[string]string{
+		"Content-Type": contentTypeJSON,
+		DeliveryHeader: alert.delivery.ID,
+	})
+	if alert.receiver.Secret != "" {
+		timestamp := strconv.FormatInt(n.config.Clock.Now().Unix(), 10)
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(SignatureHeader, SignWebhook(alert.receiver.Secret, timestamp, body))
+	}
+
+	result, err := n.client.DoWithRetry(ctx, req, n.config.RetryPolicy)
+	attempts, statusCode := 0, 0
+	if result != nil {
+		attempts, statusCode = result.Attempts, result.Response.StatusCode
+	}
+	var retryErr *RetryError
+	if errors.As(err, &retryErr) {
+		attempts = retryErr.Attempts
+	}
+	if err != nil {
+		n.complete(alert.delivery, attempts, statusCode, deliveryError(err))
+		return
+	}
+	n.complete(alert.delivery, attempts, statusCode, "")
+}
+
+// complete records the outcome of a delivery, it failed when errMsg is set
+func (n *alertNotifier) complete(delivery *AlertDelivery, attempts int, statusCode int, errMsg string) {
+	now := n.config.Clock.Now()
+	delivery.State = DeliveryDelivered
+	if errMsg != "" {
+		delivery.State = DeliveryFailed
+	}
+	delivery.Attempts, delivery.StatusCode, delivery.Error, delivery.CompletedAt = attempts, statusCode, errMsg, &now
+	_ = n.config.Store.SaveDelivery(*delivery)
+}
+
+// deliveryError describes why a delivery failed without the url of the receiver, which often embeds a token
+func deliveryError(err error) string {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Error()
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err.Error()
+	}
+	return err.Error()
+}
+
+// redactReceiver returns the receiver without its secret and with its url redacted, as returned to users
+func redactReceiver(receiver WebhookReceiver) WebhookReceiver {
+	receiver.Secret = ""
+	receiver.URL = redactReceiverURL(receiver.URL)
+	return cloneReceiver(receiver)
+}
+
+// redactReceiverURL drops the user info of a receiver url and replaces the values of its query with RedactedValue,
+// receivers such as chat webhooks are authenticated by a token in their url
+func redactReceiverURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	u.User = nil
+	if u.RawQuery != "" {
+		query := u.Query()
+		for key := range query {
+			query[key] = []string{RedactedValue}
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+func cloneReceiver(receiver WebhookReceiver) WebhookReceiver {
+	receiver.EndpointIDs = slices.Clone(receiver.EndpointIDs)
+	receiver.OwnerIDs = slices.Clone(receiver.OwnerIDs)
+	receiver.Severities = slices.Clone(receiver.Severities)
+	return receiver
+}
@@ -0,0 +1,364 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nutanix-core/nai-api/iep/internal/client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// webhook is a request received by the test receiver
+type webhook struct {
+	header http.Header
+	query  string
+	body   []byte
+	event  client.AlertEvent
+}
+
+var _ = Describe("Test alert notifications", func() {
+
+	var (
+		clock      *fakeClock
+		testServer *httptest.Server
+		mu         sync.Mutex
+		webhooks   []webhook
+		statuses   []int
+		notifier   client.IAlertNotifier
+		config     client.AlertNotifierConfig
+		cancel     context.CancelFunc
+		done       chan struct{}
+	)
+
+	received := func() []webhook {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]webhook(nil), webhooks...)
+	}
+
+	transition := func(endpointID string, from client.HealthState, to client.HealthState) client.HealthTransition {
+		return client.HealthTransition{EndpointID: endpointID, From: from, To: to, At: clock.Now(), Detail: "unexpected status code 503"}
+	}
+
+	start := func() {
+		notifier = client.NewAlertNotifier(client.NewClient(), config)
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		done = make(chan struct{})
+		go func() {
+			defer close(done)
+			notifier.Run(ctx)
+		}()
+	}
+
+	deliveries := func(filter client.DeliveryFilter) []client.AlertDelivery {
+		logged, err := notifier.Deliveries(filter)
+		Expect(err).ToNot(HaveOccurred())
+		return logged
+	}
+
+	addReceiver := func(receiver client.WebhookReceiver) client.WebhookReceiver {
+		if receiver.URL == "" {
+			receiver.URL = testServer.URL + "/hooks"
+		}
+		if receiver.Name == "" {
+			receiver.Name = "ops"
+		}
+		added, err := notifier.AddReceiver(receiver)
+		Expect(err).ToNot(HaveOccurred())
+		return added
+	}
+
+	BeforeEach(func() {
+		clock = newFakeClock()
+		webhooks, statuses = nil, nil
+		testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			var event client.AlertEvent
+			_ = json.Unmarshal(body, &event)
+			mu.Lock()
+			statusCode := http.StatusOK
+			if len(statuses) > 0 {
+				statusCode, statuses = statuses[0], statuses[1:]
+			}
+			webhooks = append(webhooks, webhook{header: r.Header.Clone(), query: r.URL.RawQuery, body: body, event: event})
+			mu.Unlock()
+			w.WriteHeader(statusCode)
+		}))
+		config = client.AlertNotifierConfig{
+			RetryPolicy: client.RetryPolicy{MaxAttempts: 3, InitialInterval: time.Millisecond},
+			Owner: func(endpointID string) string {
+				return "owner-of-" + endpointID
+			},
+			Clock: clock,
+		}
+		start()
+	})
+
+	AfterEach(func() {
+		cancel()
+		Eventually(done).Should(BeClosed())
+		testServer.Close()
+	})
+
+	It("should sign the webhooks of receivers with a secret", func() {
+		receiver := addReceiver(client.WebhookReceiver{Secret: "s3cr3t"})
+		Expect(receiver.ID).ToNot(BeEmpty())
+		Expect(receiver.Secret).To(BeEmpty())
+		Expect(receiver.Signed).To(BeTrue())
+		Expect(notifier.Receivers()).To(Equal([]client.WebhookReceiver{receiver}))
+
+		notifier.Notify(transition("endpoint-1", client.StateDegraded, client.StateCritical))
+		Eventually(received).Should(HaveLen(1))
+		hook := received()[0]
+		Expect(hook.event).To(Equal(client.AlertEvent{
+			EndpointID: "endpoint-1",
+			OwnerID:    "owner-of-endpoint-1",
+			Severity:   client.SeverityCritical,
+			From:       client.StateDegraded,
+			To:         client.StateCritical,
+			At:         clock.Now(),
+			Detail:     "unexpected status code 503",
+		}))
+		timestamp := hook.header.Get(client.TimestampHeader)
+		Expect(timestamp).To(Equal(strconv.FormatInt(clock.Now().Unix(), 10)))
+		Expect(hook.header.Get(client.SignatureHeader)).To(Equal(client.SignWebhook("s3cr3t", timestamp, hook.body)))
+		Expect(hook.header.Get("Content-Type")).To(Equal("application/json"))
+
+		Eventually(func() client.DeliveryState {
+			return deliveries(client.DeliveryFilter{})[0].State
+		}).Should(Equal(client.DeliveryDelivered))
+		delivery := deliveries(client.DeliveryFilter{})[0]
+		Expect(delivery.ID).To(Equal(hook.header.Get(client.DeliveryHeader)))
+		Expect(delivery.ReceiverID).To(Equal(receiver.ID))
+		Expect(delivery.Attempts).To(Equal(1))
+		Expect(delivery.StatusCode).To(Equal(http.StatusOK))
+		Expect(delivery.CompletedAt).ToNot(BeNil())
+	})
+
+	It("should redact the query of receiver urls but send the webhooks to the whole url", func() {
+		receiver := addReceiver(client.WebhookReceiver{URL: testServer.URL + "/hooks?token=abc&channel=ops"})
+		Expect(receiver.URL).To(Equal(testServer.URL + "/hooks?channel=REDACTED&token=REDACTED"))
+		Expect(notifier.Receivers()).To(ConsistOf(HaveField("URL", receiver.URL)))
+
+		notifier.Notify(transition("endpoint-1", client.StateDegraded, client.StateCritical))
+		Eventually(received).Should(HaveLen(1))
+		Expect(received()[0].query).To(Equal("token=abc&channel=ops"))
+	})
+
+	It("should keep the receivers and the delivery log in the store", func() {
+		cancel()
+		Eventually(done).Should(BeClosed())
+		config.Store = client.NewMemoryAlertStore()
+		start()
+		receiver := addReceiver(client.WebhookReceiver{Secret: "s3cr3t"})
+		notifier.Notify(transition("endpoint-1", client.StateDegraded, client.StateCritical))
+		Eventually(func() []client.AlertDelivery {
+			return deliveries(client.DeliveryFilter{State: client.DeliveryDelivered})
+		}).Should(HaveLen(1))
+
+		// a notifier restarted with the store sends signed webhooks to the same receivers
+		cancel()
+		Eventually(done).Should(BeClosed())
+		start()
+		Expect(notifier.Receivers()).To(Equal([]client.WebhookReceiver{receiver}))
+		Expect(deliveries(client.DeliveryFilter{})).To(ConsistOf(HaveField("State", client.DeliveryDelivered)))
+		notifier.Notify(transition("endpoint-2", client.StateDegraded, client.StateCritical))
+		Eventually(received).Should(HaveLen(2))
+		hook := received()[1]
+		Expect(hook.event.EndpointID).To(Equal("endpoint-2"))
+		Expect(hook.header.Get(client.SignatureHeader)).To(Equal(client.SignWebhook("s3cr3t", hook.header.Get(client.TimestampHeader), hook.body)))
+	})
+
+	It("should only keep the most recent deliveries", func() {
+		cancel()
+		Eventually(done).Should(BeClosed())
+		config.MaxDeliveries = 2
+		start()
+		addReceiver(client.WebhookReceiver{})
+		for _, endpointID := range []string{"endpoint-1", "endpoint-2", "endpoint-3"} {
+			notifier.Notify(transition(endpointID, client.StateDegraded, client.StateCritical))
+		}
+		Expect(deliveries(client.DeliveryFilter{})).To(HaveExactElements(
+			HaveField("Event.EndpointID", "endpoint-3"),
+			HaveField("Event.EndpointID", "endpoint-2"),
+		))
+	})
+
+	It("should not sign the webhooks of receivers without a secret", func() {
+		Expect(addReceiver(client.WebhookReceiver{}).Signed).To(BeFalse())
+		notifier.Notify(transition("endpoint-1", client.StateHealthy, client.StateDegraded))
+		Eventually(received).Should(HaveLen(1))
+		Expect(received()[0].header.Get(client.SignatureHeader)).To(BeEmpty())
+		Expect(received()[0].event.Severity).To(Equal(client.SeverityWarning))
+	})
+
+	It("should retry failed deliveries with backoff", func() {
+		mu.Lock()
+		statuses = []int{http.StatusServiceUnavailable, http.StatusBadGateway}
+		mu.Unlock()
+		addReceiver(client.WebhookReceiver{})
+		notifier.Notify(transition("endpoint-1", client.StateDegraded, client.StateCritical))
+		Eventually(func() []client.AlertDelivery {
+			return deliveries(client.DeliveryFilter{State: client.DeliveryDelivered})
+		}).Should(HaveLen(1))
+		Expect(received()).To(HaveLen(3))
+		Expect(deliveries(client.DeliveryFilter{})[0].Attempts).To(Equal(3))
+		Expect(received()[0].header.Get(client.DeliveryHeader)).To(Equal(received()[2].header.Get(client.DeliveryHeader)))
+	})
+
+	It("should log the deliveries that failed", func() {
+		mu.Lock()
+		statuses = []int{http.StatusBadRequest}
+		mu.Unlock()
+		addReceiver(client.WebhookReceiver{})
+		notifier.Notify(transition("endpoint-1", client.StateDegraded, client.StateCritical))
+		Eventually(func() []client.AlertDelivery {
+			return deliveries(client.DeliveryFilter{State: client.DeliveryFailed})
+		}).Should(HaveLen(1))
+		delivery := deliveries(client.DeliveryFilter{})[0]
+		Expect(delivery.Attempts).To(Equal(1))
+		Expect(delivery.StatusCode).To(Equal(http.StatusBadRequest))
+		Expect(delivery.Error).To(Equal("unexpected response status code 400"))
+	})
+
+	It("should only notify the receivers whose filters match", func() {
+		byEndpoint := addReceiver(client.WebhookReceiver{EndpointIDs: []string{"endpoint-2"}})
+		byOwner := addReceiver(client.WebhookReceiver{OwnerIDs: []string{"owner-of-endpoint-1"}})
+		bySeverity := addReceiver(client.WebhookReceiver{Severities: []client.AlertSeverity{client.SeverityResolved}})
+
+		notifier.Notify(transition("endpoint-1", client.StateDegraded, client.StateCritical))
+		notifier.Notify(transition("endpoint-2", client.StateDegraded, client.StateHealthy))
+
+		Expect(deliveries(client.DeliveryFilter{ReceiverID: byEndpoint.ID})).To(ConsistOf(
+			HaveField("Event.EndpointID", "endpoint-2"),
+		))
+		Expect(deliveries(client.DeliveryFilter{ReceiverID: byOwner.ID})).To(ConsistOf(
+			HaveField("Event.EndpointID", "endpoint-1"),
+		))
+		Expect(deliveries(client.DeliveryFilter{ReceiverID: bySeverity.ID})).To(ConsistOf(
+			HaveField("Event.Severity", client.SeverityResolved),
+		))
+		Expect(deliveries(client.DeliveryFilter{EndpointID: "endpoint-2"})).To(HaveLen(2))
+		Expect(deliveries(client.DeliveryFilter{Limit: 1})).To(HaveLen(1))
+		Eventually(received).Should(HaveLen(3))
+	})
+
+	It("should suppress alerts of flapping endpoints within the dedup window", func() {
+		addReceiver(client.WebhookReceiver{DedupWindowSeconds: 300})
+		notifier.Notify(transition("endpoint-1", client.StateHealthy, client.StateDegraded))
+		clock.Advance(time.Minute)
+		notifier.Notify(transition("endpoint-1", client.StateDegraded, client.StateHealthy))
+		clock.Advance(time.Minute)
+		notifier.Notify(transition("endpoint-1", client.StateHealthy, client.StateDegraded))
+		Expect(deliveries(client.DeliveryFilter{State: client.DeliverySuppressed})).To(HaveLen(1))
+
+		clock.Advance(5 * time.Minute)
+		notifier.Notify(transition("endpoint-1", client.StateDegraded, client.StateHealthy))
+		notifier.Notify(transition("endpoint-1", client.StateHealthy, client.StateDegraded))
+		Expect(deliveries(client.DeliveryFilter{State: client.DeliverySuppressed})).To(HaveLen(1))
+		Eventually(received).Should(HaveLen(4))
+	})
+
+	It("should repeat the alerts of endpoints that stay critical", func() {
+		addReceiver(client.WebhookReceiver{RepeatIntervalSeconds: 600})
+		notifier.Notify(transition("endpoint-1", client.StateDegraded, client.StateCritical))
+		Eventually(received).Should(HaveLen(1))
+
+		for i := 0; i < 10; i++ {
+			Eventually(clock.Waiters).Should(Equal(1))
+			clock.Advance(time.Minute)
+		}
+		Eventually(received).Should(HaveLen(2))
+		Expect(received()[1].event.Repeat).To(BeTrue())
+		Expect(received()[1].event.To).To(Equal(client.StateCritical))
+
+		notifier.Notify(transition("endpoint-1", client.StateCritical, client.StateDegraded))
+		notifier.Notify(transition("endpoint-1", client.StateDegraded, client.StateHealthy))
+		for i := 0; i < 20; i++ {
+			Eventually(clock.Waiters).Should(Equal(1))
+			clock.Advance(time.Minute)
+		}
+		Eventually(clock.Waiters).Should(Equal(1))
+		Expect(received()).To(HaveLen(4))
+	})
+
+	It("should not alert on the initial state of endpoints but remind and resolve it", func() {
+		addReceiver(client.WebhookReceiver{RepeatIntervalSeconds: 600})
+		initial := transition("endpoint-1", client.StateUnknown, client.StateHealthy)
+		initial.Initial = true
+		notifier.Notify(initial)
+		initial = transition("endpoint-2", client.StateUnknown, client.StateCritical)
+		initial.Initial = true
+		notifier.Notify(initial)
+		Expect(deliveries(client.DeliveryFilter{})).To(BeEmpty())
+
+		Eventually(clock.Waiters).Should(Equal(1))
+		clock.Advance(time.Minute)
+		Eventually(received).Should(HaveLen(1))
+		Expect(received()[0].event.EndpointID).To(Equal("endpoint-2"))
+		Expect(received()[0].event.Repeat).To(BeTrue())
+
+		notifier.Notify(transition("endpoint-2", client.StateCritical, client.StateHealthy))
+		Eventually(received).Should(HaveLen(2))
+		Expect(received()[1].event.Severity).To(Equal(client.SeverityResolved))
+	})
+
+	It("should only resolve endpoints that were firing", func() {
+		addReceiver(client.WebhookReceiver{})
+		notifier.Notify(transition("endpoint-1", client.StateUnknown, client.StateHealthy))
+		Expect(deliveries(client.DeliveryFilter{})).To(BeEmpty())
+
+		notifier.Notify(transition("endpoint-1", client.StateHealthy, client.StateUnknown))
+		notifier.Notify(transition("endpoint-1", client.StateUnknown, client.StateHealthy))
+		notifier.Notify(transition("endpoint-2", client.StateDegraded, client.StateHealthy))
+		Expect(deliveries(client.DeliveryFilter{})).To(ConsistOf(
+			HaveField("Event.Severity", client.SeverityWarning),
+			HaveField("Event.Severity", client.SeverityResolved),
+			HaveField("Event.EndpointID", "endpoint-2"),
+		))
+		Eventually(received).Should(HaveLen(3))
+	})
+
+	It("should drop alerts when the queue is full", func() {
+		cancel()
+		Eventually(done).Should(BeClosed())
+		config.QueueSize = 1
+		start()
+		cancel()
+		Eventually(done).Should(BeClosed())
+
+		addReceiver(client.WebhookReceiver{})
+		notifier.Notify(transition("endpoint-1", client.StateDegraded, client.StateCritical))
+		notifier.Notify(transition("endpoint-2", client.StateDegraded, client.StateCritical))
+		dropped := deliveries(client.DeliveryFilter{State: client.DeliveryDropped})
+		Expect(dropped).To(HaveLen(1))
+		Expect(dropped[0].Event.EndpointID).To(Equal("endpoint-2"))
+		Expect(dropped[0].Error).To(Equal("delivery queue is full"))
+	})
+
+	It("should reject invalid receivers", func() {
+		_, err := notifier.AddReceiver(client.WebhookReceiver{Name: "ops", URL: "ftp://example.com/hooks"})
+		Expect(err).To(MatchError(client.ErrInvalidReceiver))
+		_, err = notifier.AddReceiver(client.WebhookReceiver{Name: "ops", URL: testServer.URL, Severities: []client.AlertSeverity{"fatal"}})
+		Expect(err).To(MatchError(client.ErrInvalidReceiver))
+		_, err = notifier.AddReceiver(client.WebhookReceiver{URL: testServer.URL})
+		Expect(err).To(MatchError(client.ErrInvalidReceiver))
+		Expect(notifier.Receivers()).To(BeEmpty())
+	})
+
+	It("should stop notifying removed receivers", func() {
+		receiver := addReceiver(client.WebhookReceiver{})
+		Expect(notifier.RemoveReceiver(receiver.ID)).To(BeTrue())
+		Expect(notifier.RemoveReceiver(receiver.ID)).To(BeFalse())
+		notifier.Notify(transition("endpoint-1", client.StateDegraded, client.StateCritical))
+		Expect(deliveries(client.DeliveryFilter{})).To(BeEmpty())
+	})
+})
@@ -0,0 +1,232 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nutanix-core/nai-api/iep/constants"
+	"github.com/nutanix-core/nai-api/iep/constants/enum"
+)
+
+// DefaultLoadingGracePeriod is how long an engine may stay loading before it is failed, unless its profile sets another one
+const DefaultLoadingGracePeriod = 15 * time.Minute
+
+// ProbeState is the readiness of an inference engine
+type ProbeState string
+
+const (
+	// ProbeReady means the engine serves requests
+	ProbeReady ProbeState = "Ready"
+	// ProbeLoading means the engine is up but its model is not loaded yet
+	ProbeLoading ProbeState = "Loading"
+	// ProbeFailed means the engine cannot be reached or reported an error
+	ProbeFailed ProbeState = "Failed"
+)
+
+// ProbeResult is the outcome of probing an engine
+type ProbeResult struct {
+	State ProbeState
+	// Detail explains a state other than ready
+	Detail string
+	// URL is the last url probed
+	URL string
+	// StatusCode is the status code of the last response, zero when none was received
+	StatusCode int
+}
+
+// ProbeProfile describes how the readiness of an engine is probed
+type ProbeProfile struct {
+	Name string
+	// Paths are probed in turn relative to the base url of the endpoint, the engine is ready when every path is
+	Paths []string
+	// ModelPaths are the readiness paths of a single model, %s stands for the model name, see ForModel
+	ModelPaths []string
+	// LoadingStatusCodes are the status codes the engine answers while its model is loading
+	LoadingStatusCodes []int
+	// LoadingGracePeriod is how long a path may stay loading, it is failed afterwards.
+	// DefaultLoadingGracePeriod is used when zero.
+	LoadingGracePeriod time.Duration
+}
+
+// WithProbeProfiles checks the health check urls profile returns a profile for like Probe, the url being the base url
+// of the engine. A ready engine is healthy, a loading one unknown and a failed one critical. The expected status
+// codes and body matcher of HealthCheckOptions do not apply to these urls.
+func WithProbeProfiles(profile func(healthCheckURL string) (ProbeProfile, bool)) HealthClientOption {
+	return func(ic *healthClient) {
+		ic.probeProfile = profile
+	}
+}
+
+// ProbeProfileFor returns the probe profile of engine, engines without a profile are probed on the base url itself
+func ProbeProfileFor(engine enum.Engine) ProbeProfile {
+	switch engine {
+	case enum.TGIEngine:
+		// the TGI router answers 503 until its shards are ready
+		return ProbeProfile{Name: string(engine), Paths: []string{"/health"}, LoadingStatusCodes: []int{http.StatusServiceUnavailable}}
+	case enum.VLLMEngine:
+		// vLLM only listens once the model is loaded, any other status code is an engine failure
+		return ProbeProfile{Name: string(engine), Paths: []string{"/health"}}
+	case enum.NIMEngine:
+		return ProbeProfile{Name: string(engine), Paths: []string{"/v1/health/ready"}, LoadingStatusCodes: []int{http.StatusServiceUnavailable}}
+	case enum.KServeV2Engine:
+		return ProbeProfile{
+			Name:       string(engine),
+			Paths:      []string{"/v2/health/ready"},
+			ModelPaths: []string{"/v2/models/%s/ready"},
+			// servers answer 400 or 404 for models that are not loaded yet
+			LoadingStatusCodes: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusServiceUnavailable},
+		}
+	default:
+		return ProbeProfile{Name: string(engine), Paths: []string{""}}
+	}
+}
+
+// ForModel returns a copy of the profile probing the readiness of modelName after the engine readiness,
+// for engines serving several models. The profile is returned as is when modelName is empty.
+func (p ProbeProfile) ForModel(modelName string) ProbeProfile {
+	if modelName == "" || len(p.ModelPaths) == 0 {
+		return p
+	}
+	paths := slices.Clone(p.Paths)
+	for _, path := range p.ModelPaths {
+		paths = append(paths, fmt.Sprintf(path, url.PathEscape(modelName)))
+	}
+	p.Paths, p.ModelPaths = paths, nil
+	return p
+}
+
+// Probe probes the readiness of the engine serving at baseURL with profile.
+// Unlike CheckHealth it makes a single attempt per path and tells a loading model from a failed engine.
+// A path loading for longer than the grace period of the profile is failed.
+func (ic *healthClient) Probe(ctx context.Context, baseURL string, profile ProbeProfile) ProbeResult {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return ic.probe(ctx, baseURL, profile, nil, constants.ClientTimeout*time.Second)
+}
+
+// probeHealth checks a health check url with a probe profile, see WithProbeProfiles
+func (ic *healthClient) probeHealth(ctx context.Context, baseURL string, profile ProbeProfile, options HealthCheckOptions) (enum.ServiceHealthStatusCode, time.Duration, error) {
+	start := time.Now()
+	result := ic.probe(ctx, baseURL, profile, options.Headers, options.Timeout)
+	latency := time.Since(start)
+	switch {
+	case result.State == ProbeReady:
+		return enum.HealthyStatusCode, latency, nil
+	case result.State == ProbeLoading:
+		// the engine is up but cannot tell the health of its model yet
+		return enum.UnknownStatusCode, latency, fmt.Errorf("loading: %s", result.Detail)
+	case result.StatusCode == 0:
+		return enum.CriticalStatusCode, 0, errors.New(result.Detail)
+	default:
+		return enum.CriticalStatusCode, latency, errors.New(result.Detail)
+	}
+}
+
+// probe probes the paths of profile in turn until one is not ready
+func (ic *healthClient) probe(ctx context.Context, baseURL string, profile ProbeProfile, headers map[string]string, timeout time.Duration) ProbeResult {
+	result := ProbeResult{State: ProbeReady, URL: baseURL}
+	for _, path := range profile.Paths {
+		result = ic.loadingGrace(profile, ic.probePath(ctx, strings.TrimSuffix(baseURL, "/")+path, profile, headers, timeout))
+		if result.State != ProbeReady {
+			return result
+		}
+	}
+	return result
+}
+
+// loadingGrace fails a result loading for longer than the grace period of profile
+func (ic *healthClient) loadingGrace(profile ProbeProfile, result ProbeResult) ProbeResult {
+	now := ic.clock.Now()
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	if result.State != ProbeLoading {
+		delete(ic.loadingSince, result.URL)
+		return result
+	}
+	since, ok := ic.loadingSince[result.URL]
+	if !ok {
+		ic.loadingSince[result.URL] = now
+		return result
+	}
+	grace := profile.LoadingGracePeriod
+	if grace <= 0 {
+		grace = DefaultLoadingGracePeriod
+	}
+	if now.Sub(since) > grace {
+		result.State = ProbeFailed
+		result.Detail = fmt.Sprintf("loading for longer than %s: %s", grace, result.Detail)
+	}
+	return result
+}
+
+func (ic *healthClient) probePath(ctx context.Context, probeURL string, profile ProbeProfile, headers map[string]string, timeout time.Duration) ProbeResult {
+	result := ProbeResult{State: ProbeFailed, URL: probeURL}
+	req, err := http.NewRequest(http.MethodGet, probeURL, nil)
+	if err != nil {
+		result.Detail = err.Error()
+		return result
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	// the timeout is set per request, the client may be shared with requests needing another one
+	ctx = WithRequestTimeout(ctx, timeout)
+	resp, body, err := ic.client.Do(ctx, req)
+	if err != nil {
+		result.Detail = err.Error()
+		return result
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	result.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if slices.Contains(profile.LoadingStatusCodes, resp.StatusCode) {
+			result.State = ProbeLoading
+		}
+		result.Detail = fmt.Sprintf("unexpected status code %d", resp.StatusCode)
+		if msg := errorMessage(body); msg != "" {
+			result.Detail += ": " + msg
+		}
+		return result
+	}
+
+	// some engines answer 200 while loading and tell in the body
+	if ready, ok := readinessBody(body); ok && !ready {
+		result.State = ProbeLoading
+		result.Detail = "not ready"
+		if msg := errorMessage(body); msg != "" {
+			result.Detail += ": " + msg
+		}
+		return result
+	}
+	result.State = ProbeReady
+	return result
+}
+
+// readinessBody reads the ready or live flag of a JSON readiness body, false when the body has none
+func readinessBody(body []byte) (bool, bool) {
+	var payload struct {
+		Ready *bool `json:"ready"`
+		Live  *bool `json:"live"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return false, false
+	}
+	switch {
+	case payload.Ready != nil:
+		return *payload.Ready, true
+	case payload.Live != nil:
+		return *payload.Live, true
+	default:
+		return false, false
+	}
+}
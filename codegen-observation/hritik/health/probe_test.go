@@ -0,0 +1,196 @@
+package client_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/nutanix-core/nai-api/iep/constants/enum"
+	"github.com/nutanix-core/nai-api/iep/internal/client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Test engine probes", func() {
+
+	var (
+		testServer   *httptest.Server
+		healthClient client.IHealthClient
+		responses    map[string]func(w http.ResponseWriter)
+		ctx          = context.Background()
+	)
+
+	respond := func(statusCode int, body string) func(w http.ResponseWriter) {
+		return func(w http.ResponseWriter) {
+			w.WriteHeader(statusCode)
+			_, _ = w.Write([]byte(body))
+		}
+	}
+
+	BeforeEach(func() {
+		responses = map[string]func(w http.ResponseWriter){}
+		testServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			response, ok := responses[r.URL.EscapedPath()]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			response(w)
+		}))
+		healthClient = client.NewHealthClient(client.NewClient())
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	Context("Test TGI probes", func() {
+		It("should be ready once the health path answers 200", func() {
+			responses["/health"] = respond(http.StatusOK, "")
+			result := healthClient.Probe(ctx, testServer.URL+"/", client.ProbeProfileFor(enum.TGIEngine))
+			Expect(result.State).To(Equal(client.ProbeReady))
+			Expect(result.URL).To(Equal(testServer.URL + "/health"))
+			Expect(result.StatusCode).To(Equal(http.StatusOK))
+		})
+
+		It("should be loading while the shards are not ready", func() {
+			responses["/health"] = respond(http.StatusServiceUnavailable, "")
+			result := healthClient.Probe(ctx, testServer.URL, client.ProbeProfileFor(enum.TGIEngine))
+			Expect(result.State).To(Equal(client.ProbeLoading))
+			Expect(result.Detail).To(Equal("unexpected status code 503"))
+		})
+
+		It("should fail once the shards stay unavailable longer than the grace period", func() {
+			clock := newFakeClock()
+			healthClient = client.NewHealthClient(client.NewClient(), client.WithHealthClock(clock))
+			responses["/health"] = respond(http.StatusServiceUnavailable, "")
+			profile := client.ProbeProfileFor(enum.TGIEngine)
+			Expect(healthClient.Probe(ctx, testServer.URL, profile).State).To(Equal(client.ProbeLoading))
+			clock.Advance(client.DefaultLoadingGracePeriod)
+			Expect(healthClient.Probe(ctx, testServer.URL, profile).State).To(Equal(client.ProbeLoading))
+
+			clock.Advance(time.Second)
+			result := healthClient.Probe(ctx, testServer.URL, profile)
+			Expect(result.State).To(Equal(client.ProbeFailed))
+			Expect(result.Detail).To(Equal("loading for longer than 15m0s: unexpected status code 503"))
+
+			// the grace period starts again once the engine stopped loading
+			responses["/health"] = respond(http.StatusOK, "")
+			Expect(healthClient.Probe(ctx, testServer.URL, profile).State).To(Equal(client.ProbeReady))
+			responses["/health"] = respond(http.StatusServiceUnavailable, "")
+			Expect(healthClient.Probe(ctx, testServer.URL, profile).State).To(Equal(client.ProbeLoading))
+		})
+
+		It("should fail on other errors", func() {
+			responses["/health"] = respond(http.StatusInternalServerError, `{"error": "shard 0 died"}`)
+			result := healthClient.Probe(ctx, testServer.URL, client.ProbeProfileFor(enum.TGIEngine))
+			Expect(result.State).To(Equal(client.ProbeFailed))
+			Expect(result.Detail).To(Equal("unexpected status code 500: shard 0 died"))
+		})
+	})
+
+	Context("Test vLLM probes", func() {
+		It("should fail when the engine is unavailable", func() {
+			responses["/health"] = respond(http.StatusServiceUnavailable, "")
+			result := healthClient.Probe(ctx, testServer.URL, client.ProbeProfileFor(enum.VLLMEngine))
+			Expect(result.State).To(Equal(client.ProbeFailed))
+			Expect(result.StatusCode).To(Equal(http.StatusServiceUnavailable))
+		})
+
+		It("should fail when the engine cannot be reached", func() {
+			testServer.Close()
+			result := healthClient.Probe(ctx, testServer.URL, client.ProbeProfileFor(enum.VLLMEngine))
+			Expect(result.State).To(Equal(client.ProbeFailed))
+			Expect(result.StatusCode).To(BeZero())
+			Expect(result.Detail).ToNot(BeEmpty())
+		})
+	})
+
+	Context("Test NIM probes", func() {
+		It("should probe the readiness path", func() {
+			responses["/v1/health/ready"] = respond(http.StatusServiceUnavailable, `{"object": "health.response", "message": "Service is not ready."}`)
+			result := healthClient.Probe(ctx, testServer.URL, client.ProbeProfileFor(enum.NIMEngine))
+			Expect(result.State).To(Equal(client.ProbeLoading))
+			Expect(result.Detail).To(Equal("unexpected status code 503: Service is not ready."))
+
+			responses["/v1/health/ready"] = respond(http.StatusOK, `{"object": "health.response", "message": "Service is ready."}`)
+			result = healthClient.Probe(ctx, testServer.URL, client.ProbeProfileFor(enum.NIMEngine))
+			Expect(result.State).To(Equal(client.ProbeReady))
+		})
+	})
+
+	Context("Test KServe v2 probes", func() {
+		It("should probe the server then the model readiness", func() {
+			responses["/v2/health/ready"] = respond(http.StatusOK, `{"ready": true}`)
+			profile := client.ProbeProfileFor(enum.KServeV2Engine).ForModel("llama 3")
+
+			result := healthClient.Probe(ctx, testServer.URL, profile)
+			Expect(result.State).To(Equal(client.ProbeLoading))
+			Expect(result.URL).To(Equal(testServer.URL + "/v2/models/llama%203/ready"))
+
+			responses["/v2/models/llama%203/ready"] = respond(http.StatusOK, `{"name": "llama 3", "ready": false}`)
+			result = healthClient.Probe(ctx, testServer.URL, profile)
+			Expect(result.State).To(Equal(client.ProbeLoading))
+			Expect(result.Detail).To(Equal("not ready"))
+
+			responses["/v2/models/llama%203/ready"] = respond(http.StatusOK, `{"name": "llama 3", "ready": true}`)
+			Expect(healthClient.Probe(ctx, testServer.URL, profile).State).To(Equal(client.ProbeReady))
+		})
+
+		It("should only probe the server readiness without model", func() {
+			responses["/v2/health/ready"] = respond(http.StatusOK, `{"ready": true}`)
+			profile := client.ProbeProfileFor(enum.KServeV2Engine)
+			Expect(profile.ForModel("")).To(Equal(profile))
+			result := healthClient.Probe(ctx, testServer.URL, profile)
+			Expect(result.State).To(Equal(client.ProbeReady))
+			Expect(result.URL).To(Equal(testServer.URL + "/v2/health/ready"))
+		})
+
+		It("should stop at the server readiness", func() {
+			responses["/v2/health/ready"] = respond(http.StatusOK, `{"ready": false}`)
+			result := healthClient.Probe(ctx, testServer.URL, client.ProbeProfileFor(enum.KServeV2Engine).ForModel("llama"))
+			Expect(result.State).To(Equal(client.ProbeLoading))
+			Expect(result.URL).To(Equal(testServer.URL + "/v2/health/ready"))
+		})
+	})
+
+	It("should check the health of urls with a profile like Probe", func() {
+		clock := newFakeClock()
+		profile := client.ProbeProfileFor(enum.TGIEngine)
+		profile.LoadingGracePeriod = time.Minute
+		healthClient = client.NewHealthClient(client.NewClient(), client.WithHealthClock(clock),
+			client.WithProbeProfiles(func(healthCheckURL string) (client.ProbeProfile, bool) {
+				return profile, healthCheckURL == testServer.URL
+			}))
+		check := func() client.HealthCheckResult {
+			return healthClient.CheckHealthWithOptions(ctx, testServer.URL, client.HealthCheckOptions{Attempts: 1})
+		}
+
+		responses["/health"] = respond(http.StatusOK, "")
+		Expect(check().Status).To(Equal(enum.HealthyStatusCode))
+		Expect(healthClient.CheckHealth(testServer.URL)).To(Equal(enum.HealthyStatusCode))
+
+		responses["/health"] = respond(http.StatusServiceUnavailable, "")
+		result := check()
+		Expect(result.Status).To(Equal(enum.UnknownStatusCode))
+		Expect(result.Detail).To(Equal("loading: unexpected status code 503"))
+		clock.Advance(2 * time.Minute)
+		result = check()
+		Expect(result.Status).To(Equal(enum.CriticalStatusCode))
+		Expect(result.Detail).To(Equal("loading for longer than 1m0s: unexpected status code 503"))
+
+		responses["/health"] = respond(http.StatusInternalServerError, "")
+		Expect(check().Status).To(Equal(enum.CriticalStatusCode))
+
+		// urls without a profile are checked as is
+		Expect(healthClient.CheckHealthWithOptions(ctx, testServer.URL+"/health", client.HealthCheckOptions{Attempts: 1}).Status).To(Equal(enum.CriticalStatusCode))
+	})
+
+	It("should probe the base url of engines without a profile", func() {
+		responses["/v2/health/live"] = respond(http.StatusOK, `{"live": true}`)
+		result := healthClient.Probe(ctx, testServer.URL+"/v2/health/live", client.ProbeProfileFor("custom"))
+		Expect(result.State).To(Equal(client.ProbeReady))
+		Expect(result.URL).To(Equal(testServer.URL + "/v2/health/live"))
+	})
+})
@@ -0,0 +1,236 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	e "github.com/nutanix-core/nai-api/common/errors"
+	"github.com/nutanix-core/nai-api/iep/constants/enum"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const (
+	// DefaultSyntheticPrompt is the prompt sent by synthetic probes unless configured otherwise
+	DefaultSyntheticPrompt = "Reply with OK."
+	// DefaultSyntheticMaxTokens keeps synthetic probes cheap
+	DefaultSyntheticMaxTokens = 1
+	// DefaultLatencyBudget is the time a synthetic probe waits for a response
+	DefaultLatencyBudget = 10 * time.Second
+	// DefaultSyntheticInterval is the minimum time between two synthetic probes of an endpoint
+	DefaultSyntheticInterval = 5 * time.Minute
+)
+
+// InferenceRunner sends inference requests to endpoints the way inference requests of users are sent.
+// Requests must return once ctx is done.
+type InferenceRunner interface {
+	Completion(ctx context.Context, completionRequest openai.CompletionRequest, engine enum.Engine) (openai.CompletionResponse, *e.Error)
+	ChatCompletion(ctx context.Context, chatRequest openai.ChatCompletionRequest, engine enum.Engine) (openai.ChatCompletionResponse, *e.Error)
+}
+
+// SyntheticProbeMode selects the inference API used by a synthetic probe
+type SyntheticProbeMode string
+
+const (
+	// SyntheticChat sends a chat completion request
+	SyntheticChat SyntheticProbeMode = "chat"
+	// SyntheticCompletion sends a completion request
+	SyntheticCompletion SyntheticProbeMode = "completion"
+)
+
+// SyntheticProbeConfig configures the synthetic probe of an endpoint
+type SyntheticProbeConfig struct {
+	// Model is the model name the requests are routed with
+	Model  string
+	Engine enum.Engine
+	// Mode is SyntheticChat by default
+	Mode SyntheticProbeMode
+	// Prompt is DefaultSyntheticPrompt by default
+	Prompt string
+	// MaxTokens is DefaultSyntheticMaxTokens by default
+	MaxTokens int
+	// LatencyBudget is the time allowed for a well formed response, the request is cancelled afterwards.
+	// DefaultLatencyBudget by default.
+	LatencyBudget time.Duration
+	// Interval is the minimum time between two probes, DefaultSyntheticInterval by default
+	Interval time.Duration
+}
+
+// SyntheticProbeResult is the outcome of a synthetic probe
+type SyntheticProbeResult struct {
+	Status enum.ServiceHealthStatusCode
+	// Detail explains a status other than healthy
+	Detail string
+	// Latency is the time the response took, or the time waited for it
+	Latency time.Duration
+	// CheckedAt is the time the probe started
+	CheckedAt time.Time
+}
+
+// ISyntheticProber sends small inference requests to detect endpoints that pass their health checks
+// but do not serve inference requests
+type ISyntheticProber interface {
+	// Configure enables the synthetic probe of an endpoint, configuring it again resets its last result
+	Configure(endpointID string, config SyntheticProbeConfig)
+	Remove(endpointID string)
+	// Probe probes an endpoint unless it was probed less than its interval ago, in which case the last
+	// result is returned. It returns false when the endpoint has no synthetic probe. A probe interrupted
+	// by ctx is unknown and not kept as the last result.
+	Probe(ctx context.Context, endpointID string) (SyntheticProbeResult, bool)
+}
+
+type syntheticProber struct {
+	runner InferenceRunner
+	clock  Clock
+
+	mu     sync.Mutex
+	probes map[string]*syntheticProbe
+}
+
+// syntheticProbe is the state of the synthetic probe of an endpoint
+type syntheticProbe struct {
+	config SyntheticProbeConfig
+	// mu serializes the probes of the endpoint so that concurrent callers share a result
+	mu   sync.Mutex
+	last *SyntheticProbeResult
+}
+
+// NewSyntheticProber returns a prober sending requests with runner, a nil clock uses the real clock
+func NewSyntheticProber(runner InferenceRunner, clock Clock) ISyntheticProber {
+	if clock == nil {
+		clock = realClock{}
+	}
+	return &syntheticProber{runner: runner, clock: clock, probes: map[string]*syntheticProbe{}}
+}
+
+func (p *syntheticProber) Configure(endpointID string, config SyntheticProbeConfig) {
+	if config.Mode == "" {
+		config.Mode = SyntheticChat
+	}
+	if config.Prompt == "" {
+		config.Prompt = DefaultSyntheticPrompt
+	}
+	if config.MaxTokens <= 0 {
+		config.MaxTokens = DefaultSyntheticMaxTokens
+	}
+	if config.LatencyBudget <= 0 {
+		config.LatencyBudget = DefaultLatencyBudget
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultSyntheticInterval
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.probes[endpointID] = &syntheticProbe{config: config}
+}
+
+func (p *syntheticProber) Remove(endpointID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.probes, endpointID)
+}
+
+func (p *syntheticProber) Probe(ctx context.Context, endpointID string) (SyntheticProbeResult, bool) {
+	p.mu.Lock()
+	probe, ok := p.probes[endpointID]
+	p.mu.Unlock()
+	if !ok {
+		return SyntheticProbeResult{}, false
+	}
+
+	probe.mu.Lock()
+	defer probe.mu.Unlock()
+	now := p.clock.Now()
+	if probe.last != nil && now.Sub(probe.last.CheckedAt) < probe.config.Interval {
+		return *probe.last, true
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	result := p.run(ctx, probe.config, now)
+	if ctx.Err() == nil {
+		probe.last = &result
+	}
+	return result, true
+}
+
+// run sends the request of a probe within the latency budget
+func (p *syntheticProber) run(ctx context.Context, config SyntheticProbeConfig, start time.Time) SyntheticProbeResult {
+	result := SyntheticProbeResult{Status: enum.CriticalStatusCode, CheckedAt: start}
+	budgetCtx, cancel := context.WithTimeout(ctx, config.LatencyBudget)
+	defer cancel()
+	msg := p.send(budgetCtx, config)
+	result.Latency = p.clock.Now().Sub(start)
+
+	switch {
+	case ctx.Err() != nil:
+		// the caller gave up, this says nothing about the endpoint
+		result.Status = enum.UnknownStatusCode
+		result.Detail = fmt.Sprintf("probe interrupted: %v", ctx.Err())
+	case errors.Is(budgetCtx.Err(), context.DeadlineExceeded):
+		result.Latency = config.LatencyBudget
+		result.Detail = fmt.Sprintf("no response within the latency budget of %s", config.LatencyBudget)
+	case msg != "":
+		result.Detail = msg
+	default:
+		result.Status = enum.HealthyStatusCode
+	}
+	return result
+}
+
+// send sends the inference request of a probe and returns what is wrong with the response, if anything
+func (p *syntheticProber) send(ctx context.Context, config SyntheticProbeConfig) string {
+	if config.Mode == SyntheticCompletion {
+		resp, err := p.runner.Completion(ctx, openai.CompletionRequest{
+			Model:     config.Model,
+			Prompt:    config.Prompt,
+			MaxTokens: config.MaxTokens,
+		}, config.Engine)
+		if err != nil {
+			return inferenceErrorDetail(err)
+		}
+		if len(resp.Choices) == 0 {
+			return "completion response has no choices"
+		}
+		return generatedTextDetail(resp.Choices[0].Text, resp.Choices[0].FinishReason)
+	}
+
+	resp, err := p.runner.ChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model:     config.Model,
+		Messages:  []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: config.Prompt}},
+		MaxTokens: config.MaxTokens,
+	}, config.Engine)
+	if err != nil {
+		return inferenceErrorDetail(err)
+	}
+	if len(resp.Choices) == 0 {
+		return "chat completion response has no choices"
+	}
+	return generatedTextDetail(resp.Choices[0].Message.Content, string(resp.Choices[0].FinishReason))
+}
+
+// generatedTextDetail tells whether the text generated for a probe is well formed, a model may legitimately
+// generate nothing but then says why it stopped
+func generatedTextDetail(text string, finishReason string) string {
+	if !utf8.ValidString(text) {
+		return "response is not valid UTF-8"
+	}
+	if text == "" && finishReason == "" {
+		return "response has neither text nor finish reason"
+	}
+	return ""
+}
+
+func inferenceErrorDetail(err *e.Error) string {
+	switch {
+	case err.Msg != "" && err.InternalErr != nil:
+		return fmt.Sprintf("inference request failed: %s: %v", err.Msg, err.InternalErr)
+	case err.InternalErr != nil:
+		return fmt.Sprintf("inference request failed: %v", err.InternalErr)
+	default:
+		return "inference request failed: " + err.Msg
+	}
+}
@@ -0,0 +1,195 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	e "github.com/nutanix-core/nai-api/common/errors"
+	"github.com/nutanix-core/nai-api/iep/constants/enum"
+	"github.com/nutanix-core/nai-api/iep/internal/client"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// fakeRunner answers inference requests with the given functions and records the requests it receives
+type fakeRunner struct {
+	mu           sync.Mutex
+	completions  []openai.CompletionRequest
+	chats        []openai.ChatCompletionRequest
+	engines      []enum.Engine
+	completion   func(context.Context) (openai.CompletionResponse, *e.Error)
+	chat         func(context.Context) (openai.ChatCompletionResponse, *e.Error)
+	requestCount int
+}
+
+func (r *fakeRunner) Completion(ctx context.Context, completionRequest openai.CompletionRequest, engine enum.Engine) (openai.CompletionResponse, *e.Error) {
+	r.mu.Lock()
+	r.completions = append(r.completions, completionRequest)
+	r.engines = append(r.engines, engine)
+	r.requestCount++
+	r.mu.Unlock()
+	return r.completion(ctx)
+}
+
+func (r *fakeRunner) ChatCompletion(ctx context.Context, chatRequest openai.ChatCompletionRequest, engine enum.Engine) (openai.ChatCompletionResponse, *e.Error) {
+	r.mu.Lock()
+	r.chats = append(r.chats, chatRequest)
+	r.engines = append(r.engines, engine)
+	r.requestCount++
+	r.mu.Unlock()
+	return r.chat(ctx)
+}
+
+func (r *fakeRunner) requests() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.requestCount
+}
+
+var _ = Describe("Test synthetic inference probes", func() {
+
+	var (
+		clock  *fakeClock
+		runner *fakeRunner
+		prober client.ISyntheticProber
+		ctx    = context.Background()
+	)
+
+	chatResponse := func(content string, finishReason openai.FinishReason) func(context.Context) (openai.ChatCompletionResponse, *e.Error) {
+		return func(context.Context) (openai.ChatCompletionResponse, *e.Error) {
+			return openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{
+				Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content},
+				FinishReason: finishReason,
+			}}}, nil
+		}
+	}
+
+	BeforeEach(func() {
+		clock = newFakeClock()
+		runner = &fakeRunner{chat: chatResponse("OK", openai.FinishReasonLength)}
+		prober = client.NewSyntheticProber(runner, clock)
+	})
+
+	It("should send a small chat request and cache the result for the interval", func() {
+		prober.Configure("endpoint-1", client.SyntheticProbeConfig{Model: "llama", Engine: enum.VLLMEngine, Interval: time.Minute})
+		result, ok := prober.Probe(ctx, "endpoint-1")
+		Expect(ok).To(BeTrue())
+		Expect(result.Status).To(Equal(enum.HealthyStatusCode))
+		Expect(result.Detail).To(BeEmpty())
+		Expect(result.CheckedAt).To(Equal(clock.Now()))
+
+		Expect(runner.chats).To(HaveLen(1))
+		Expect(runner.chats[0].Model).To(Equal("llama"))
+		Expect(runner.chats[0].MaxTokens).To(Equal(client.DefaultSyntheticMaxTokens))
+		Expect(runner.chats[0].Messages).To(Equal([]openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: client.DefaultSyntheticPrompt}}))
+		Expect(runner.engines).To(Equal([]enum.Engine{enum.VLLMEngine}))
+
+		clock.Advance(30 * time.Second)
+		_, _ = prober.Probe(ctx, "endpoint-1")
+		Expect(runner.requests()).To(Equal(1))
+		clock.Advance(30 * time.Second)
+		_, _ = prober.Probe(ctx, "endpoint-1")
+		Expect(runner.requests()).To(Equal(2))
+	})
+
+	It("should send completion requests with the configured prompt", func() {
+		runner.completion = func(context.Context) (openai.CompletionResponse, *e.Error) {
+			return openai.CompletionResponse{Choices: []openai.CompletionChoice{{Text: " Paris", FinishReason: "length"}}}, nil
+		}
+		prober.Configure("endpoint-1", client.SyntheticProbeConfig{
+			Model:     "llama",
+			Engine:    enum.TGIEngine,
+			Mode:      client.SyntheticCompletion,
+			Prompt:    "The capital of France is",
+			MaxTokens: 2,
+		})
+		result, _ := prober.Probe(ctx, "endpoint-1")
+		Expect(result.Status).To(Equal(enum.HealthyStatusCode))
+		Expect(runner.completions).To(HaveLen(1))
+		Expect(runner.completions[0].Prompt).To(Equal("The capital of France is"))
+		Expect(runner.completions[0].MaxTokens).To(Equal(2))
+	})
+
+	It("should report failed inference requests as critical", func() {
+		runner.chat = func(context.Context) (openai.ChatCompletionResponse, *e.Error) {
+			return openai.ChatCompletionResponse{}, &e.Error{Type: e.GenericError, InternalErr: errors.New("connection reset"), Msg: "Chat completion inference failed"}
+		}
+		prober.Configure("endpoint-1", client.SyntheticProbeConfig{Model: "llama"})
+		result, _ := prober.Probe(ctx, "endpoint-1")
+		Expect(result.Status).To(Equal(enum.CriticalStatusCode))
+		Expect(result.Detail).To(Equal("inference request failed: Chat completion inference failed: connection reset"))
+	})
+
+	It("should report malformed responses as critical", func() {
+		prober.Configure("endpoint-1", client.SyntheticProbeConfig{Model: "llama", Interval: time.Second})
+
+		runner.chat = func(context.Context) (openai.ChatCompletionResponse, *e.Error) {
+			return openai.ChatCompletionResponse{}, nil
+		}
+		result, _ := prober.Probe(ctx, "endpoint-1")
+		Expect(result.Status).To(Equal(enum.CriticalStatusCode))
+		Expect(result.Detail).To(Equal("chat completion response has no choices"))
+
+		clock.Advance(time.Second)
+		runner.chat = chatResponse("", "")
+		result, _ = prober.Probe(ctx, "endpoint-1")
+		Expect(result.Detail).To(Equal("response has neither text nor finish reason"))
+
+		clock.Advance(time.Second)
+		runner.chat = chatResponse("\xff\xfe", openai.FinishReasonStop)
+		result, _ = prober.Probe(ctx, "endpoint-1")
+		Expect(result.Detail).To(Equal("response is not valid UTF-8"))
+	})
+
+	It("should cancel requests exceeding the latency budget", func() {
+		runner.chat = func(ctx context.Context) (openai.ChatCompletionResponse, *e.Error) {
+			<-ctx.Done()
+			return openai.ChatCompletionResponse{}, &e.Error{Type: e.GenericError, InternalErr: ctx.Err()}
+		}
+		prober.Configure("endpoint-1", client.SyntheticProbeConfig{Model: "llama", LatencyBudget: 50 * time.Millisecond, Interval: time.Minute})
+
+		result, _ := prober.Probe(ctx, "endpoint-1")
+		Expect(result.Status).To(Equal(enum.CriticalStatusCode))
+		Expect(result.Detail).To(Equal("no response within the latency budget of 50ms"))
+		Expect(result.Latency).To(Equal(50 * time.Millisecond))
+
+		runner.chat = chatResponse("OK", openai.FinishReasonStop)
+		clock.Advance(time.Minute)
+		result, _ = prober.Probe(ctx, "endpoint-1")
+		Expect(result.Status).To(Equal(enum.HealthyStatusCode))
+		Expect(runner.requests()).To(Equal(2))
+	})
+
+	It("should not keep probes interrupted by the caller", func() {
+		prober.Configure("endpoint-1", client.SyntheticProbeConfig{Model: "llama", Interval: time.Minute})
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		runner.chat = func(ctx context.Context) (openai.ChatCompletionResponse, *e.Error) {
+			return openai.ChatCompletionResponse{}, &e.Error{Type: e.GenericError, InternalErr: ctx.Err()}
+		}
+		result, _ := prober.Probe(cancelled, "endpoint-1")
+		Expect(result.Status).To(Equal(enum.UnknownStatusCode))
+		Expect(result.Detail).To(Equal("probe interrupted: context canceled"))
+
+		runner.chat = chatResponse("OK", openai.FinishReasonStop)
+		result, _ = prober.Probe(ctx, "endpoint-1")
+		Expect(result.Status).To(Equal(enum.HealthyStatusCode))
+		Expect(runner.requests()).To(Equal(2))
+	})
+
+	It("should only probe configured endpoints", func() {
+		_, ok := prober.Probe(ctx, "endpoint-1")
+		Expect(ok).To(BeFalse())
+
+		prober.Configure("endpoint-1", client.SyntheticProbeConfig{Model: "llama"})
+		_, ok = prober.Probe(ctx, "endpoint-1")
+		Expect(ok).To(BeTrue())
+		prober.Remove("endpoint-1")
+		_, ok = prober.Probe(ctx, "endpoint-1")
+		Expect(ok).To(BeFalse())
+		Expect(runner.requests()).To(Equal(1))
+	})
+})
@@ -0,0 +1,38 @@
+package model
+
+import "time"
+
+// AlertWebhookReceiver is a webhook receiver notified when endpoints change health status.
+// The secret is kept to sign the webhooks and is never returned by the alert routes.
+type AlertWebhookReceiver struct {
+	BaseModel
+	Name                  string `gorm:"not null"`
+	URL                   string `gorm:"not null"`
+	Secret                string
+	EndpointIDs           []string `gorm:"serializer:json"`
+	OwnerIDs              []string `gorm:"serializer:json"`
+	Severities            []string `gorm:"serializer:json"`
+	DedupWindowSeconds    int64
+	RepeatIntervalSeconds int64
+}
+
+// AlertDelivery is an entry of the delivery log of the alerts sent to webhook receivers.
+// Entries are listed most recent first and the oldest are deleted past the size of the log.
+type AlertDelivery struct {
+	BaseModel
+	ReceiverID  string `gorm:"type:uuid;not null;index:idx_alert_deliveries_receiver"`
+	EndpointID  string `gorm:"type:uuid;not null;index:idx_alert_deliveries_endpoint"`
+	OwnerID     string
+	Severity    string `gorm:"not null"`
+	FromState   string
+	ToState     string    `gorm:"not null"`
+	EventAt     time.Time `gorm:"not null"`
+	Detail      string
+	Repeat      bool
+	State       string `gorm:"not null;index:idx_alert_deliveries_state"`
+	Attempts    int
+	StatusCode  int
+	Error       string
+	LoggedAt    time.Time `gorm:"not null;index:idx_alert_deliveries_logged"`
+	CompletedAt *time.Time
+}
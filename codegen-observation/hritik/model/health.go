@@ -0,0 +1,18 @@
+package model
+
+import (
+	"time"
+
+	"github.com/nutanix-core/nai-api/iep/constants/enum"
+)
+
+// EndpointHealthObservation is the health of an endpoint observed by the health monitor at a point in time.
+// Observations are listed per endpoint in time order and deleted once older than the health retention.
+type EndpointHealthObservation struct {
+	BaseModel
+	EndpointID string                       `gorm:"type:uuid;not null;index:idx_endpoint_health_observations_endpoint_observed,priority:1"`
+	ObservedAt time.Time                    `gorm:"not null;index:idx_endpoint_health_observations_endpoint_observed,priority:2;index:idx_endpoint_health_observations_observed"`
+	Status     enum.ServiceHealthStatusCode `gorm:"not null"`
+	LatencyMs  int64
+	Detail     string
+}
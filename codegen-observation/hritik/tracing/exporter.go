@@ -0,0 +1,181 @@
+package tracing
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	// ExporterNone drops every span
+	ExporterNone = "none"
+	// ExporterStdout writes spans to the standard output
+	ExporterStdout = "stdout"
+	// ExporterFile appends spans to a file
+	ExporterFile = "file"
+
+	instrumentationScope = "github.com/nutanix-core/nai-api/iep/internal/tracing"
+)
+
+// Exporter receives every ended and sampled span
+type Exporter interface {
+	ExportSpan(SpanData) error
+}
+
+// ExporterConfig selects and configures an exporter
+type ExporterConfig struct {
+	// Mode is one of ExporterNone, ExporterStdout or ExporterFile
+	Mode string
+	// Path is the file spans are appended to in file mode
+	Path string
+}
+
+// NewExporter returns the exporter described by config, the returned closer releases its resources
+func NewExporter(config ExporterConfig) (Exporter, io.Closer, error) {
+	switch config.Mode {
+	case "", ExporterNone:
+		return nil, nopCloser{}, nil
+	case ExporterStdout:
+		return NewWriterExporter(os.Stdout), nopCloser{}, nil
+	case ExporterFile:
+		file, err := os.OpenFile(config.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open trace file %s: %w", config.Path, err)
+		}
+		return NewWriterExporter(file), file, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported trace exporter %q, should be one of none/stdout/file", config.Mode)
+	}
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// writerExporter writes every span as one line of OTLP JSON, the format read by the
+// OpenTelemetry collector otlpjsonfile receiver
+type writerExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterExporter returns an exporter writing spans to w as OTLP JSON lines
+func NewWriterExporter(w io.Writer) Exporter {
+	return &writerExporter{w: w}
+}
+
+func (e *writerExporter) ExportSpan(data SpanData) error {
+	line, err := json.Marshal(toOTLP(data))
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.w.Write(append(line, '\n'))
+	return err
+}
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	// Code is 0 for unset and 2 for error
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+func toOTLP(data SpanData) otlpTraces {
+	span := otlpSpan{
+		TraceID:           hex.EncodeToString(data.SpanContext.TraceID[:]),
+		SpanID:            hex.EncodeToString(data.SpanContext.SpanID[:]),
+		Name:              data.Name,
+		Kind:              int(data.Kind),
+		StartTimeUnixNano: strconv.FormatInt(data.StartTime.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(data.EndTime.UnixNano(), 10),
+		Attributes:        toOTLPAttributes(data.Attributes),
+	}
+	if data.ParentSpanID != [8]byte{} {
+		span.ParentSpanID = hex.EncodeToString(data.ParentSpanID[:])
+	}
+	if data.Error {
+		span.Status = otlpStatus{Code: 2, Message: data.StatusMessage}
+	}
+	return otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: toOTLPAttributes(map[string]any{"service.name": data.ServiceName})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: instrumentationScope}, Spans: []otlpSpan{span}}},
+	}}}
+}
+
+// toOTLPAttributes converts attributes to OTLP key values sorted by key
+func toOTLPAttributes(attributes map[string]any) []otlpKeyValue {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	values := make([]otlpKeyValue, 0, len(keys))
+	for _, key := range keys {
+		var value map[string]any
+		switch v := attributes[key].(type) {
+		case bool:
+			value = map[string]any{"boolValue": v}
+		case int:
+			// OTLP JSON encodes 64 bit integers as strings
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		case string:
+			value = map[string]any{"stringValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		values = append(values, otlpKeyValue{Key: key, Value: value})
+	}
+	return values
+}
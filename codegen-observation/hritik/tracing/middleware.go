@@ -0,0 +1,39 @@
+package tracing
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware starts a server span for every request, continuing the trace of an incoming
+// traceparent header. Handlers find the span in c.Request.Context().
+func Middleware(tracer *Tracer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if sc, ok := Extract(c.Request.Header); ok {
+			ctx = ContextWithRemoteSpanContext(ctx, sc)
+		}
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched route"
+		}
+		ctx, span := tracer.Start(ctx, fmt.Sprintf("%s %s", c.Request.Method, route), SpanKindServer)
+		defer span.End()
+		span.SetAttribute("http.request.method", c.Request.Method)
+		span.SetAttribute("http.route", route)
+
+		c.Request = c.Request.WithContext(ctx)
+		// let callers correlate their request with the recorded trace
+		c.Header(TraceparentHeader, span.SpanContext().Traceparent())
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttribute("http.response.status_code", status)
+		if status >= http.StatusInternalServerError {
+			span.SetError(http.StatusText(status))
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package tracing_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nutanix-core/nai-api/iep/internal/tracing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Test tracing middleware", func() {
+
+	var (
+		exporter *recordingExporter
+		router   *gin.Engine
+	)
+
+	BeforeEach(func() {
+		gin.SetMode(gin.TestMode)
+		exporter = &recordingExporter{}
+		router = gin.New()
+		router.Use(tracing.Middleware(tracing.NewTracer("nai-api", exporter)))
+		router.GET("/v1/endpoints/:endpoint_id", func(c *gin.Context) {
+			span := tracing.SpanFromContext(c.Request.Context())
+			Expect(span).ToNot(BeNil())
+			span.SetAttribute("inference.endpoint_id", c.Param("endpoint_id"))
+			c.Status(http.StatusOK)
+		})
+		router.GET("/v1/fail", func(c *gin.Context) {
+			c.Status(http.StatusInternalServerError)
+		})
+	})
+
+	It("should continue the incoming trace", func() {
+		req := httptest.NewRequest(http.MethodGet, "/v1/endpoints/endpoint-1", nil)
+		req.Header.Set(tracing.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(exporter.spans).To(HaveLen(1))
+		span := exporter.spans[0]
+		Expect(span.Name).To(Equal("GET /v1/endpoints/:endpoint_id"))
+		Expect(span.Kind).To(Equal(tracing.SpanKindServer))
+		Expect(span.SpanContext.Traceparent()).To(HavePrefix("00-4bf92f3577b34da6a3ce929d0e0e4736-"))
+		Expect(span.Attributes).To(HaveKeyWithValue("inference.endpoint_id", "endpoint-1"))
+		Expect(span.Attributes).To(HaveKeyWithValue("http.response.status_code", http.StatusOK))
+		Expect(w.Header().Get(tracing.TraceparentHeader)).To(Equal(span.SpanContext.Traceparent()))
+	})
+
+	It("should mark server errors", func() {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/fail", nil))
+
+		Expect(exporter.spans).To(HaveLen(1))
+		Expect(exporter.spans[0].Error).To(BeTrue())
+	})
+})
@@ -0,0 +1,291 @@
+// Package tracing contains code for W3C trace context propagation and span recording
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceparentHeader is the W3C trace context header
+const TraceparentHeader = "traceparent"
+
+// ErrInvalidTraceparent is returned when a traceparent header cannot be parsed
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+// SpanKind tells whether a span handles an incoming request, makes an outgoing one or is internal
+type SpanKind int
+
+const (
+	// SpanKindInternal is an operation within the service
+	SpanKindInternal SpanKind = iota + 1
+	// SpanKindServer handles an incoming request
+	SpanKindServer
+	// SpanKindClient makes an outgoing request
+	SpanKindClient
+)
+
+// SpanContext identifies a span across process boundaries
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether both trace and span ids are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent formats the span context as a version 00 traceparent header value
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), flags)
+}
+
+// ParseTraceparent parses a traceparent header value
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	// future versions may append fields, version 00 has exactly four
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, ErrInvalidTraceparent
+	}
+	if _, err := hex.Decode(make([]byte, 1), []byte(parts[0])); err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, ErrInvalidTraceparent
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	flags := make([]byte, 1)
+	if _, err := hex.Decode(flags, []byte(parts[3])); err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	if strings.ToLower(value) != value || !sc.IsValid() {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	sc.Sampled = flags[0]&0x01 == 0x01
+	return sc, nil
+}
+
+// Extract returns the span context carried by the traceparent header, if any
+func Extract(header http.Header) (SpanContext, bool) {
+	sc, err := ParseTraceparent(header.Get(TraceparentHeader))
+	return sc, err == nil
+}
+
+// Inject sets the traceparent header from the span context of ctx, it does nothing when ctx carries none
+func Inject(ctx context.Context, header http.Header) {
+	if sc, ok := SpanContextFromContext(ctx); ok {
+		header.Set(TraceparentHeader, sc.Traceparent())
+	}
+}
+
+// NewTransport returns a round tripper injecting the traceparent of the request context into the requests of
+// clients not going through client.Do, such as SDK clients. A nil base uses http.DefaultTransport.
+func NewTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{base: base}
+}
+
+type transport struct {
+	base http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if _, ok := SpanContextFromContext(req.Context()); ok {
+		// round trippers must not modify the request they are given
+		req = req.Clone(req.Context())
+		Inject(req.Context(), req.Header)
+	}
+	return t.base.RoundTrip(req)
+}
+
+type contextKey int
+
+const (
+	spanKey contextKey = iota
+	remoteKey
+)
+
+// ContextWithSpan returns a copy of ctx carrying span
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey, span)
+}
+
+// ContextWithRemoteSpanContext returns a copy of ctx carrying a span context received from another service
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey, sc)
+}
+
+// SpanFromContext returns the span carried by ctx, or nil. Every Span method is safe to call on nil.
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanKey).(*Span)
+	return span
+}
+
+// SpanContextFromContext returns the span context of the current span, or the remote one received by the service
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.data.SpanContext, true
+	}
+	if ctx == nil {
+		return SpanContext{}, false
+	}
+	sc, ok := ctx.Value(remoteKey).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// Tracer creates spans and hands them to an exporter once they end
+type Tracer struct {
+	serviceName string
+	exporter    Exporter
+}
+
+// NewTracer returns a tracer exporting the spans of serviceName, a nil exporter drops every span
+func NewTracer(serviceName string, exporter Exporter) *Tracer {
+	return &Tracer{serviceName: serviceName, exporter: exporter}
+}
+
+// Start starts a span that is a child of the span or remote span context carried by ctx,
+// and returns a copy of ctx carrying the new span
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	data := SpanData{Name: name, Kind: kind, StartTime: time.Now(), Attributes: map[string]any{}}
+	if parent, ok := SpanContextFromContext(ctx); ok {
+		data.SpanContext.TraceID = parent.TraceID
+		data.SpanContext.Sampled = parent.Sampled
+		data.ParentSpanID = parent.SpanID
+	} else {
+		data.SpanContext.TraceID = newTraceID()
+		data.SpanContext.Sampled = true
+	}
+	data.SpanContext.SpanID = newSpanID()
+	span := &Span{tracer: t, data: data}
+	return ContextWithSpan(ctx, span), span
+}
+
+// StartSpan starts a child of the span carried by ctx with the tracer of that span. Nothing is traced
+// when ctx carries no span, ctx is returned as is with a nil span.
+func StartSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name, kind)
+}
+
+// Span is an operation being traced
+type Span struct {
+	tracer *Tracer
+	mu     sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+// SpanData is the immutable record of an ended span handed to exporters
+type SpanData struct {
+	SpanContext   SpanContext
+	ParentSpanID  [8]byte
+	Name          string
+	Kind          SpanKind
+	StartTime     time.Time
+	EndTime       time.Time
+	Attributes    map[string]any
+	Error         bool
+	StatusMessage string
+	ServiceName   string
+}
+
+// SpanContext returns the span context of the span
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+// SetAttribute records a key value pair on the span, values should be strings, booleans or numbers
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes[key] = value
+}
+
+// SetError marks the span as failed with msg
+func (s *Span) SetError(msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Error = true
+	s.data.StatusMessage = msg
+}
+
+// End records the end time of the span and exports it, calling End more than once has no effect
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.EndTime = time.Now()
+	data := s.data
+	data.ServiceName = s.tracer.serviceName
+	data.Attributes = make(map[string]any, len(s.data.Attributes))
+	for key, value := range s.data.Attributes {
+		data.Attributes[key] = value
+	}
+	s.mu.Unlock()
+
+	if s.tracer.exporter != nil && data.SpanContext.Sampled {
+		// a failing exporter must never fail the traced operation
+		_ = s.tracer.exporter.ExportSpan(data)
+	}
+}
+
+func newTraceID() [16]byte {
+	var id [16]byte
+	for id == [16]byte{} {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() [8]byte {
+	var id [8]byte
+	for id == [8]byte{} {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
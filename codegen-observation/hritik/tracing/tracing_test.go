@@ -0,0 +1,192 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/nutanix-core/nai-api/iep/internal/tracing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// recordingExporter keeps every exported span in memory
+type recordingExporter struct {
+	spans []tracing.SpanData
+}
+
+func (e *recordingExporter) ExportSpan(data tracing.SpanData) error {
+	e.spans = append(e.spans, data)
+	return nil
+}
+
+var _ = Describe("Test tracing", func() {
+
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	Context("Test ParseTraceparent function", func() {
+		It("should parse a valid traceparent", func() {
+			sc, err := tracing.ParseTraceparent(traceparent)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sc.IsValid()).To(BeTrue())
+			Expect(sc.Sampled).To(BeTrue())
+			Expect(sc.Traceparent()).To(Equal(traceparent))
+		})
+
+		It("should reject invalid traceparents", func() {
+			for _, value := range []string{
+				"",
+				"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+				"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+				"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+				"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+				"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+				"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+				"00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01",
+			} {
+				_, err := tracing.ParseTraceparent(value)
+				Expect(err).To(MatchError(tracing.ErrInvalidTraceparent), value)
+			}
+		})
+	})
+
+	Context("Test Tracer", func() {
+		It("should continue a remote trace and propagate child spans", func() {
+			exporter := &recordingExporter{}
+			tracer := tracing.NewTracer("nai-api", exporter)
+			header := http.Header{}
+			header.Set(tracing.TraceparentHeader, traceparent)
+			remote, ok := tracing.Extract(header)
+			Expect(ok).To(BeTrue())
+
+			ctx, parent := tracer.Start(tracing.ContextWithRemoteSpanContext(context.Background(), remote), "parent", tracing.SpanKindServer)
+			_, child := tracer.Start(ctx, "child", tracing.SpanKindClient)
+			child.SetAttribute("inference.stream", true)
+			child.SetError("engine unavailable")
+			child.End()
+			parent.End()
+			parent.End()
+
+			Expect(exporter.spans).To(HaveLen(2))
+			childData, parentData := exporter.spans[0], exporter.spans[1]
+			Expect(parentData.SpanContext.TraceID).To(Equal(remote.TraceID))
+			Expect(parentData.ParentSpanID).To(Equal(remote.SpanID))
+			Expect(childData.SpanContext.TraceID).To(Equal(remote.TraceID))
+			Expect(childData.ParentSpanID).To(Equal(parentData.SpanContext.SpanID))
+			Expect(childData.Attributes).To(HaveKeyWithValue("inference.stream", true))
+			Expect(childData.Error).To(BeTrue())
+			Expect(childData.ServiceName).To(Equal("nai-api"))
+
+			injected := http.Header{}
+			tracing.Inject(ctx, injected)
+			Expect(injected.Get(tracing.TraceparentHeader)).To(Equal(parent.SpanContext().Traceparent()))
+		})
+
+		It("should start a new sampled trace without parent", func() {
+			tracer := tracing.NewTracer("nai-api", nil)
+			_, span := tracer.Start(context.Background(), "root", tracing.SpanKindInternal)
+			Expect(span.SpanContext().IsValid()).To(BeTrue())
+			Expect(span.SpanContext().Sampled).To(BeTrue())
+			span.End()
+		})
+
+		It("should not export unsampled spans", func() {
+			exporter := &recordingExporter{}
+			tracer := tracing.NewTracer("nai-api", exporter)
+			remote, err := tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+			Expect(err).ToNot(HaveOccurred())
+			_, span := tracer.Start(tracing.ContextWithRemoteSpanContext(context.Background(), remote), "unsampled", tracing.SpanKindServer)
+			span.End()
+			Expect(exporter.spans).To(BeEmpty())
+		})
+
+		It("should start children of the span of the context with its tracer", func() {
+			exporter := &recordingExporter{}
+			ctx, parent := tracing.NewTracer("nai-api", exporter).Start(context.Background(), "parent", tracing.SpanKindServer)
+			childCtx, child := tracing.StartSpan(ctx, "child", tracing.SpanKindInternal)
+			Expect(tracing.SpanFromContext(childCtx)).To(BeIdenticalTo(child))
+			child.End()
+			parent.End()
+
+			Expect(exporter.spans).To(HaveLen(2))
+			Expect(exporter.spans[0].Name).To(Equal("child"))
+			Expect(exporter.spans[0].Kind).To(Equal(tracing.SpanKindInternal))
+			Expect(exporter.spans[0].ParentSpanID).To(Equal(parent.SpanContext().SpanID))
+
+			// without span nothing is traced
+			untracedCtx, untraced := tracing.StartSpan(context.Background(), "child", tracing.SpanKindInternal)
+			Expect(untraced).To(BeNil())
+			Expect(untracedCtx).To(Equal(context.Background()))
+		})
+
+		It("should accept calls on a nil span", func() {
+			span := tracing.SpanFromContext(context.Background())
+			Expect(span).To(BeNil())
+			span.SetAttribute("key", "value")
+			span.SetError("error")
+			span.End()
+			Expect(span.SpanContext().IsValid()).To(BeFalse())
+		})
+	})
+
+	Context("Test NewTransport function", func() {
+		It("should inject the traceparent of the request context", func() {
+			var received []string
+			testServer := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				received = append(received, r.Header.Get(tracing.TraceparentHeader))
+			}))
+			defer testServer.Close()
+			httpClient := &http.Client{Transport: tracing.NewTransport(nil)}
+			ctx, span := tracing.NewTracer("nai-api", nil).Start(context.Background(), "parent", tracing.SpanKindInternal)
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, testServer.URL, nil)
+			Expect(err).ToNot(HaveOccurred())
+			resp, err := httpClient.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			resp.Body.Close() //nolint:errcheck
+			// the request of the caller is left untouched
+			Expect(req.Header.Get(tracing.TraceparentHeader)).To(BeEmpty())
+
+			req, err = http.NewRequest(http.MethodGet, testServer.URL, nil)
+			Expect(err).ToNot(HaveOccurred())
+			resp, err = httpClient.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			resp.Body.Close() //nolint:errcheck
+
+			Expect(received).To(Equal([]string{span.SpanContext().Traceparent(), ""}))
+		})
+	})
+
+	Context("Test writer exporter", func() {
+		It("should write OTLP JSON lines", func() {
+			var buf bytes.Buffer
+			tracer := tracing.NewTracer("nai-api", tracing.NewWriterExporter(&buf))
+			_, span := tracer.Start(context.Background(), "GET /v1/endpoints", tracing.SpanKindServer)
+			span.SetAttribute("http.response.status_code", 200)
+			span.End()
+
+			var traces map[string]any
+			Expect(json.Unmarshal(buf.Bytes(), &traces)).To(Succeed())
+			Expect(buf.String()).To(ContainSubstring(`"name":"GET /v1/endpoints"`))
+			Expect(buf.String()).To(ContainSubstring(`{"key":"http.response.status_code","value":{"intValue":"200"}}`))
+			Expect(buf.String()).To(ContainSubstring(`{"key":"service.name","value":{"stringValue":"nai-api"}}`))
+		})
+
+		It("should reject unknown exporter modes", func() {
+			_, _, err := tracing.NewExporter(tracing.ExporterConfig{Mode: "jaeger"})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should append spans to a file", func() {
+			path := GinkgoT().TempDir() + "/traces.jsonl"
+			exporter, closer, err := tracing.NewExporter(tracing.ExporterConfig{Mode: tracing.ExporterFile, Path: path})
+			Expect(err).ToNot(HaveOccurred())
+			_, span := tracing.NewTracer("nai-api", exporter).Start(context.Background(), "span", tracing.SpanKindInternal)
+			span.End()
+			Expect(closer.Close()).To(Succeed())
+			Expect(path).To(BeAnExistingFile())
+		})
+	})
+})
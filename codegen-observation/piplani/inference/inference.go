@@ -16,6 +16,7 @@ import (
 	"github.com/nutanix-core/nai-api/iep/constants/enum"
 	"github.com/nutanix-core/nai-api/iep/internal/middleware"
 	"github.com/nutanix-core/nai-api/iep/internal/service"
+	"github.com/nutanix-core/nai-api/iep/internal/tracing"
 	openai "github.com/sashabaranov/go-openai"
 )
 
@@ -69,30 +70,34 @@ func (ic *InferenceController) Completion(c *gin.Context) {
 	before := c.GetTime(constants.PreValidationTimestamp)
 	completionsBody, exists := c.Get("completionsRequest")
 	if !exists {
-		ic.metrics.RecordInferenceMetrics(c, "", "invalid", time.Since(before).Milliseconds())
+		ic.recordMetrics(c, "", "invalid", before)
 		response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ic.logger, SuccMsg: succMsg, Err: &e.Error{Type: e.NotFoundError, Msg: errMsg + ": failed retrieving request body", Log: "failed retrieving request body"}})
 		return
 	}
 
 	completionRequest, ok := completionsBody.(openai.CompletionRequest)
 	if !ok {
-		ic.metrics.RecordInferenceMetrics(c, "", "invalid", time.Since(before).Milliseconds())
+		ic.recordMetrics(c, "", "invalid", before)
 		response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ic.logger, SuccMsg: succMsg, Err: &e.Error{Type: e.BindingError, Msg: errMsg, Log: "failed parsing request body"}})
 		return
 	}
 
 	engine, err := ic.getEngineParam(c, errMsg)
 	if err != nil {
-		ic.metrics.RecordInferenceMetrics(c, "", "invalid", time.Since(before).Milliseconds())
+		ic.recordMetrics(c, "", "invalid", before)
 		response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ic.logger, Err: err})
 		return
 	}
+	ic.traceInference(c, completionRequest.Model, engine, completionRequest.Stream)
 
 	if completionRequest.Stream {
 		responseID := uuid.New().String()
+		span := ic.startServiceSpan(c, "InferenceService.CompletionStream", completionRequest.Model, engine)
+		defer span.End()
 		stream, err := ic.inferenceService.CompletionStream(completionRequest, engine)
 		if err != nil {
-			ic.metrics.RecordInferenceMetrics(c, completionRequest.Model, "failure", time.Since(before).Milliseconds())
+			span.SetError(errMsg)
+			ic.recordMetrics(c, completionRequest.Model, "failure", before)
 			response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ic.logger, Err: err})
 			return
 		}
@@ -105,13 +110,14 @@ func (ic *InferenceController) Completion(c *gin.Context) {
 				_, _ = w.Write([]byte("data: [DONE]\n\n"))
 
 				ic.logger.Debug(fmt.Sprintf("Stream finished for request on endpoint: %s", completionRequest.Model))
-				ic.metrics.RecordInferenceMetrics(c, completionRequest.Model, "success", time.Since(before).Milliseconds())
+				ic.recordMetrics(c, completionRequest.Model, "success", before)
 				return false // Here setting false to exit the streaming process
 			}
 
 			if err != nil {
 				ic.logger.Debug(fmt.Sprintf("Stream error for request on endpoint: %s; error %v", completionRequest.Model, err))
-				ic.metrics.RecordInferenceMetrics(c, completionRequest.Model, "failure", time.Since(before).Milliseconds())
+				span.SetError(errMsg)
+				ic.recordMetrics(c, completionRequest.Model, "failure", before)
 				return false // Here setting false to exit the streaming process
 			}
 
@@ -126,9 +132,14 @@ func (ic *InferenceController) Completion(c *gin.Context) {
 		return
 	}
 
+	span := ic.startServiceSpan(c, "InferenceService.Completion", completionRequest.Model, engine)
 	completionResponse, err := ic.inferenceService.Completion(completionRequest, engine)
 	if err != nil {
-		ic.metrics.RecordInferenceMetrics(c, completionRequest.Model, "failure", time.Since(before).Milliseconds())
+		span.SetError(errMsg)
+	}
+	span.End()
+	if err != nil {
+		ic.recordMetrics(c, completionRequest.Model, "failure", before)
 		response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ic.logger, Err: err})
 		return
 	}
@@ -137,7 +148,7 @@ func (ic *InferenceController) Completion(c *gin.Context) {
 		completionResponse.ID = uuid.New().String()
 	}
 	completionResponse.Model = completionRequest.Model
-	ic.metrics.RecordInferenceMetrics(c, completionRequest.Model, "success", time.Since(before).Milliseconds())
+	ic.recordMetrics(c, completionRequest.Model, "success", before)
 
 	response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ic.logger, SuccMsg: succMsg, Data: completionResponse, Raw: true})
 }
@@ -169,30 +180,34 @@ func (ic *InferenceController) ChatCompletion(c *gin.Context) {
 	before := c.GetTime(constants.PreValidationTimestamp)
 	chatBody, exists := c.Get("chatRequest")
 	if !exists {
-		ic.metrics.RecordInferenceMetrics(c, "", "invalid", time.Since(before).Milliseconds())
+		ic.recordMetrics(c, "", "invalid", before)
 		response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ic.logger, SuccMsg: succMsg, Err: &e.Error{Type: e.NotFoundError, Msg: errMsg + ": failed retrieving request body", Log: "failed retrieving request body"}})
 		return
 	}
 
 	chatRequest, ok := chatBody.(openai.ChatCompletionRequest)
 	if !ok {
-		ic.metrics.RecordInferenceMetrics(c, "", "invalid", time.Since(before).Milliseconds())
+		ic.recordMetrics(c, "", "invalid", before)
 		response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ic.logger, SuccMsg: succMsg, Err: &e.Error{Type: e.BindingError, Msg: errMsg, Log: "failed parsing request body"}})
 		return
 	}
 
 	engine, err := ic.getEngineParam(c, errMsg)
 	if err != nil {
-		ic.metrics.RecordInferenceMetrics(c, "", "invalid", time.Since(before).Milliseconds())
+		ic.recordMetrics(c, "", "invalid", before)
 		response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ic.logger, Err: err})
 		return
 	}
+	ic.traceInference(c, chatRequest.Model, engine, chatRequest.Stream)
 
 	if chatRequest.Stream {
 		responseID := uuid.New().String()
+		span := ic.startServiceSpan(c, "InferenceService.ChatCompletionStream", chatRequest.Model, engine)
+		defer span.End()
 		stream, err := ic.inferenceService.ChatCompletionStream(chatRequest, engine)
 		if err != nil {
-			ic.metrics.RecordInferenceMetrics(c, chatRequest.Model, "failure", time.Since(before).Milliseconds())
+			span.SetError(errMsg)
+			ic.recordMetrics(c, chatRequest.Model, "failure", before)
 			response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ic.logger, Err: err})
 			return
 		}
@@ -205,13 +220,14 @@ func (ic *InferenceController) ChatCompletion(c *gin.Context) {
 				_, _ = w.Write([]byte("data: [DONE]\n\n"))
 
 				ic.logger.Debug(fmt.Sprintf("Stream finished for request on endpoint: %s", chatRequest.Model))
-				ic.metrics.RecordInferenceMetrics(c, chatRequest.Model, "success", time.Since(before).Milliseconds())
+				ic.recordMetrics(c, chatRequest.Model, "success", before)
 				return false // Here setting false to exit the streaming process
 			}
 
 			if err != nil {
 				ic.logger.Debug(fmt.Sprintf("Stream error for request on endpoint: %s; error %v", chatRequest.Model, err))
-				ic.metrics.RecordInferenceMetrics(c, chatRequest.Model, "failure", time.Since(before).Milliseconds())
+				span.SetError(errMsg)
+				ic.recordMetrics(c, chatRequest.Model, "failure", before)
 				return false // Here setting false to exit the streaming process
 			}
 
@@ -226,9 +242,14 @@ func (ic *InferenceController) ChatCompletion(c *gin.Context) {
 		return
 	}
 
+	span := ic.startServiceSpan(c, "InferenceService.ChatCompletion", chatRequest.Model, engine)
 	chatResponse, err := ic.inferenceService.ChatCompletion(chatRequest, engine)
 	if err != nil {
-		ic.metrics.RecordInferenceMetrics(c, chatRequest.Model, "failure", time.Since(before).Milliseconds())
+		span.SetError(errMsg)
+	}
+	span.End()
+	if err != nil {
+		ic.recordMetrics(c, chatRequest.Model, "failure", before)
 		response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ic.logger, Err: err})
 		return
 	}
@@ -238,11 +259,33 @@ func (ic *InferenceController) ChatCompletion(c *gin.Context) {
 	}
 
 	chatResponse.Model = chatRequest.Model
-	ic.metrics.RecordInferenceMetrics(c, chatRequest.Model, "success", time.Since(before).Milliseconds())
+	ic.recordMetrics(c, chatRequest.Model, "success", before)
 
 	response.HTTPResponse(response.HTTPResponseOptions{Ctx: c, Logger: ic.logger, SuccMsg: succMsg, Data: chatResponse, Raw: true})
 }
 
+// traceInference records the inference details on the span of the request
+func (ic *InferenceController) traceInference(c *gin.Context, endpointID string, engine enum.Engine, stream bool) {
+	span := tracing.SpanFromContext(c.Request.Context())
+	span.SetAttribute("inference.endpoint_id", endpointID)
+	span.SetAttribute("inference.engine", string(engine))
+	span.SetAttribute("inference.stream", stream)
+}
+
+// startServiceSpan starts the span of an inference service call as a child of the span of the request
+func (ic *InferenceController) startServiceSpan(c *gin.Context, name string, endpointID string, engine enum.Engine) *tracing.Span {
+	_, span := tracing.StartSpan(c.Request.Context(), name, tracing.SpanKindInternal)
+	span.SetAttribute("inference.endpoint_id", endpointID)
+	span.SetAttribute("inference.engine", string(engine))
+	return span
+}
+
+// recordMetrics records the inference metrics and the inference status on the span of the request
+func (ic *InferenceController) recordMetrics(c *gin.Context, endpointID string, status string, before time.Time) {
+	ic.metrics.RecordInferenceMetrics(c, endpointID, status, time.Since(before).Milliseconds())
+	tracing.SpanFromContext(c.Request.Context()).SetAttribute("inference.status", status)
+}
+
 func (ic *InferenceController) streamResponse(w io.Writer, response any) {
 	// As response is received from OpenAI golang SDK, we can not manipulate the error on JSON Encode
 	_, _ = w.Write([]byte("data: "))
@@ -1,6 +1,7 @@
 package v1_test
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -14,6 +15,7 @@ import (
 	"github.com/nutanix-core/nai-api/iep/constants"
 	"github.com/nutanix-core/nai-api/iep/constants/enum"
 	"github.com/nutanix-core/nai-api/iep/internal/service"
+	"github.com/nutanix-core/nai-api/iep/internal/tracing"
 	mock_middleware "github.com/nutanix-core/nai-api/iep/mocks/middleware"
 	mock_service "github.com/nutanix-core/nai-api/iep/mocks/service"
 	. "github.com/onsi/ginkgo/v2"
@@ -632,8 +634,104 @@ var _ = Describe("Test Inference Controller", func() {
 			Expect(validContext.Writer.Status()).Should(Equal(http.StatusInternalServerError))
 		})
 	})
+
+	Context("Test Inference Tracing", func() {
+
+		var (
+			exporter   *inferenceSpanExporter
+			serverSpan *tracing.Span
+		)
+
+		// traceContext starts the span of the request like the tracing middleware
+		traceContext := func(c *gin.Context) {
+			exporter = &inferenceSpanExporter{}
+			var ctx context.Context
+			ctx, serverSpan = tracing.NewTracer("nai-api", exporter).Start(c.Request.Context(), "POST /v1/completions", tracing.SpanKindServer)
+			c.Request = c.Request.WithContext(ctx)
+		}
+
+		It("Completion inference service call traced", func() {
+			completionRequest := getCompletionRequest(false)
+			validContext, router := getContext("api/v1/completions", correctCompletionRequest, "POST")
+			traceContext(validContext)
+			validContext.Set("completionsRequest", completionRequest)
+			validContext.Set(constants.PreValidationTimestamp, before)
+			validContext.Set("endpointEngine", enum.VLLMEngine)
+			mockInstrumentationService.EXPECT().RecordInferenceMetrics(validContext, completionRequest.Model, "success", gomock.Any())
+			mockInferenceService.EXPECT().Completion(completionRequest, engine).Return(openai.CompletionResponse{}, nil).Times(1)
+			testInferenceController := v1.NewInferenceController(router.Group("/v1"), logger, mockInstrumentationService, mockInferenceService, mockInferenceValidator)
+			testInferenceController.Completion(validContext)
+			serverSpan.End()
+
+			Expect(validContext.Writer.Status()).Should(Equal(http.StatusOK))
+			Expect(exporter.spans).To(HaveLen(2))
+			serviceSpan, requestSpan := exporter.spans[0], exporter.spans[1]
+			Expect(serviceSpan.Name).To(Equal("InferenceService.Completion"))
+			Expect(serviceSpan.Kind).To(Equal(tracing.SpanKindInternal))
+			Expect(serviceSpan.ParentSpanID).To(Equal(requestSpan.SpanContext.SpanID))
+			Expect(serviceSpan.Attributes).To(HaveKeyWithValue("inference.endpoint_id", completionRequest.Model))
+			Expect(serviceSpan.Attributes).To(HaveKeyWithValue("inference.engine", string(engine)))
+			Expect(serviceSpan.Error).To(BeFalse())
+			Expect(requestSpan.Attributes).To(HaveKeyWithValue("inference.endpoint_id", completionRequest.Model))
+			Expect(requestSpan.Attributes).To(HaveKeyWithValue("inference.engine", string(engine)))
+			Expect(requestSpan.Attributes).To(HaveKeyWithValue("inference.stream", false))
+			Expect(requestSpan.Attributes).To(HaveKeyWithValue("inference.status", "success"))
+		})
+
+		It("Chat Completion service error marks the service span", func() {
+			chatCompletionRequest := getChatRequest(false)
+			validContext, router := getContext("api/v1/chat/completions", "", "POST")
+			traceContext(validContext)
+			validContext.Set("chatRequest", chatCompletionRequest)
+			validContext.Set(constants.PreValidationTimestamp, before)
+			validContext.Set("endpointEngine", enum.VLLMEngine)
+			mockInstrumentationService.EXPECT().RecordInferenceMetrics(validContext, chatCompletionRequest.Model, "failure", gomock.Any())
+			mockInferenceService.EXPECT().ChatCompletion(chatCompletionRequest, engine).Return(openai.ChatCompletionResponse{}, &e.Error{Type: e.GenericError, InternalErr: errors.New("Chat Completion inference failed")}).Times(1)
+			testInferenceController := v1.NewInferenceController(router.Group("/v1"), logger, mockInstrumentationService, mockInferenceService, mockInferenceValidator)
+			testInferenceController.ChatCompletion(validContext)
+			serverSpan.End()
+
+			Expect(exporter.spans).To(HaveLen(2))
+			serviceSpan, requestSpan := exporter.spans[0], exporter.spans[1]
+			Expect(serviceSpan.Name).To(Equal("InferenceService.ChatCompletion"))
+			Expect(serviceSpan.ParentSpanID).To(Equal(requestSpan.SpanContext.SpanID))
+			Expect(serviceSpan.Error).To(BeTrue())
+			Expect(serviceSpan.StatusMessage).To(Equal("Chat completions request failed"))
+			Expect(requestSpan.Attributes).To(HaveKeyWithValue("inference.status", "failure"))
+		})
+
+		It("Chat Completion Stream service error marks the service span", func() {
+			chatCompletionRequest := getChatRequest(true)
+			validContext, router := getContext("api/v1/chat/completions", "", "POST")
+			traceContext(validContext)
+			validContext.Set("chatRequest", chatCompletionRequest)
+			validContext.Set(constants.PreValidationTimestamp, before)
+			validContext.Set("endpointEngine", enum.VLLMEngine)
+			mockInstrumentationService.EXPECT().RecordInferenceMetrics(validContext, chatCompletionRequest.Model, "failure", gomock.Any())
+			mockInferenceService.EXPECT().ChatCompletionStream(chatCompletionRequest, engine).Return(nil, &e.Error{Type: e.GenericError, InternalErr: errors.New("Chat Completion inference failed")}).Times(1)
+			testInferenceController := v1.NewInferenceController(router.Group("/v1"), logger, mockInstrumentationService, mockInferenceService, mockInferenceValidator)
+			testInferenceController.ChatCompletion(validContext)
+			serverSpan.End()
+
+			Expect(exporter.spans).To(HaveLen(2))
+			serviceSpan, requestSpan := exporter.spans[0], exporter.spans[1]
+			Expect(serviceSpan.Name).To(Equal("InferenceService.ChatCompletionStream"))
+			Expect(serviceSpan.Error).To(BeTrue())
+			Expect(requestSpan.Attributes).To(HaveKeyWithValue("inference.stream", true))
+		})
+	})
 })
 
+// inferenceSpanExporter keeps every exported span in memory
+type inferenceSpanExporter struct {
+	spans []tracing.SpanData
+}
+
+func (se *inferenceSpanExporter) ExportSpan(data tracing.SpanData) error {
+	se.spans = append(se.spans, data)
+	return nil
+}
+
 func setupOpenAITestServer() (server *ServerTest, teardown func()) {
 	server = NewTestServer()
 	ts := server.OpenAITestServer()